@@ -3,10 +3,18 @@ package auth
 import (
 	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -78,3 +86,127 @@ func handleConn(t *testing.T, conn net.Conn) {
 		conn.Write([]byte("world\n"))
 	}
 }
+
+// newTestCert issues a certificate for commonName, signed by parent or self-signed when parent is nil
+func newTestCert(t *testing.T, commonName string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	issuer, signer := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	assert.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// newTestConnPair returns the two ends of a loopback tcp connection
+func newTestConnPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	serverConn, err := ln.Accept()
+	assert.Nil(t, err)
+
+	return clientConn, serverConn
+}
+
+func TestMutualTLSHandshake(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil)
+	serverCert := newTestCert(t, "server.novarpc", &ca)
+	clientCert := newTestCert(t, "client.novarpc", &ca)
+
+	cp := x509.NewCertPool()
+	cp.AddCert(ca.Leaf)
+
+	serverAuth := NewServerTLSAuth(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    cp,
+	})
+	clientAuth := NewClientTLSAuth(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      cp,
+	})
+
+	clientConn, serverConn := newTestConnPair(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	serverInfo := make(chan AuthInfo, 1)
+	go func() {
+		_, info, err := serverAuth.ServerHandshake(serverConn)
+		assert.Nil(t, err)
+		serverInfo <- info
+	}()
+
+	_, info, err := clientAuth.ClientHandshake(context.Background(), "server.novarpc:8000", clientConn)
+	assert.Nil(t, err)
+	assert.Equal(t, "tls", info.AuthType())
+	assert.Equal(t, "server.novarpc", info.(TLSInfo).State.PeerCertificates[0].Subject.CommonName)
+
+	// 服务端可以拿到客户端证书中的身份
+	tlsInfo := (<-serverInfo).(TLSInfo)
+	assert.Equal(t, "client.novarpc", tlsInfo.State.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestMutualTLSRejectsClientWithoutCert(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil)
+	serverCert := newTestCert(t, "server.novarpc", &ca)
+
+	cp := x509.NewCertPool()
+	cp.AddCert(ca.Leaf)
+
+	serverAuth := NewServerTLSAuth(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    cp,
+	})
+	clientAuth := NewClientTLSAuth(&tls.Config{RootCAs: cp})
+
+	clientConn, serverConn := newTestConnPair(t)
+	defer clientConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		_, _, err := serverAuth.ServerHandshake(serverConn)
+		serverConn.Close()
+		serverErr <- err
+	}()
+
+	clientAuth.ClientHandshake(context.Background(), "server.novarpc", clientConn)
+	assert.NotNil(t, <-serverErr)
+}
+
+func TestPeer(t *testing.T) {
+	_, ok := GetPeer(context.Background())
+	assert.False(t, ok)
+
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8000}
+	ctx := WithPeer(context.Background(), &Peer{Addr: addr, AuthInfo: TLSInfo{}})
+	p, ok := GetPeer(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, addr, p.Addr)
+	assert.Equal(t, "tls", p.AuthInfo.AuthType())
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"context"
+	"net"
+)
+
+type peerKey struct{}
+
+// Peer describes the other side of a connection
+type Peer struct {
+	Addr     net.Addr // remote address
+	AuthInfo AuthInfo // nil if the connection is not authenticated
+}
+
+// WithPeer creates a new context with the peer attached
+func WithPeer(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// GetPeer returns the peer of the connection the request arrived on
+func GetPeer(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
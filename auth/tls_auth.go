@@ -24,6 +24,27 @@ func (t *tlsAuth) AuthType() string {
 	return "tls"
 }
 
+// TLSInfo is the AuthInfo of a TLS connection,
+// under mutual TLS State.PeerCertificates identify the client
+type TLSInfo struct {
+	State tls.ConnectionState
+}
+
+// AuthType returns the protocol name
+func (t TLSInfo) AuthType() string {
+	return "tls"
+}
+
+// NewClientTLSAuth instantiates client-side authentication information with a tls config
+func NewClientTLSAuth(config *tls.Config) TransportAuth {
+	return &tlsAuth{config: cloneTLSConfig(config)}
+}
+
+// NewServerTLSAuth generates server-side authentication information with a tls config
+func NewServerTLSAuth(config *tls.Config) TransportAuth {
+	return &tlsAuth{config: cloneTLSConfig(config)}
+}
+
 // NewClientTLSAuthFromFile instantiates client-side authentication information
 // with certificates and service names
 func NewClientTLSAuthFromFile(certFile, serverName string) (TransportAuth, error) {
@@ -55,6 +76,56 @@ func NewServerTLSAuthFromFile(certFile, keyFile string) (TransportAuth, error) {
 	return &tlsAuth{config: conf}, nil
 }
 
+// NewClientMutualTLSAuthFromFile instantiates client-side authentication information for mutual TLS,
+// the client presents its own certificate and verifies the server with the CA certificate
+func NewClientMutualTLSAuthFromFile(certFile, keyFile, caFile, serverName string) (TransportAuth, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, codes.ClientCertFailError
+	}
+	cp, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		ServerName:   serverName,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      cp,
+	}
+	return &tlsAuth{config: conf}, nil
+}
+
+// NewServerMutualTLSAuthFromFile generates server-side authentication information for mutual TLS,
+// clients must present a certificate signed by the CA certificate
+func NewServerMutualTLSAuthFromFile(certFile, keyFile, caFile string) (TransportAuth, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, codes.ClientCertFailError
+	}
+	cp, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    cp,
+	}
+	return &tlsAuth{config: conf}, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cp := x509.NewCertPool()
+	if !cp.AppendCertsFromPEM(ca) {
+		return nil, codes.ClientCertFailError
+	}
+	return cp, nil
+}
+
 // ClientHandshake implements the client's handshake
 func (t *tlsAuth) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, AuthInfo, error) {
 	// Prevent ServerName from being contaminated when you use a different endpoint
@@ -81,7 +152,7 @@ func (t *tlsAuth) ClientHandshake(ctx context.Context, authority string, rawConn
 		return nil, nil, ctx.Err()
 	}
 
-	return WrapConn(rawConn, conn), &tlsAuth{state: conn.ConnectionState()}, nil
+	return WrapConn(rawConn, conn), TLSInfo{State: conn.ConnectionState()}, nil
 }
 
 // the ServerHandshake implements the server handshake
@@ -90,7 +161,7 @@ func (t *tlsAuth) ServerHandshake(rawConn net.Conn) (net.Conn, AuthInfo, error)
 	if err := conn.Handshake(); err != nil {
 		return nil, nil, err
 	}
-	return WrapConn(rawConn, conn), &tlsAuth{state: conn.ConnectionState()}, nil
+	return WrapConn(rawConn, conn), TLSInfo{State: conn.ConnectionState()}, nil
 }
 
 func cloneTLSConfig(cfg *tls.Config) *tls.Config {
@@ -0,0 +1,275 @@
+// Package breaker implements a circuit breaker for downstream nodes. A node whose calls keep failing is
+// opened and skipped by the selector, after OpenTimeout a few probe calls are let through (half-open),
+// the node is closed again when the probes succeed.
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/selector"
+)
+
+// State is the state of the circuit breaker of a node
+type State int32
+
+const (
+	StateClosed   State = iota // 正常调用
+	StateOpen                  // 熔断，不再向节点发送请求
+	StateHalfOpen              // 放行少量探测请求，成功后恢复
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker keeps a circuit breaker per downstream node, or per node and method
+type CircuitBreaker struct {
+	opts     *Options
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	changes  []change // 等待通知的状态变化，释放锁之后再回调
+}
+
+type change struct {
+	key      string
+	from, to State
+}
+
+// breaker 一个节点（或者节点的一个方法）的熔断状态
+type breaker struct {
+	state     State
+	failures  int       // 连续失败次数
+	openedAt  time.Time // 进入熔断的时间
+	probes    int       // 半开状态下已经放行的探测请求数
+	successes int       // 半开状态下成功的探测请求数
+}
+
+// New creates a CircuitBreaker
+func New(opt ...Option) *CircuitBreaker {
+	opts := &Options{
+		failureThreshold: 5,
+		openTimeout:      5 * time.Second,
+		maxProbes:        1,
+		failureCodes: []uint32{
+			codes.ServerInternalErrorCode,
+			codes.DeadlineExceededErrorCode,
+			codes.ResourceExhaustedErrorCode,
+			codes.UnavailableErrorCode,
+		},
+	}
+	for _, o := range opt {
+		o(opts)
+	}
+
+	return &CircuitBreaker{
+		opts:     opts,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// Key returns the key of the circuit breaker of a call to addr, path is the service path of the call,
+// e.g. /helloworld.Greeter/SayHello, and is a part of the key only if the breaker is per method
+func (cb *CircuitBreaker) Key(addr string, path string) string {
+	if cb.opts.perMethod {
+		return addr + path
+	}
+	return addr
+}
+
+// Ready reports whether calls may be sent with key, it does not use a probe of a half-open breaker
+func (cb *CircuitBreaker) Ready(key string) bool {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	b, ok := cb.breakers[key]
+	if !ok {
+		return true
+	}
+	cb.expire(key, b)
+
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return b.probes < cb.opts.maxProbes
+	}
+	return true
+}
+
+// Allow reports whether a call may be sent with key, a half-open breaker lets through at most maxProbes calls
+func (cb *CircuitBreaker) Allow(key string) bool {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	b, ok := cb.breakers[key]
+	if !ok {
+		return true
+	}
+	cb.expire(key, b)
+
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probes >= cb.opts.maxProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// Record records the outcome of a call allowed with key, business errors count as successes
+// and cancelled calls are ignored
+func (cb *CircuitBreaker) Record(key string, err error) {
+	failed := cb.isFailure(err)
+	cancelled := codes.Code(err) == codes.CanceledErrorCode
+
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	b, ok := cb.breakers[key]
+	if !ok {
+		if !failed {
+			return
+		}
+		b = &breaker{}
+		cb.breakers[key] = b
+	}
+
+	// 取消的探测请求不能说明节点是否恢复，让出名额
+	if cancelled {
+		if b.state == StateHalfOpen && b.probes > 0 {
+			b.probes--
+		}
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= cb.opts.failureThreshold {
+			cb.transit(key, b, StateOpen)
+		}
+
+	case StateHalfOpen:
+		// 任何一个探测请求失败都重新熔断
+		if failed {
+			cb.transit(key, b, StateOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= cb.opts.maxProbes {
+			cb.transit(key, b, StateClosed)
+		}
+	}
+}
+
+// State returns the state of the circuit breaker of key
+func (cb *CircuitBreaker) State(key string) State {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	b, ok := cb.breakers[key]
+	if !ok {
+		return StateClosed
+	}
+	cb.expire(key, b)
+	return b.state
+}
+
+// States returns the states of all the circuit breakers that have seen failures, e.g. for metrics
+func (cb *CircuitBreaker) States() map[string]State {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	states := make(map[string]State, len(cb.breakers))
+	for key, b := range cb.breakers {
+		cb.expire(key, b)
+		states[key] = b.state
+	}
+	return states
+}
+
+// expire 熔断时间到了之后进入半开状态
+func (cb *CircuitBreaker) expire(key string, b *breaker) {
+	if b.state == StateOpen && time.Since(b.openedAt) >= cb.opts.openTimeout {
+		cb.transit(key, b, StateHalfOpen)
+	}
+}
+
+func (cb *CircuitBreaker) transit(key string, b *breaker, to State) {
+	from := b.state
+	b.state = to
+	b.failures, b.probes, b.successes = 0, 0, 0
+	if to == StateOpen {
+		b.openedAt = time.Now()
+	}
+
+	// 恢复后不再保存状态
+	if to == StateClosed {
+		delete(cb.breakers, key)
+	}
+
+	if cb.opts.onStateChange != nil {
+		cb.changes = append(cb.changes, change{key, from, to})
+	}
+}
+
+// unlock 释放锁之后通知状态变化，回调中可以再调用 CircuitBreaker
+func (cb *CircuitBreaker) unlock() {
+	changes := cb.changes
+	cb.changes = nil
+	cb.mu.Unlock()
+
+	for _, c := range changes {
+		cb.opts.onStateChange(c.key, c.from, c.to)
+	}
+}
+
+// Filter returns a selector.NodeFilter that skips the nodes whose breakers are open for calls of path,
+// e.g. /helloworld.Greeter/SayHello, see client.WithCircuitBreaker
+func (cb *CircuitBreaker) Filter(path string) selector.NodeFilter {
+	return &filter{cb: cb, path: path}
+}
+
+type filter struct {
+	cb   *CircuitBreaker
+	path string
+}
+
+func (f *filter) Ready(serviceName string, addr string) bool {
+	return f.cb.Ready(f.cb.Key(addr, f.path))
+}
+
+func (f *filter) Admit(serviceName string, addr string) bool {
+	return f.cb.Allow(f.cb.Key(addr, f.path))
+}
+
+func (cb *CircuitBreaker) isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	code := codes.Code(err)
+	for _, c := range cb.opts.failureCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/selector"
+)
+
+var unavailable = codes.NewFrameworkError(codes.UnavailableErrorCode, "connection refused")
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []string
+	cb := New(WithFailureThreshold(3), WithOpenTimeout(50*time.Millisecond), WithMaxProbes(2),
+		WithStateChange(func(key string, from, to State) {
+			changes = append(changes, key+" "+from.String()+"->"+to.String())
+		}))
+	key := cb.Key("127.0.0.1:8000", "/helloworld.Greeter/SayHello")
+	assert.Equal(t, "127.0.0.1:8000", key)
+
+	// 业务错误和成功的调用重置连续失败次数
+	cb.Record(key, unavailable)
+	cb.Record(key, unavailable)
+	cb.Record(key, codes.New(1001, "not found"))
+	cb.Record(key, unavailable)
+	cb.Record(key, unavailable)
+	assert.Equal(t, StateClosed, cb.State(key))
+
+	// 连续失败后熔断
+	cb.Record(key, unavailable)
+	assert.Equal(t, StateOpen, cb.State(key))
+	assert.False(t, cb.Ready(key))
+	assert.False(t, cb.Allow(key))
+	assert.Equal(t, map[string]State{key: StateOpen}, cb.States())
+
+	// 熔断时间到了之后放行 maxProbes 个探测请求
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, StateHalfOpen, cb.State(key))
+	assert.True(t, cb.Ready(key))
+	assert.True(t, cb.Allow(key))
+	assert.True(t, cb.Allow(key))
+	assert.False(t, cb.Ready(key))
+	assert.False(t, cb.Allow(key))
+
+	// 探测请求被取消时让出名额
+	cb.Record(key, context.Canceled)
+	assert.True(t, cb.Allow(key))
+
+	// 探测请求全部成功后恢复
+	cb.Record(key, nil)
+	cb.Record(key, nil)
+	assert.Equal(t, StateClosed, cb.State(key))
+	assert.Empty(t, cb.States())
+
+	assert.Equal(t, []string{
+		key + " closed->open",
+		key + " open->half-open",
+		key + " half-open->closed",
+	}, changes)
+}
+
+func TestCircuitBreakerProbeFailure(t *testing.T) {
+	cb := New(WithFailureThreshold(1), WithOpenTimeout(20*time.Millisecond), WithPerMethod(true))
+	key := cb.Key("127.0.0.1:8000", "/helloworld.Greeter/SayHello")
+	assert.Equal(t, "127.0.0.1:8000/helloworld.Greeter/SayHello", key)
+
+	// 不统计的错误不熔断
+	cb.Record(key, errors.New("marshal failed"))
+	assert.Equal(t, StateClosed, cb.State(key))
+
+	cb.Record(key, codes.NewFrameworkError(codes.DeadlineExceededErrorCode, "timeout"))
+	assert.Equal(t, StateOpen, cb.State(key))
+
+	// 探测失败后重新熔断
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, cb.Allow(key))
+	cb.Record(key, unavailable)
+	assert.Equal(t, StateOpen, cb.State(key))
+
+	// 其他方法不受影响
+	assert.True(t, cb.Ready(cb.Key("127.0.0.1:8000", "/helloworld.Greeter/Other")))
+}
+
+func TestFilter(t *testing.T) {
+	cb := New(WithFailureThreshold(1))
+	cb.Record("127.0.0.1:8000", unavailable)
+
+	nodes := []*selector.Node{{Address: "127.0.0.1:8000"}, {Address: "127.0.0.1:8001"}}
+	ctx := selector.WithFilter(context.Background(), cb.Filter("/helloworld.Greeter/SayHello"))
+
+	// 熔断的节点在负载均衡时被跳过
+	assert.Equal(t, nodes[1:], selector.FilterNodes(ctx, "helloworld.Greeter", nodes))
+	assert.False(t, selector.Admit(ctx, "helloworld.Greeter", "127.0.0.1:8000"))
+	assert.True(t, selector.Admit(ctx, "helloworld.Greeter", "127.0.0.1:8001"))
+}
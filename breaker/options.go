@@ -0,0 +1,58 @@
+package breaker
+
+import "time"
+
+// Options defines the circuit breaker parameters
+type Options struct {
+	failureThreshold int                              // 连续失败多少次后熔断
+	openTimeout      time.Duration                    // 熔断多久之后进入半开状态
+	maxProbes        int                              // 半开状态下放行的探测请求数，全部成功后恢复
+	perMethod        bool                             // 按节点的每个方法分别熔断
+	failureCodes     []uint32                         // 按失败统计的错误码，业务错误不算失败
+	onStateChange    func(key string, from, to State) // 状态变化时回调，例如上报监控
+}
+
+type Option func(*Options)
+
+// WithFailureThreshold set the number of consecutive failures that opens the breaker, 5 by default
+func WithFailureThreshold(threshold int) Option {
+	return func(o *Options) {
+		o.failureThreshold = threshold
+	}
+}
+
+// WithOpenTimeout set how long the breaker stays open before letting probes through, 5s by default
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.openTimeout = timeout
+	}
+}
+
+// WithMaxProbes set the number of probe calls let through by a half-open breaker, 1 by default
+func WithMaxProbes(probes int) Option {
+	return func(o *Options) {
+		o.maxProbes = probes
+	}
+}
+
+// WithPerMethod keeps a breaker for every method of a node instead of one for the node
+func WithPerMethod(perMethod bool) Option {
+	return func(o *Options) {
+		o.perMethod = perMethod
+	}
+}
+
+// WithFailureCodes set the codes counted as failures, by default transport errors, timeouts,
+// server internal errors and ResourceExhausted
+func WithFailureCodes(failureCodes ...uint32) Option {
+	return func(o *Options) {
+		o.failureCodes = failureCodes
+	}
+}
+
+// WithStateChange set the func called when the state of a breaker changes, e.g. to export metrics
+func WithStateChange(f func(key string, from, to State)) Option {
+	return func(o *Options) {
+		o.onStateChange = f
+	}
+}
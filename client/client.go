@@ -3,8 +3,10 @@ package client
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/xing-you-ji/novarpc/auth"
 	"github.com/xing-you-ji/novarpc/codec"
 	"github.com/xing-you-ji/novarpc/codes"
 	"github.com/xing-you-ji/novarpc/interceptor"
@@ -20,6 +22,10 @@ import (
 type Client interface {
 	// 调用下游服务
 	Invoke(ctx context.Context, req, rsp interface{}, path string, opts ...Option) error
+	// 单向调用下游服务，请求发出即返回，不等待响应
+	InvokeOneWay(ctx context.Context, req interface{}, path string, opts ...Option) error
+	// 建立流式调用
+	NewStream(ctx context.Context, desc *stream.Desc, path string, opts ...Option) (*stream.ClientStream, error)
 }
 
 // DefaultClient 是一个全局的 Client（为了减少创建/销毁 客户端的损耗）
@@ -29,7 +35,8 @@ var DefaultClient = NewDefaultClient()
 var NewDefaultClient = func() *defaultClient {
 	return &defaultClient{
 		opts: &Options{
-			protocol: "proto",
+			protocol:          "proto",
+			compressThreshold: codec.DefaultCompressThreshold,
 		},
 	}
 }
@@ -55,12 +62,32 @@ func (c *defaultClient) Call(ctx context.Context, servicePath string, req interf
 	return nil
 }
 
+// CallOneWay 通过 InvokeOneWay 单向调用下游服务，请求体使用 msgpack 序列化
+func (c *defaultClient) CallOneWay(ctx context.Context, servicePath string, req interface{}, opts ...Option) error {
+
+	callOpts := make([]Option, 0, len(opts)+1)
+	callOpts = append(callOpts, opts...)
+	callOpts = append(callOpts, WithSerializationType(codec.MsgPack))
+
+	return c.InvokeOneWay(ctx, req, servicePath, callOpts...)
+}
+
 func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path string, opts ...Option) error {
 
-	// 选项模式执行 opts
-	for _, o := range opts {
-		o(c.opts)
-	}
+	// 每次调用复制一份参数，并发调用之间互不影响
+	return c.withOptions(opts...).call(ctx, req, rsp, path)
+}
+
+// InvokeOneWay 发出请求后立即返回，服务端执行方法但不回复，适用于审计、监控上报等不关心结果的调用
+func (c *defaultClient) InvokeOneWay(ctx context.Context, req interface{}, path string, opts ...Option) error {
+
+	c = c.withOptions(opts...)
+	c.opts.oneWay = true
+
+	return c.call(ctx, req, nil, path)
+}
+
+func (c *defaultClient) call(ctx context.Context, req, rsp interface{}, path string) error {
 
 	// 如果设置了超时时间，那么就使用 context.WithTimeout
 	if c.opts.timeout > 0 {
@@ -69,6 +96,13 @@ func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path s
 		defer cancel()
 	}
 
+	if c.opts.hashKey != "" {
+		ctx = selector.WithHashKey(ctx, c.opts.hashKey)
+	}
+	for _, f := range c.opts.nodeFilters {
+		ctx = selector.WithFilter(ctx, f)
+	}
+
 	newCtx, clientStream := stream.NewClientStream(ctx)
 
 	// 解析 path 得到 serviceName, method
@@ -89,6 +123,53 @@ func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path s
 	return interceptor.ClientIntercept(newCtx, req, rsp, c.opts.interceptors, c.invoke)
 }
 
+// hedgingPolicy 返回本次调用的对冲策略，方法的策略优先于服务的策略，不幂等的方法不对冲
+func (c *defaultClient) hedgingPolicy() *HedgingPolicy {
+	path := fmt.Sprintf("/%s/%s", c.opts.serviceName, c.opts.method)
+	if !c.opts.idempotent && !c.opts.idempotentMethods[path] {
+		return nil
+	}
+
+	if policy, ok := c.opts.hedgingPolicies[path]; ok {
+		return policy
+	}
+	if policy, ok := c.opts.hedgingPolicies[c.opts.serviceName]; ok {
+		return policy
+	}
+	return c.opts.hedgingPolicy
+}
+
+// retryPolicy 返回本次调用的重试策略，方法的策略优先于服务的策略，不幂等的方法不重试
+func (c *defaultClient) retryPolicy() *RetryPolicy {
+	path := fmt.Sprintf("/%s/%s", c.opts.serviceName, c.opts.method)
+	if !c.opts.idempotent && !c.opts.idempotentMethods[path] {
+		return nil
+	}
+
+	if policy, ok := c.opts.retryPolicies[path]; ok {
+		return policy
+	}
+	if policy, ok := c.opts.retryPolicies[c.opts.serviceName]; ok {
+		return policy
+	}
+	return c.opts.retryPolicy
+}
+
+// withOptions 返回一个使用本次调用参数的 client
+func (c *defaultClient) withOptions(opts ...Option) *defaultClient {
+	callOpts := *c.opts
+	callOpts.interceptors = append([]interceptor.ClientInterceptor(nil), c.opts.interceptors...)
+	callOpts.perRPCAuth = append([]auth.PerRPCAuth(nil), c.opts.perRPCAuth...)
+	callOpts.nodeFilters = append([]selector.NodeFilter(nil), c.opts.nodeFilters...)
+
+	// 选项模式执行 opts
+	for _, o := range opts {
+		o(&callOpts)
+	}
+
+	return &defaultClient{opts: &callOpts}
+}
+
 // invoke 真正调用下游服务
 func (c *defaultClient) invoke(ctx context.Context, req, rsp interface{}) error {
 
@@ -100,6 +181,50 @@ func (c *defaultClient) invoke(ctx context.Context, req, rsp interface{}) error
 		return codes.NewFrameworkError(codes.ClientMsgErrorCode, "request marshal failed ...")
 	}
 
+	call := func(ctx context.Context) (*protocol.Response, error) {
+		return c.roundTrip(ctx, payload)
+	}
+
+	// 幂等的方法按对冲策略或者重试策略多次发送，只使用一个响应
+	var response *protocol.Response
+	if policy := c.hedgingPolicy(); policy != nil {
+		response, err = policy.do(ctx, call)
+	} else if policy := c.retryPolicy(); policy != nil {
+		response, err = policy.do(ctx, call)
+	} else {
+		response, err = call(ctx)
+	}
+
+	// 出错时服务端同样可能返回元数据，例如限流提示
+	if response != nil && c.opts.responseMetadata != nil {
+		*c.opts.responseMetadata = response.Metadata
+	}
+
+	// 单向调用没有响应
+	if err != nil || response == nil {
+		return err
+	}
+
+	// 反序列化响应
+	return codec.UnmarshalPayload(serialization, response.Payload, rsp)
+}
+
+// roundTrip 发送一次请求，每次重试都重新生成请求头，携带最新的剩余超时时间
+// 服务端返回错误时同时返回响应和错误，单向调用返回 nil
+func (c *defaultClient) roundTrip(ctx context.Context, payload []byte) (response *protocol.Response, err error) {
+
+	// 熔断：跳过熔断的节点，并记录这次调用在所选节点上的结果
+	if cb := c.opts.circuitBreaker; cb != nil {
+		path := fmt.Sprintf("/%s/%s", c.opts.serviceName, c.opts.method)
+		var peer *selector.Peer
+		ctx, peer = selector.WithPeer(selector.WithFilter(ctx, cb.Filter(path)))
+		defer func() {
+			if addr := peer.Addr(); addr != "" {
+				cb.Record(cb.Key(addr, path), err)
+			}
+		}()
+	}
+
 	// 按照协议进行编码(默认是自定义协议)
 	clientCodec := codec.GetCodec(c.opts.protocol)
 
@@ -109,62 +234,154 @@ func (c *defaultClient) invoke(ctx context.Context, req, rsp interface{}) error
 	// 请求头进行 序列化
 	reqBuf, err := proto.Marshal(request)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 编码请求（得到一个完整 的 二进制请求包）
 	reqBody, err := clientCodec.Encode(reqBuf)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	clientTransport := c.NewClientTransport()
-	clientTransportOpts := []transport.ClientTransportOption{
-		transport.WithServiceName(c.opts.serviceName),
-		transport.WithClientTarget(c.opts.target),
-		transport.WithClientNetwork(c.opts.network),
-		transport.WithClientPool(connpool.GetPool("default")),
-		transport.WithSelector(selector.GetSelector(c.opts.selectorName)),
-		transport.WithTimeout(c.opts.timeout),
+
+	// 单向调用，请求发出即返回
+	if c.opts.oneWay {
+		transportOpts := append(c.transportOptions(), transport.WithReqType(codec.SendOnly))
+		_, err = clientTransport.Send(ctx, reqBody, transportOpts...)
+		if err != nil {
+			return nil, transportError(err)
+		}
+		return nil, nil
 	}
 
 	// send request
-	frame, err := clientTransport.Send(ctx, reqBody, clientTransportOpts...)
+	frame, err := clientTransport.Send(ctx, reqBody, c.transportOptions()...)
 	if err != nil {
-		return err
+		return nil, transportError(err)
 	}
 
 	// 将响应解码
 	rspBuf, err := clientCodec.Decode(frame)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 反序列化响应头
-	response := &protocol.Response{}
+	response = &protocol.Response{}
 	if err = proto.Unmarshal(rspBuf, response); err != nil {
-		return err
+		return nil, err
+	}
+
+	// 保留错误类型和错误详情
+	return response, codes.FromResponse(response)
+}
+
+// NewStream 建立流式调用，desc 为 nil 时按双向流处理
+// 超时参数对流式调用不生效，通过取消 ctx 结束流
+// 调用方需要一直读到 RecvMsg 返回错误（包括 io.EOF），或者使用 CloseAndRecv，或者取消 ctx，否则流占用的
+// stream ID 和后台的 goroutine 不会释放
+func (c *defaultClient) NewStream(ctx context.Context, desc *stream.Desc, path string, opts ...Option) (*stream.ClientStream, error) {
+
+	c = c.withOptions(opts...)
+
+	// 解析 path 得到 serviceName, method
+	serviceName, method, err := utils.ParseServicePath(path)
+	if err != nil {
+		return nil, err
 	}
 
-	if response.RetCode != 0 {
-		return codes.New(response.RetCode, response.RetMsg)
+	c.opts.serviceName = serviceName
+	c.opts.method = method
+
+	if c.opts.hashKey != "" {
+		ctx = selector.WithHashKey(ctx, c.opts.hashKey)
+	}
+	for _, f := range c.opts.nodeFilters {
+		ctx = selector.WithFilter(ctx, f)
 	}
 
-	// 反序列化响应
-	return serialization.Unmarshal(response.Payload, rsp)
+	newCtx, clientStream := stream.NewStreamingClientStream(ctx)
+	clientStream.WithServiceName(serviceName)
+	clientStream.WithMethod(method)
+	clientStream.WithSerialization(codec.GetSerialization(c.opts.serializationType))
 
+	// 首帧只携带服务路径和元数据
+	request := addReqHeader(newCtx, c, nil)
+	reqBuf, err := proto.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody, err := codec.GetCodec(c.opts.protocol).Encode(reqBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	streamTransport, ok := c.NewClientTransport().(transport.ClientStreamTransport)
+	if !ok {
+		return nil, codes.NewFrameworkError(codes.NetworkNotSupportedErrorCode, "client transport does not support streaming")
+	}
+
+	transportOpts := append(c.transportOptions(), transport.WithReqType(streamReqType(desc)))
+	st, err := streamTransport.NewStream(newCtx, reqBody, transportOpts...)
+	if err != nil {
+		return nil, transportError(err)
+	}
+	clientStream.WithTransport(st)
+
+	return clientStream, nil
+}
+
+func streamReqType(desc *stream.Desc) uint8 {
+	switch {
+	case desc == nil || desc.ClientStreams == desc.ServerStreams:
+		return codec.BidiStreamReq
+	case desc.ClientStreams:
+		return codec.ClientStreamReq
+	default:
+		return codec.ServerStreamReq
+	}
+}
+
+// transportError 传输层无法识别的错误（例如连接池中的连接已经关闭）按服务不可用处理，
+// 上下文错误原样返回，调用方仍然可以用 errors.Is 判断
+func transportError(err error) error {
+	if _, ok := codes.FromError(err); !ok {
+		return codes.NewFrameworkError(codes.UnavailableErrorCode, err.Error())
+	}
+	return err
 }
 
 func (c *defaultClient) NewClientTransport() transport.ClientTransport {
 	return transport.GetClientTransport(c.opts.protocol)
 }
 
+func (c *defaultClient) transportOptions() []transport.ClientTransportOption {
+	return []transport.ClientTransportOption{
+		transport.WithServiceName(c.opts.serviceName),
+		transport.WithClientTarget(c.opts.target),
+		transport.WithClientNetwork(c.opts.network),
+		transport.WithClientPool(connpool.GetPool("default")),
+		transport.WithSelector(selector.GetSelector(c.opts.selectorName)),
+		transport.WithTimeout(c.opts.timeout),
+		transport.WithMultiplexed(c.opts.multiplexed),
+		transport.WithClientProtocol(c.opts.protocol),
+		transport.WithClientTransportAuth(c.opts.transportAuth),
+		transport.WithClientCompressType(c.opts.compressType),
+		transport.WithClientCompressThreshold(c.opts.compressThreshold),
+	}
+}
+
 func addReqHeader(ctx context.Context, client *defaultClient, payload []byte) *protocol.Request {
 	clientStream := stream.GetClientStream(ctx)
 
 	servicePath := fmt.Sprintf("/%s/%s", clientStream.ServiceName, clientStream.Method)
-	// 元数据：例如超时时间
-	md := metadata.ClientMetadata(ctx)
+	// 元数据：例如超时时间，复制一份，避免修改调用方上下文中的元数据
+	md := make(map[string][]byte)
+	for k, v := range metadata.ClientMetadata(ctx) {
+		md[k] = v
+	}
 
 	// fill the authentication information
 	for _, pra := range client.opts.perRPCAuth {
@@ -174,6 +391,14 @@ func addReqHeader(ctx context.Context, client *defaultClient, payload []byte) *p
 		}
 	}
 
+	// 剩余的超时时间传给服务端，服务端据此决定处理请求的最长时间
+	if deadline, ok := ctx.Deadline(); ok {
+		metadata.SetTimeout(md, time.Until(deadline))
+	} else {
+		// 没有超时时间时不转发复制来的超时，例如代理透传的元数据
+		delete(md, metadata.TimeoutKey)
+	}
+
 	request := &protocol.Request{
 		ServicePath: servicePath,
 		Payload:     payload,
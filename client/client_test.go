@@ -2,11 +2,17 @@ package client
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xing-you-ji/novarpc"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/metadata"
+	"github.com/xing-you-ji/novarpc/stream"
 	"github.com/xing-you-ji/novarpc/testdata"
 )
 
@@ -14,13 +20,13 @@ func TestCall(t *testing.T) {
 
 	var ch = make(chan struct{})
 	go func() {
-		serverOpts := []gorpc.ServerOption{
-			gorpc.WithAddress("127.0.0.1:8001"),
-			gorpc.WithNetwork("tcp"),
-			gorpc.WithSerializationType("msgpack"),
-			gorpc.WithTimeout(time.Millisecond * 2000),
+		serverOpts := []novarpc.ServerOption{
+			novarpc.WithAddress("127.0.0.1:8001"),
+			novarpc.WithNetwork("tcp"),
+			novarpc.WithSerializationType("msgpack"),
+			novarpc.WithTimeout(time.Millisecond * 2000),
 		}
-		s := gorpc.NewServer(serverOpts...)
+		s := novarpc.NewServer(serverOpts...)
 		if err := s.RegisterService("helloworld.Greeter", new(testdata.Service)); err != nil {
 			panic(err)
 		}
@@ -53,3 +59,216 @@ func TestCall(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestReqHeaderTimeout(t *testing.T) {
+	c := NewDefaultClient()
+	ctx, cs := stream.NewClientStream(context.Background())
+	cs.WithServiceName("helloworld.Greeter")
+	ctx = metadata.WithClientMetadata(ctx, map[string][]byte{metadata.TimeoutKey: []byte("10"), "app-id": []byte("a")})
+
+	// 复制来的超时不转发给服务端
+	request := addReqHeader(ctx, c, nil)
+	_, ok := request.Metadata[metadata.TimeoutKey]
+	assert.False(t, ok)
+	assert.Equal(t, []byte("a"), request.Metadata["app-id"])
+
+	// 有超时时间时使用调用方剩余的时间
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	request = addReqHeader(ctx, c, nil)
+	timeout, ok := metadata.Timeout(request.Metadata)
+	assert.True(t, ok)
+	assert.True(t, timeout > 500*time.Millisecond)
+}
+
+type streamService struct {
+	cancelled chan error
+}
+
+type num struct {
+	N int
+}
+
+// Sum 客户端流，返回收到的所有数字之和
+func (s *streamService) Sum(ss *stream.ServerStream) error {
+	total := 0
+	for {
+		n := &num{}
+		err := ss.RecvMsg(n)
+		if err == io.EOF {
+			return ss.SendMsg(&num{N: total})
+		}
+		if err != nil {
+			return err
+		}
+		total += n.N
+	}
+}
+
+// Count 服务端流，返回 0 到 N-1
+func (s *streamService) Count(ss *stream.ServerStream) error {
+	n := &num{}
+	if err := ss.RecvMsg(n); err != nil {
+		return err
+	}
+	for i := 0; i < n.N; i++ {
+		if err := ss.SendMsg(&num{N: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Echo 双向流，原样返回每个数字，客户端关闭发送后结束
+func (s *streamService) Echo(ss *stream.ServerStream) error {
+	for {
+		n := &num{}
+		err := ss.RecvMsg(n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ss.SendMsg(n); err != nil {
+			return err
+		}
+	}
+}
+
+// Block 等待客户端取消
+func (s *streamService) Block(ss *stream.ServerStream) error {
+	<-ss.Context().Done()
+	s.cancelled <- ss.Context().Err()
+	return ss.Context().Err()
+}
+
+func TestStream(t *testing.T) {
+	s := novarpc.NewServer(
+		novarpc.WithAddress("127.0.0.1:8002"),
+		novarpc.WithNetwork("tcp"),
+		novarpc.WithSerializationType("msgpack"))
+	svc := &streamService{cancelled: make(chan error, 1)}
+	assert.Nil(t, s.RegisterService("helloworld.Stream", svc))
+	go s.Serve()
+	defer s.Close()
+	time.Sleep(300 * time.Millisecond)
+
+	opts := []Option{
+		WithTarget("127.0.0.1:8002"),
+		WithNetwork("tcp"),
+		WithSerializationType("msgpack"),
+	}
+	c := DefaultClient
+
+	// 客户端流：关闭发送后收到唯一的响应，之后流已经结束
+	cs, err := c.NewStream(context.Background(), &stream.Desc{ClientStreams: true}, "/helloworld.Stream/Sum", opts...)
+	assert.Nil(t, err)
+	for i := 1; i <= 10; i++ {
+		assert.Nil(t, cs.SendMsg(&num{N: i}))
+	}
+	sum := &num{}
+	assert.Nil(t, cs.CloseAndRecv(sum))
+	assert.Equal(t, 55, sum.N)
+	assert.Equal(t, io.EOF, cs.RecvMsg(sum))
+
+	// 服务端流：按顺序收到所有消息，最后是 io.EOF
+	cs, err = c.NewStream(context.Background(), &stream.Desc{ServerStreams: true}, "/helloworld.Stream/Count", opts...)
+	assert.Nil(t, err)
+	assert.Nil(t, cs.SendMsg(&num{N: 200}))
+	assert.Nil(t, cs.CloseSend())
+	for i := 0; i < 200; i++ {
+		n := &num{}
+		assert.Nil(t, cs.RecvMsg(n))
+		assert.Equal(t, i, n.N)
+	}
+	assert.Equal(t, io.EOF, cs.RecvMsg(&num{}))
+
+	// 双向流：半关闭之后服务端结束流
+	cs, err = c.NewStream(context.Background(), nil, "/helloworld.Stream/Echo", opts...)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, cs.SendMsg(&num{N: i}))
+		n := &num{}
+		assert.Nil(t, cs.RecvMsg(n))
+		assert.Equal(t, i, n.N)
+	}
+	assert.Nil(t, cs.CloseSend())
+	assert.Equal(t, io.EOF, cs.RecvMsg(&num{}))
+
+	// 取消 ctx 时服务端的 Handler 被取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cs, err = c.NewStream(ctx, nil, "/helloworld.Stream/Block", opts...)
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-svc.cancelled:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream handler was not cancelled")
+	}
+	assert.Equal(t, context.Canceled, cs.RecvMsg(&num{}))
+}
+
+type slowService struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowService) SayHello(ctx context.Context, req *testdata.HelloRequest) (*testdata.HelloReply, error) {
+	return &testdata.HelloReply{Msg: req.Msg}, nil
+}
+
+func (s *slowService) Wait(ctx context.Context, req *testdata.HelloRequest) (*testdata.HelloReply, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return &testdata.HelloReply{Msg: req.Msg}, nil
+}
+
+func TestShutdown(t *testing.T) {
+	s := novarpc.NewServer(
+		novarpc.WithAddress("127.0.0.1:8003"),
+		novarpc.WithNetwork("tcp"),
+		novarpc.WithSerializationType("msgpack"))
+	svc := &slowService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	assert.Nil(t, s.RegisterService("helloworld.Slow", svc))
+	go s.Serve()
+	time.Sleep(300 * time.Millisecond)
+
+	opts := []Option{
+		WithTarget("127.0.0.1:8003"),
+		WithNetwork("tcp"),
+		WithSerializationType("msgpack"),
+	}
+	c := DefaultClient
+	req := &testdata.HelloRequest{Msg: "hello"}
+	assert.Nil(t, c.Call(context.Background(), "/helloworld.Slow/SayHello", req, &testdata.HelloReply{}, opts...))
+
+	// 一个请求正在处理，另一个连接空闲地放在连接池里
+	slow := make(chan error, 1)
+	go func() {
+		slow <- c.Call(context.Background(), "/helloworld.Slow/Wait", req, &testdata.HelloReply{}, opts...)
+	}()
+	<-svc.started
+	assert.Nil(t, c.Call(context.Background(), "/helloworld.Slow/SayHello", req, &testdata.HelloReply{}, opts...))
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// 收到 GOAWAY 的空闲连接不再复用，新的连接建立失败，而不是读到 EOF
+	err := c.Call(context.Background(), "/helloworld.Slow/SayHello", req, &testdata.HelloReply{}, opts...)
+	var opErr *net.OpError
+	if assert.True(t, errors.As(err, &opErr), "%v", err) {
+		assert.Equal(t, "dial", opErr.Op)
+	}
+	assert.Equal(t, uint32(codes.UnavailableErrorCode), codes.Code(err))
+
+	// 正在处理的请求正常返回
+	close(svc.release)
+	assert.Nil(t, <-slow)
+	assert.Nil(t, <-shutdown)
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/health"
+	"go.uber.org/zap"
+)
+
+// HealthCheckConfig defines the parameters of the active health checks of a HealthChecker
+type HealthCheckConfig struct {
+	Interval           time.Duration // 检查间隔，默认 5s
+	Timeout            time.Duration // 单次检查的超时时间，默认 1s
+	UnhealthyThreshold int           // 连续检查失败多少次后摘除节点，默认 3，节点返回 NOT_SERVING 时立即摘除
+	Options            []Option      // 调用健康检查服务的参数，需要和服务端一致，例如序列化方式、鉴权
+}
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = time.Second
+	defaultUnhealthyThreshold  = 3
+	// healthCheckIdleRounds 节点连续这么多个检查间隔没有被选择时停止检查
+	healthCheckIdleRounds = 10
+)
+
+// HealthChecker actively checks the health service of the nodes a client calls and takes the nodes that are
+// not serving out of the selector results. Nodes are checked from the first time they are seen, a node is
+// healthy until a check says otherwise. Servers without the health service are seen as healthy.
+// Share one checker between calls, e.g. set it with WithHealthChecker when creating the client.
+type HealthChecker struct {
+	config HealthCheckConfig
+	probe  func(key healthKey) (health.ServingStatus, error) // 检查一个节点，默认调用节点的健康检查服务
+	mu     sync.Mutex
+	nodes  map[healthKey]*nodeHealth
+	done   chan struct{}
+	once   sync.Once
+}
+
+type healthKey struct {
+	serviceName string
+	addr        string
+}
+
+// nodeHealth 一个服务在一个节点上的健康状态
+type nodeHealth struct {
+	healthy  bool
+	failures int       // 连续检查失败的次数
+	lastUsed time.Time // 最近一次被选择的时间
+	checking bool      // 正在检查
+}
+
+// NewHealthChecker creates a HealthChecker and starts checking in the background, Close stops it
+func NewHealthChecker(config HealthCheckConfig) *HealthChecker {
+	return newHealthChecker(config, nil)
+}
+
+func newHealthChecker(config HealthCheckConfig, probe func(key healthKey) (health.ServingStatus, error)) *HealthChecker {
+	if config.Interval <= 0 {
+		config.Interval = defaultHealthCheckInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultHealthCheckTimeout
+	}
+	if config.UnhealthyThreshold <= 0 {
+		config.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+
+	hc := &HealthChecker{
+		config: config,
+		probe:  probe,
+		nodes:  make(map[healthKey]*nodeHealth),
+		done:   make(chan struct{}),
+	}
+	if hc.probe == nil {
+		hc.probe = hc.call
+	}
+	go hc.loop()
+
+	return hc
+}
+
+// Ready reports whether the node is healthy, a node seen for the first time is checked right away
+func (hc *HealthChecker) Ready(serviceName string, addr string) bool {
+	key := healthKey{serviceName: serviceName, addr: addr}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	node, ok := hc.nodes[key]
+	if !ok {
+		node = &nodeHealth{healthy: true, checking: true}
+		hc.nodes[key] = node
+		go hc.check(key)
+	}
+	node.lastUsed = time.Now()
+
+	return node.healthy
+}
+
+// Admit rejects calls to unhealthy nodes, e.g. a fixed target that is not serving
+func (hc *HealthChecker) Admit(serviceName string, addr string) bool {
+	return hc.Ready(serviceName, addr)
+}
+
+// Healthy reports whether the node is healthy without starting to check it
+func (hc *HealthChecker) Healthy(serviceName string, addr string) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	node, ok := hc.nodes[healthKey{serviceName: serviceName, addr: addr}]
+	return !ok || node.healthy
+}
+
+// Close stops checking
+func (hc *HealthChecker) Close() {
+	hc.once.Do(func() {
+		close(hc.done)
+	})
+}
+
+func (hc *HealthChecker) loop() {
+	ticker := time.NewTicker(hc.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hc.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var keys []healthKey
+		hc.mu.Lock()
+		for key, node := range hc.nodes {
+			// 不再使用的节点不再检查，例如已经从服务发现中摘除
+			if now.Sub(node.lastUsed) > healthCheckIdleRounds*hc.config.Interval {
+				delete(hc.nodes, key)
+				continue
+			}
+			if !node.checking {
+				node.checking = true
+				keys = append(keys, key)
+			}
+		}
+		hc.mu.Unlock()
+
+		for _, key := range keys {
+			go hc.check(key)
+		}
+	}
+}
+
+// check 检查节点并更新节点的状态
+func (hc *HealthChecker) check(key healthKey) {
+	status, err := hc.probe(key)
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	node, ok := hc.nodes[key]
+	if !ok {
+		return
+	}
+	node.checking = false
+
+	healthy := node.healthy
+	switch {
+	case err != nil:
+		node.failures++
+		if node.failures >= hc.config.UnhealthyThreshold {
+			healthy = false
+		}
+	case status == health.StatusServing:
+		node.failures = 0
+		healthy = true
+	default:
+		node.failures = 0
+		healthy = false
+	}
+
+	if healthy != node.healthy {
+		zap.L().Info("health status of node changed", zap.String("service", key.serviceName),
+			zap.String("addr", key.addr), zap.Bool("healthy", healthy), zap.Stringer("status", status), zap.Error(err))
+		node.healthy = healthy
+	}
+}
+
+// call 调用节点的健康检查服务
+func (hc *HealthChecker) call(key healthKey) (health.ServingStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.config.Timeout)
+	defer cancel()
+
+	// 默认使用 tcp，Options 可以覆盖
+	opts := append([]Option{WithNetwork("tcp")}, hc.config.Options...)
+	opts = append(opts, WithTarget(key.addr), WithSelectorName("default"))
+
+	rsp := &health.HealthCheckResponse{}
+	err := DefaultClient.Invoke(ctx, &health.HealthCheckRequest{Service: key.serviceName}, rsp, health.CheckPath, opts...)
+	if err != nil {
+		// 没有健康检查服务的节点按健康处理
+		if codes.Code(err) == uint32(codes.NotFoundErrorCode) {
+			return health.StatusServing, nil
+		}
+		return health.StatusUnknown, err
+	}
+
+	return rsp.Status, nil
+}
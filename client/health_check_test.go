@@ -0,0 +1,70 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/health"
+)
+
+type fakeProbe struct {
+	mu      sync.Mutex
+	status  map[string]health.ServingStatus
+	err     map[string]error
+	checked chan string
+}
+
+func (p *fakeProbe) probe(key healthKey) (health.ServingStatus, error) {
+	p.mu.Lock()
+	status, err := p.status[key.addr], p.err[key.addr]
+	p.mu.Unlock()
+	defer func() { p.checked <- key.addr }()
+	return status, err
+}
+
+func (p *fakeProbe) set(addr string, status health.ServingStatus, err error) {
+	p.mu.Lock()
+	p.status[addr], p.err[addr] = status, err
+	p.mu.Unlock()
+}
+
+func TestHealthChecker(t *testing.T) {
+	p := &fakeProbe{
+		status:  map[string]health.ServingStatus{"a": health.StatusServing, "b": health.StatusNotServing},
+		err:     map[string]error{},
+		checked: make(chan string, 100),
+	}
+	hc := newHealthChecker(HealthCheckConfig{Interval: 10 * time.Millisecond, UnhealthyThreshold: 2}, p.probe)
+	defer hc.Close()
+
+	wait := func(addr string, healthy bool) {
+		deadline := time.Now().Add(time.Second)
+		for hc.Healthy("svc", addr) != healthy && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Equal(t, healthy, hc.Healthy("svc", addr))
+	}
+
+	// 第一次见到的节点按健康处理，并立即检查
+	assert.True(t, hc.Ready("svc", "a"))
+	assert.True(t, hc.Ready("svc", "b"))
+	wait("b", false)
+	assert.False(t, hc.Ready("svc", "b"))
+	assert.False(t, hc.Admit("svc", "b"))
+	assert.True(t, hc.Ready("svc", "a"))
+
+	// 连续检查失败达到阈值后摘除
+	p.set("a", health.StatusUnknown, errors.New("connection refused"))
+	wait("a", false)
+
+	// 恢复后重新加入
+	p.set("a", health.StatusServing, nil)
+	p.set("b", health.StatusServing, nil)
+	hc.Ready("svc", "a")
+	hc.Ready("svc", "b")
+	wait("a", true)
+	wait("b", true)
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/selector"
+)
+
+// HedgingPolicy sends another copy of an idempotent call to another node when no reply comes back
+// within a delay, the first reply is used and the other copies are cancelled. It takes precedence over
+// RetryPolicy when both are set for a call.
+type HedgingPolicy struct {
+	MaxAttempts int           // 最多发送的份数（包括第一次），默认 2
+	Delay       time.Duration // 发送下一份之前等待的时间，默认 100ms
+	// Percentile 按最近调用延迟的分位数决定等待的时间，例如 0.95，样本不足时使用 Delay
+	Percentile    float64
+	NonFatalCodes []uint32     // 出现这些错误码时继续等待其他副本，默认只有 codes.UnavailableErrorCode
+	Budget        *RetryBudget // 对冲预算，每发送一份副本消耗一个令牌，nil 表示不限制
+
+	mu        sync.Mutex
+	latencies []time.Duration // 最近成功调用的延迟
+	next      int
+}
+
+const (
+	defaultHedgingAttempts = 2
+	defaultHedgingDelay    = 100 * time.Millisecond
+	// hedgingWindow 计算分位数使用的样本数，hedgingMinSamples 样本数少于这个值时使用固定的延迟
+	hedgingWindow     = 100
+	hedgingMinSamples = 20
+)
+
+type hedgeResult struct {
+	response *protocol.Response
+	err      error
+}
+
+// do sends copies of the call until one of them replies, or fails with a fatal error
+func (p *HedgingPolicy) do(ctx context.Context, call func(context.Context) (*protocol.Response, error)) (*protocol.Response, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultHedgingAttempts
+	}
+
+	// 每份副本发给不同的节点，返回时取消其他副本
+	ctx = selector.WithTried(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, maxAttempts)
+	send := func() {
+		go func() {
+			start := time.Now()
+			response, err := call(ctx)
+			if err == nil {
+				p.observe(time.Since(start))
+			}
+			results <- hedgeResult{response, err}
+		}()
+	}
+
+	send()
+	sent, pending := 1, 1
+	delay := p.delay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last hedgeResult
+	for {
+		select {
+		case result := <-results:
+			pending--
+			if !retryable(result.err, p.NonFatalCodes) {
+				p.Budget.onSuccess()
+				return result.response, result.err
+			}
+			last = result
+
+			// 所有副本都失败了，立即发送下一份
+			if pending == 0 {
+				if sent >= maxAttempts || ctx.Err() != nil || !p.Budget.allow() {
+					return last.response, last.err
+				}
+				p.Budget.onFailure()
+				send()
+				sent, pending = sent+1, pending+1
+			}
+
+		case <-timer.C:
+			if sent < maxAttempts && p.Budget.allow() {
+				p.Budget.onFailure()
+				send()
+				sent, pending = sent+1, pending+1
+				timer.Reset(delay)
+			}
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// delay returns how long to wait before sending the next copy
+func (p *HedgingPolicy) delay() time.Duration {
+	if p.Percentile > 0 {
+		p.mu.Lock()
+		latencies := append([]time.Duration(nil), p.latencies...)
+		p.mu.Unlock()
+
+		if len(latencies) >= hedgingMinSamples {
+			sort.Slice(latencies, func(i, j int) bool {
+				return latencies[i] < latencies[j]
+			})
+			index := int(p.Percentile * float64(len(latencies)))
+			if index >= len(latencies) {
+				index = len(latencies) - 1
+			}
+			return latencies[index]
+		}
+	}
+
+	if p.Delay > 0 {
+		return p.Delay
+	}
+	return defaultHedgingDelay
+}
+
+// observe 记录成功调用的延迟，只保留最近 hedgingWindow 个样本
+func (p *HedgingPolicy) observe(latency time.Duration) {
+	if p.Percentile <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.latencies) < hedgingWindow {
+		p.latencies = append(p.latencies, latency)
+		return
+	}
+	p.latencies[p.next] = latency
+	p.next = (p.next + 1) % hedgingWindow
+}
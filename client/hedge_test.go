@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/selector"
+)
+
+func TestHedgingPolicy(t *testing.T) {
+	policy := &HedgingPolicy{MaxAttempts: 3, Delay: 20 * time.Millisecond}
+
+	// 第一份副本很慢，使用第二份的响应并取消第一份
+	var attempts int32
+	cancelled := make(chan struct{}, 1)
+	start := time.Now()
+	response, err := policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) {
+		n := atomic.AddInt32(&attempts, 1)
+		selector.MarkTried(ctx, "node")
+		if n == 1 {
+			<-ctx.Done()
+			cancelled <- struct{}{}
+			return nil, ctx.Err()
+		}
+		assert.Len(t, selector.Tried(ctx), 2)
+		return &protocol.Response{Payload: []byte("fast")}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "fast", string(response.Payload))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+	<-cancelled
+
+	// 第一份很快时不发送副本
+	atomic.StoreInt32(&attempts, 0)
+	_, err = policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return &protocol.Response{}, nil
+	})
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestHedgingErrors(t *testing.T) {
+	policy := &HedgingPolicy{MaxAttempts: 3, Delay: time.Second}
+
+	// 节点不可用时立即发送下一份，全部失败后返回最后的错误
+	var attempts int32
+	start := time.Now()
+	_, err := policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, codes.NewFrameworkError(codes.UnavailableErrorCode, "connection refused")
+	})
+	assert.Equal(t, uint32(codes.UnavailableErrorCode), codes.Code(err))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.True(t, time.Since(start) < time.Second)
+
+	// 业务错误是有效的响应，直接返回
+	atomic.StoreInt32(&attempts, 0)
+	_, err = policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return &protocol.Response{RetCode: 1001}, codes.New(1001, "not found")
+	})
+	assert.Equal(t, uint32(1001), codes.Code(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestHedgingBudget(t *testing.T) {
+	policy := &HedgingPolicy{MaxAttempts: 2, Delay: time.Millisecond, Budget: NewRetryBudget(2, 0)}
+
+	var attempts int32
+	slow := func(ctx context.Context) (*protocol.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		time.Sleep(20 * time.Millisecond)
+		return &protocol.Response{}, nil
+	}
+
+	// 第一次调用发送了副本，预算用完后不再发送
+	policy.do(context.Background(), slow)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	policy.do(context.Background(), slow)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestHedgingDelay(t *testing.T) {
+	policy := &HedgingPolicy{Percentile: 0.9}
+	assert.Equal(t, defaultHedgingDelay, policy.delay())
+
+	for i := 1; i <= 2*hedgingWindow; i++ {
+		policy.observe(time.Duration(i%hedgingWindow) * time.Millisecond)
+	}
+	assert.Len(t, policy.latencies, hedgingWindow)
+	assert.Equal(t, 90*time.Millisecond, policy.delay())
+
+	assert.Equal(t, 5*time.Millisecond, (&HedgingPolicy{Delay: 5 * time.Millisecond}).delay())
+}
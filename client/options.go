@@ -4,7 +4,9 @@ import (
 	"time"
 
 	"github.com/xing-you-ji/novarpc/auth"
+	"github.com/xing-you-ji/novarpc/breaker"
 	"github.com/xing-you-ji/novarpc/interceptor"
+	"github.com/xing-you-ji/novarpc/selector"
 	"github.com/xing-you-ji/novarpc/transport"
 )
 
@@ -22,6 +24,20 @@ type Options struct {
 	selectorName      string            // service discovery name, e.g. : consul、zookeeper、etcd
 	perRPCAuth        []auth.PerRPCAuth // authentication information required for each RPC call
 	transportAuth     auth.TransportAuth
+	multiplexed       bool                      // 多路复用：并发调用共享少量长连接
+	compressType      uint8                     // 请求体压缩类型，例如 codec.CompressTypeGzip，默认不压缩
+	compressThreshold int                       // 请求体小于这个大小时不压缩
+	oneWay            bool                      // 单向调用，不等待响应
+	responseMetadata  *map[string][]byte        // 保存服务端返回的元数据
+	hashKey           string                    // 一致性哈希负载均衡按这个 key 选择节点
+	retryPolicy       *RetryPolicy              // 默认的重试策略
+	retryPolicies     map[string]*RetryPolicy   // 按服务或者方法设置的重试策略
+	hedgingPolicy     *HedgingPolicy            // 默认的对冲策略
+	hedgingPolicies   map[string]*HedgingPolicy // 按服务或者方法设置的对冲策略
+	idempotent        bool                      // 本次调用是幂等的，可以重试或者对冲
+	idempotentMethods map[string]bool           // 幂等的方法，例如 /helloworld.Greeter/SayHello
+	circuitBreaker    *breaker.CircuitBreaker   // 熔断器，跳过持续失败的节点
+	nodeFilters       []selector.NodeFilter     // 选择节点时跳过的节点，例如健康检查不通过的节点
 }
 
 type Option func(*Options)
@@ -102,3 +118,122 @@ func WithTransportAuth(transportAuth auth.TransportAuth) Option {
 		o.transportAuth = transportAuth
 	}
 }
+
+// WithMultiplexed set whether concurrent calls share a few long-lived connections
+func WithMultiplexed(multiplexed bool) Option {
+	return func(o *Options) {
+		o.multiplexed = multiplexed
+	}
+}
+
+// WithCompressType set the compress type of requests, e.g. codec.CompressTypeGzip
+func WithCompressType(compressType uint8) Option {
+	return func(o *Options) {
+		o.compressType = compressType
+	}
+}
+
+// WithCompressThreshold set the size below which requests are sent uncompressed
+func WithCompressThreshold(threshold int) Option {
+	return func(o *Options) {
+		o.compressThreshold = threshold
+	}
+}
+
+// WithResponseMetadata captures the metadata the server sends back with the response, e.g. pagination cursors
+func WithResponseMetadata(md *map[string][]byte) Option {
+	return func(o *Options) {
+		o.responseMetadata = md
+	}
+}
+
+// WithHashKey routes the call by key when the selector uses the consistent hash balancer,
+// calls with the same key go to the same node as long as it is alive
+func WithHashKey(key string) Option {
+	return func(o *Options) {
+		o.hashKey = key
+	}
+}
+
+// WithRetryPolicy set the retry policy of calls, only idempotent calls are retried
+func WithRetryPolicy(policy *RetryPolicy) Option {
+	return func(o *Options) {
+		o.retryPolicy = policy
+	}
+}
+
+// WithServiceRetryPolicy set the retry policy of a service, e.g. helloworld.Greeter,
+// or of a method, e.g. /helloworld.Greeter/SayHello. It takes precedence over WithRetryPolicy.
+func WithServiceRetryPolicy(name string, policy *RetryPolicy) Option {
+	return func(o *Options) {
+		// 复制一份，避免单次调用的参数修改到 client 的参数
+		policies := make(map[string]*RetryPolicy, len(o.retryPolicies)+1)
+		for k, v := range o.retryPolicies {
+			policies[k] = v
+		}
+		policies[name] = policy
+		o.retryPolicies = policies
+	}
+}
+
+// WithHedgingPolicy set the hedging policy of calls, only idempotent calls are hedged
+func WithHedgingPolicy(policy *HedgingPolicy) Option {
+	return func(o *Options) {
+		o.hedgingPolicy = policy
+	}
+}
+
+// WithServiceHedgingPolicy set the hedging policy of a service, e.g. helloworld.Greeter,
+// or of a method, e.g. /helloworld.Greeter/SayHello. It takes precedence over WithHedgingPolicy.
+func WithServiceHedgingPolicy(name string, policy *HedgingPolicy) Option {
+	return func(o *Options) {
+		// 复制一份，避免单次调用的参数修改到 client 的参数
+		policies := make(map[string]*HedgingPolicy, len(o.hedgingPolicies)+1)
+		for k, v := range o.hedgingPolicies {
+			policies[k] = v
+		}
+		policies[name] = policy
+		o.hedgingPolicies = policies
+	}
+}
+
+// WithIdempotent marks the call as idempotent, so that it can be retried or hedged
+func WithIdempotent() Option {
+	return func(o *Options) {
+		o.idempotent = true
+	}
+}
+
+// WithIdempotentMethods marks methods as idempotent, e.g. /helloworld.Greeter/SayHello
+func WithIdempotentMethods(paths ...string) Option {
+	return func(o *Options) {
+		methods := make(map[string]bool, len(o.idempotentMethods)+len(paths))
+		for k, v := range o.idempotentMethods {
+			methods[k] = v
+		}
+		for _, path := range paths {
+			methods[path] = true
+		}
+		o.idempotentMethods = methods
+	}
+}
+
+// WithCircuitBreaker skips the nodes whose calls keep failing, the outcome of every attempt is recorded
+// with the node it is sent to. Share one breaker between calls, e.g. set it when creating the client.
+func WithCircuitBreaker(cb *breaker.CircuitBreaker) Option {
+	return func(o *Options) {
+		o.circuitBreaker = cb
+	}
+}
+
+// WithNodeFilter skips the nodes f is not ready for when selecting a node
+func WithNodeFilter(f selector.NodeFilter) Option {
+	return func(o *Options) {
+		o.nodeFilters = append(o.nodeFilters, f)
+	}
+}
+
+// WithHealthChecker skips the nodes that are not serving according to the active health checks of hc
+func WithHealthChecker(hc *HealthChecker) Option {
+	return WithNodeFilter(hc)
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/selector"
+)
+
+// RetryPolicy defines how failed calls are retried, only idempotent calls are retried (see WithIdempotent).
+// Zero fields use the defaults below, retries go to nodes the call has not tried if there are any.
+type RetryPolicy struct {
+	MaxAttempts       int           // 最多调用次数（包括第一次），默认 3
+	InitialBackoff    time.Duration // 第一次重试前等待的时间，默认 50ms
+	MaxBackoff        time.Duration // 等待时间的上限，默认 1s
+	BackoffMultiplier float64       // 每次重试等待时间的增长倍数，默认 2
+	Jitter            float64       // 等待时间随机浮动的比例，0.2 表示 ±20%，默认 0.2
+	RetryableCodes    []uint32      // 可以重试的错误码，默认只重试 codes.UnavailableErrorCode
+	Budget            *RetryBudget  // 重试预算，多个调用共享，防止重试风暴，nil 表示不限制
+}
+
+const (
+	defaultMaxAttempts       = 3
+	defaultInitialBackoff    = 50 * time.Millisecond
+	defaultMaxBackoff        = time.Second
+	defaultBackoffMultiplier = 2
+	defaultJitter            = 0.2
+)
+
+// do calls call until it succeeds, fails with a code that is not retryable, or runs out of attempts or budget
+func (p *RetryPolicy) do(ctx context.Context, call func(context.Context) (*protocol.Response, error)) (*protocol.Response, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	// 记录尝试过的节点，重试时由 selector 避开
+	ctx = selector.WithTried(ctx)
+
+	for attempt := 1; ; attempt++ {
+		response, err := call(ctx)
+		if !retryable(err, p.RetryableCodes) {
+			p.Budget.onSuccess()
+			return response, err
+		}
+		p.Budget.onFailure()
+
+		if attempt >= maxAttempts || ctx.Err() != nil || !p.Budget.allow() {
+			return response, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return response, err
+		}
+	}
+}
+
+// retryable 错误码在 retryableCodes 中，没有设置时只有 codes.UnavailableErrorCode 可以重试
+func retryable(err error, retryableCodes []uint32) bool {
+	if err == nil {
+		return false
+	}
+
+	code := codes.Code(err)
+	if len(retryableCodes) == 0 {
+		return code == codes.UnavailableErrorCode
+	}
+	for _, c := range retryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 第 n 次重试前等待的时间：指数增长并随机浮动，避免所有客户端同时重试
+func (p *RetryPolicy) backoff(retries int) time.Duration {
+	initial, max := p.InitialBackoff, p.MaxBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	multiplier, jitter := p.BackoffMultiplier, p.Jitter
+	if multiplier < 1 {
+		multiplier = defaultBackoffMultiplier
+	}
+	if jitter <= 0 {
+		jitter = defaultJitter
+	}
+
+	backoff := math.Min(float64(initial)*math.Pow(multiplier, float64(retries-1)), float64(max))
+	backoff *= 1 + jitter*(2*rand.Float64()-1)
+	return time.Duration(backoff)
+}
+
+// RetryBudget limits retries when a large part of calls fail, e.g. the downstream service is overloaded.
+// Every failed attempt costs a token and every successful call earns tokenRatio tokens,
+// retries are allowed only while more than half of the tokens are left.
+type RetryBudget struct {
+	mu         sync.Mutex
+	maxTokens  float64
+	tokenRatio float64
+	tokens     float64
+}
+
+// NewRetryBudget creates a RetryBudget, e.g. NewRetryBudget(10, 0.1) stops retrying once
+// more than about 1 in 10 calls fail
+func NewRetryBudget(maxTokens float64, tokenRatio float64) *RetryBudget {
+	return &RetryBudget{
+		maxTokens:  maxTokens,
+		tokenRatio: tokenRatio,
+		tokens:     maxTokens,
+	}
+}
+
+func (b *RetryBudget) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens > b.maxTokens/2
+}
+
+func (b *RetryBudget) onSuccess() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens = math.Min(b.tokens+b.tokenRatio, b.maxTokens)
+	b.mu.Unlock()
+}
+
+func (b *RetryBudget) onFailure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens = math.Max(b.tokens-1, 0)
+	b.mu.Unlock()
+}
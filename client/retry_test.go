@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/selector"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	unavailable := codes.NewFrameworkError(codes.UnavailableErrorCode, "connection refused")
+
+	// 失败后重试，并且记录尝试过的节点
+	attempts := 0
+	_, err := policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) {
+		attempts++
+		assert.Len(t, selector.Tried(ctx), attempts-1)
+		selector.MarkTried(ctx, "127.0.0.1:800"+string(rune('0'+attempts)))
+		if attempts < 2 {
+			return nil, unavailable
+		}
+		return &protocol.Response{}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+
+	// 最多调用 MaxAttempts 次
+	attempts = 0
+	_, err = policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) {
+		attempts++
+		return nil, unavailable
+	})
+	assert.Equal(t, unavailable, err)
+	assert.Equal(t, 3, attempts)
+
+	// 不可重试的错误码直接返回
+	attempts = 0
+	_, err = policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) {
+		attempts++
+		return nil, errors.New("business error")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// 自定义可重试的错误码
+	policy.RetryableCodes = []uint32{codes.ResourceExhaustedErrorCode}
+	assert.True(t, retryable(codes.NewFrameworkError(codes.ResourceExhaustedErrorCode, ""), policy.RetryableCodes))
+	assert.False(t, retryable(unavailable, policy.RetryableCodes))
+	assert.False(t, retryable(nil, policy.RetryableCodes))
+}
+
+func TestRetryDeadline(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// 等待重试时超时，返回最后一次的错误
+	attempts := 0
+	start := time.Now()
+	_, err := policy.do(ctx, func(ctx context.Context) (*protocol.Response, error) {
+		attempts++
+		return nil, codes.NewFrameworkError(codes.UnavailableErrorCode, "connection refused")
+	})
+	assert.Equal(t, uint32(codes.UnavailableErrorCode), codes.Code(err))
+	assert.Equal(t, 1, attempts)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond, Jitter: 0.1}
+	for i := 0; i < 100; i++ {
+		b := policy.backoff(1)
+		assert.True(t, b >= 90*time.Millisecond && b <= 110*time.Millisecond, "%v", b)
+		b = policy.backoff(2)
+		assert.True(t, b >= 180*time.Millisecond && b <= 220*time.Millisecond, "%v", b)
+		b = policy.backoff(10)
+		assert.True(t, b >= 270*time.Millisecond && b <= 330*time.Millisecond, "%v", b)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := NewRetryBudget(4, 1)
+	policy := &RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Millisecond, Budget: budget}
+	failing := func(ctx context.Context) (*protocol.Response, error) {
+		return nil, codes.NewFrameworkError(codes.UnavailableErrorCode, "connection refused")
+	}
+
+	// 令牌剩一半时停止重试
+	attempts := 0
+	policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) {
+		attempts++
+		return failing(ctx)
+	})
+	assert.Equal(t, 2, attempts)
+	assert.False(t, budget.allow())
+
+	// 成功的调用恢复预算
+	policy.do(context.Background(), func(ctx context.Context) (*protocol.Response, error) { return nil, nil })
+	assert.True(t, budget.allow())
+}
+
+func TestRetryPolicyLookup(t *testing.T) {
+	def, svc, method := &RetryPolicy{}, &RetryPolicy{}, &RetryPolicy{}
+	c := NewDefaultClient().withOptions(WithRetryPolicy(def),
+		WithServiceRetryPolicy("helloworld.Greeter", svc),
+		WithServiceRetryPolicy("/helloworld.Greeter/SayHello", method),
+		WithIdempotentMethods("/helloworld.Greeter/SayHello"))
+
+	c.opts.serviceName, c.opts.method = "helloworld.Greeter", "SayHello"
+	assert.Equal(t, method, c.retryPolicy())
+
+	// 不幂等的方法不重试
+	c.opts.method = "Update"
+	assert.Nil(t, c.retryPolicy())
+
+	call := c.withOptions(WithIdempotent())
+	call.opts.serviceName, call.opts.method = "helloworld.Greeter", "Update"
+	assert.Equal(t, svc, call.retryPolicy())
+	call.opts.serviceName = "helloworld.Other"
+	assert.Equal(t, def, call.retryPolicy())
+
+	// 单次调用的参数不影响 client
+	c.withOptions(WithServiceRetryPolicy("helloworld.Other", svc))
+	assert.Len(t, c.opts.retryPolicies, 2)
+}
@@ -3,6 +3,7 @@ package codec
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"math"
 	"sync"
 
@@ -19,13 +20,31 @@ const FrameHeadLen = 15 // frame header length
 const Magic = 0x11      // magic
 const Version = 0       // version
 
+// frame msg types
+const (
+	GeneralMsg   = 0x0 // general request or response
+	HeartbeatMsg = 0x1 // heartbeat
+	StreamEndMsg = 0x2 // end of a stream : client half-close or server final status
+	CancelMsg    = 0x3 // the caller cancels a request or stream
+	GoAwayMsg    = 0x4 // the server is shutting down, no new requests on the connection
+)
+
+// frame request types
+const (
+	SendAndRecv     = 0x0 // send and receive
+	SendOnly        = 0x1 // send but not receive
+	ClientStreamReq = 0x2 // client stream request
+	ServerStreamReq = 0x3 // server stream request
+	BidiStreamReq   = 0x4 // bidirectional streaming request
+)
+
 // FrameHeader describes the header structure of a data frame
 type FrameHeader struct {
 	Magic        uint8  // 魔数
 	Version      uint8  // version
 	MsgType      uint8  // msg type e.g. :   0x0: general req,  0x1: heartbeat
 	ReqType      uint8  // request type e.g.	 :   0x0: send and receive,   0x1: send but not receive,  0x2: client stream request, 0x3: server stream request, 0x4: bidirectional streaming request
-	CompressType uint8  // compression or not :  0x0: not compression,  0x1: compression
+	CompressType uint8  // compression type e.g. :  0x0: not compression,  0x1: gzip,  0x2: snappy,  0x3: zstd
 	StreamID     uint16 // stream ID
 	Length       uint32 // total packet length
 	Reserved     uint32 // 4 bytes reserved
@@ -125,8 +144,74 @@ func (c *defaultCodec) Encode(data []byte) ([]byte, error) {
 }
 
 func (c *defaultCodec) Decode(requestBuf []byte) ([]byte, error) {
-	// 我们只需要帧头 后面的数据
-	return requestBuf[FrameHeadLen:], nil
+	if len(requestBuf) < FrameHeadLen {
+		return nil, errors.New("frame is shorter than the frame header")
+	}
+
+	// 我们只需要帧头 后面的数据，按帧头中的压缩类型解压
+	return decompress(CompressType(requestBuf), requestBuf[FrameHeadLen:])
+}
+
+// MsgType returns the msg type carried in the header of a complete frame
+func MsgType(frame []byte) uint8 {
+	if len(frame) < FrameHeadLen {
+		return 0
+	}
+	return frame[2]
+}
+
+// SetMsgType writes the msg type into the header of a complete frame
+func SetMsgType(frame []byte, msgType uint8) {
+	if len(frame) < FrameHeadLen {
+		return
+	}
+	frame[2] = msgType
+}
+
+// HeartbeatFrame returns a ping frame with an empty body, the server answers it with the same frame as the pong
+func HeartbeatFrame() []byte {
+	frame := make([]byte, FrameHeadLen)
+	frame[0] = Magic
+	frame[1] = Version
+	frame[2] = HeartbeatMsg
+	return frame
+}
+
+// ReqType returns the request type carried in the header of a complete frame
+func ReqType(frame []byte) uint8 {
+	if len(frame) < FrameHeadLen {
+		return 0
+	}
+	return frame[3]
+}
+
+// SetReqType writes the request type into the header of a complete frame
+func SetReqType(frame []byte, reqType uint8) {
+	if len(frame) < FrameHeadLen {
+		return
+	}
+	frame[3] = reqType
+}
+
+// IsStream reports whether the request type belongs to a streaming call
+func IsStream(reqType uint8) bool {
+	return reqType == ClientStreamReq || reqType == ServerStreamReq || reqType == BidiStreamReq
+}
+
+// StreamID returns the stream ID carried in the header of a complete frame
+func StreamID(frame []byte) uint16 {
+	if len(frame) < FrameHeadLen {
+		return 0
+	}
+	return binary.BigEndian.Uint16(frame[5:7])
+}
+
+// SetStreamID writes the stream ID into the header of a complete frame
+func SetStreamID(frame []byte, streamID uint16) {
+	if len(frame) < FrameHeadLen {
+		return
+	}
+	binary.BigEndian.PutUint16(frame[5:7], streamID)
 }
 
 type defaultCodec struct{}
@@ -20,3 +20,30 @@ func TestDefaultCodec_Decode(t *testing.T) {
 func TestDefaultCodec_Encode(t *testing.T) {
 
 }
+
+func TestStreamID(t *testing.T) {
+	frame, err := DefaultCodec.Encode([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(0), StreamID(frame))
+
+	SetStreamID(frame, 1024)
+	assert.Equal(t, uint16(1024), StreamID(frame))
+
+	payload, err := DefaultCodec.Decode(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(payload))
+}
+
+func TestFrameTypes(t *testing.T) {
+	frame, err := DefaultCodec.Encode([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(GeneralMsg), MsgType(frame))
+	assert.Equal(t, uint8(SendAndRecv), ReqType(frame))
+
+	SetMsgType(frame, StreamEndMsg)
+	SetReqType(frame, BidiStreamReq)
+	assert.Equal(t, uint8(StreamEndMsg), MsgType(frame))
+	assert.Equal(t, uint8(BidiStreamReq), ReqType(frame))
+	assert.True(t, IsStream(ReqType(frame)))
+	assert.False(t, IsStream(SendOnly))
+}
@@ -0,0 +1,104 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Compressor defines the compression of a frame body
+type Compressor interface {
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+// compress types, written into the CompressType of the frame header
+const (
+	CompressTypeNone   = 0x0 // not compression
+	CompressTypeGzip   = 0x1 // gzip
+	CompressTypeSnappy = 0x2 // snappy
+	CompressTypeZstd   = 0x3 // zstd
+)
+
+// MaxPayloadLength 帧体的最大长度，压缩的帧体解压后同样不能超过，避免很小的帧解压出大量数据
+const MaxPayloadLength = 4 * 1024 * 1024
+
+// ErrPayloadTooLarge is returned when a compressed body expands beyond MaxPayloadLength
+var ErrPayloadTooLarge = errors.New("decompressed payload too large")
+
+// DefaultCompressThreshold 小于这个大小的帧不压缩，压缩收益抵不上开销
+const DefaultCompressThreshold = 1024
+
+var compressorMap = make(map[uint8]Compressor)
+
+// RegisterCompressor registers a compressor for a compress type
+func RegisterCompressor(compressType uint8, compressor Compressor) {
+	if compressorMap == nil {
+		compressorMap = make(map[uint8]Compressor)
+	}
+	compressorMap[compressType] = compressor
+}
+
+// GetCompressor get a Compressor by a compress type, nil if it is not registered
+func GetCompressor(compressType uint8) Compressor {
+	return compressorMap[compressType]
+}
+
+// CompressType returns the compress type carried in the header of a complete frame
+func CompressType(frame []byte) uint8 {
+	if len(frame) < FrameHeadLen {
+		return 0
+	}
+	return frame[4]
+}
+
+// SetCompressType writes the compress type into the header of a complete frame
+func SetCompressType(frame []byte, compressType uint8) {
+	if len(frame) < FrameHeadLen {
+		return
+	}
+	frame[4] = compressType
+}
+
+// CompressFrame compresses the body of a complete frame and fills in the header,
+// the frame is returned as it is if its body is smaller than threshold or compression does not make it smaller
+func CompressFrame(frame []byte, compressType uint8, threshold int) ([]byte, error) {
+	if compressType == CompressTypeNone || len(frame) < FrameHeadLen || len(frame)-FrameHeadLen < threshold {
+		return frame, nil
+	}
+
+	compressor := GetCompressor(compressType)
+	if compressor == nil {
+		return nil, fmt.Errorf("compressor %d is not registered", compressType)
+	}
+
+	body, err := compressor.Compress(frame[FrameHeadLen:])
+	if err != nil {
+		return nil, err
+	}
+	if len(body) >= len(frame)-FrameHeadLen {
+		return frame, nil
+	}
+
+	compressed := make([]byte, FrameHeadLen+len(body))
+	copy(compressed, frame[:FrameHeadLen])
+	copy(compressed[FrameHeadLen:], body)
+	SetCompressType(compressed, compressType)
+	binary.BigEndian.PutUint32(compressed[7:11], uint32(len(body)))
+
+	return compressed, nil
+}
+
+// decompress restores the body of a frame according to the compress type in its header
+func decompress(compressType uint8, body []byte) ([]byte, error) {
+	if compressType == CompressTypeNone {
+		return body, nil
+	}
+
+	compressor := GetCompressor(compressType)
+	if compressor == nil {
+		return nil, fmt.Errorf("compressor %d is not registered", compressType)
+	}
+
+	return compressor.Decompress(body)
+}
@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+func init() {
+	RegisterCompressor(CompressTypeGzip, &gzipCompressor{})
+}
+
+// gzipCompressor 复用 gzip.Writer，避免每次压缩都分配
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	w, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buffer)
+	} else {
+		w = gzip.NewWriter(&buffer)
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// 多读一个字节用来判断是否超过限制
+	data, err = ioutil.ReadAll(io.LimitReader(r, MaxPayloadLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxPayloadLength {
+		return nil, ErrPayloadTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,25 @@
+package codec
+
+import "github.com/golang/snappy"
+
+func init() {
+	RegisterCompressor(CompressTypeSnappy, &snappyCompressor{})
+}
+
+type snappyCompressor struct{}
+
+func (c *snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (c *snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	// 解压后的长度记录在数据头部
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxPayloadLength {
+		return nil, ErrPayloadTooLarge
+	}
+	return snappy.Decode(nil, data)
+}
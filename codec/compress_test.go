@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressors(t *testing.T) {
+	data := bytes.Repeat([]byte("novarpc batch export "), 1024)
+
+	for _, compressType := range []uint8{CompressTypeGzip, CompressTypeSnappy, CompressTypeZstd} {
+		compressor := GetCompressor(compressType)
+		assert.NotNil(t, compressor)
+
+		compressed, err := compressor.Compress(data)
+		assert.Nil(t, err)
+		assert.True(t, len(compressed) < len(data))
+
+		decompressed, err := compressor.Decompress(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, data, decompressed)
+	}
+}
+
+func TestDecompressBomb(t *testing.T) {
+	limit := bytes.Repeat([]byte{0}, MaxPayloadLength)
+	bomb := bytes.Repeat([]byte{0}, MaxPayloadLength+1)
+
+	for _, compressType := range []uint8{CompressTypeGzip, CompressTypeSnappy, CompressTypeZstd} {
+		compressor := GetCompressor(compressType)
+
+		compressed, err := compressor.Compress(limit)
+		assert.Nil(t, err)
+		decompressed, err := compressor.Decompress(compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, MaxPayloadLength, len(decompressed))
+
+		// 很小的帧解压后超过限制
+		compressed, err = compressor.Compress(bomb)
+		assert.Nil(t, err)
+		assert.True(t, len(compressed) < MaxPayloadLength/10)
+		_, err = compressor.Decompress(compressed)
+		assert.Equal(t, ErrPayloadTooLarge, err, "compress type %d", compressType)
+	}
+}
+
+func TestCompressFrame(t *testing.T) {
+	data := bytes.Repeat([]byte("novarpc batch export "), 1024)
+
+	frame, err := DefaultCodec.Encode(data)
+	assert.Nil(t, err)
+	SetStreamID(frame, 7)
+
+	compressed, err := CompressFrame(frame, CompressTypeZstd, DefaultCompressThreshold)
+	assert.Nil(t, err)
+	assert.True(t, len(compressed) < len(frame))
+	assert.Equal(t, uint8(CompressTypeZstd), CompressType(compressed))
+	assert.Equal(t, uint16(7), StreamID(compressed))
+	// 原始帧不被修改
+	assert.Equal(t, uint8(CompressTypeNone), CompressType(frame))
+
+	// 接收方按帧头透明解压
+	payload, err := DefaultCodec.Decode(compressed)
+	assert.Nil(t, err)
+	assert.Equal(t, data, payload)
+
+	// 小于阈值的帧不压缩
+	small, err := DefaultCodec.Encode([]byte("hello"))
+	assert.Nil(t, err)
+	uncompressed, err := CompressFrame(small, CompressTypeGzip, DefaultCompressThreshold)
+	assert.Nil(t, err)
+	assert.Equal(t, small, uncompressed)
+
+	_, err = CompressFrame(frame, 0xf, 0)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeUnknownCompressType(t *testing.T) {
+	frame, err := DefaultCodec.Encode([]byte("hello"))
+	assert.Nil(t, err)
+	SetCompressType(frame, 0xf)
+
+	_, err = DefaultCodec.Decode(frame)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,39 @@
+package codec
+
+import (
+	"errors"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	RegisterCompressor(CompressTypeZstd, newZstdCompressor())
+}
+
+// zstdCompressor 的 encoder 和 decoder 都可以并发使用
+type zstdCompressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCompressor() *zstdCompressor {
+	// 参数都是合法的，不会返回错误
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxPayloadLength), zstd.WithDecoderMaxWindow(MaxPayloadLength))
+	return &zstdCompressor{
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
+
+func (c *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	data, err := c.decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, ErrPayloadTooLarge
+	}
+	return data, err
+}
@@ -47,6 +47,16 @@ func GetSerialization(name string) Serialization {
 	return DefaultSerialization
 }
 
+// UnmarshalPayload deserializes the payload of a request or response. A proto message whose fields
+// all have default values is encoded as 0 bytes, such an empty payload resets v instead of failing.
+func UnmarshalPayload(s Serialization, data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok && len(data) == 0 {
+		m.Reset()
+		return nil
+	}
+	return s.Unmarshal(data, v)
+}
+
 type pbSerialization struct{}
 
 func (d *pbSerialization) Marshal(v interface{}) ([]byte, error) {
@@ -1,14 +1,27 @@
 package codes
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
 
 const (
 	OK                           = 0
 	ServerInternalErrorCode      = 100
 	ConfigErrorCode              = 101
+	NotFoundErrorCode            = 102 // 服务或方法不存在
+	DeadlineExceededErrorCode    = 103 // 超时
+	CanceledErrorCode            = 104 // 调用方取消了请求
+	ResourceExhaustedErrorCode   = 105 // 限流或资源耗尽
+	UnimplementedErrorCode       = 106 // 不支持的操作
+	UnknownErrorCode             = 107 // 无法识别的错误
 	NetworkNotSupportedErrorCode = 201
+	UnavailableErrorCode         = 202 // 服务不可用，例如连接失败、连接断开、服务端正在关闭
 	ClientMsgErrorCode           = 301
 	ClientCertFail               = 401
+	PermissionDeniedErrorCode    = 402 // 没有权限
+	UnauthenticatedErrorCode     = 403 // 没有通过认证
 )
 
 // error code type
@@ -30,6 +43,7 @@ type Error struct {
 	Code    uint32
 	Type    int
 	Message string
+	Details []proto.Message // 结构化的错误详情，随响应传给客户端
 }
 
 const (
@@ -11,5 +11,5 @@ func TestCodes(t *testing.T) {
 	assert.Equal(t, err.Type, FrameworkError)
 	err = New(1002, "params error")
 	assert.NotNil(t, err)
-	assert.Equal(t, err.Type, BusinuessError)
+	assert.Equal(t, err.Type, BusyError)
 }
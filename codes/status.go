@@ -0,0 +1,125 @@
+package codes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/xing-you-ji/novarpc/protocol"
+)
+
+// WithDetails returns a copy of e carrying the details, e.g. the field that failed validation or when to retry
+func (e *Error) WithDetails(details ...proto.Message) *Error {
+	err := *e
+	err.Details = append(append([]proto.Message(nil), e.Details...), details...)
+	return &err
+}
+
+// FromError converts err into an *Error. Context errors, network failures and closed connections map to
+// their canonical codes. It returns false with UnknownErrorCode if err cannot be recognized, and nil if err is
+// nil or a nil *Error.
+func FromError(err error) (*Error, bool) {
+	if err == nil {
+		return nil, true
+	}
+
+	// 值为 nil 的 *Error 同样表示成功
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return NewFrameworkError(DeadlineExceededErrorCode, err.Error()), true
+	case errors.Is(err, context.Canceled):
+		return NewFrameworkError(CanceledErrorCode, err.Error()), true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return NewFrameworkError(UnavailableErrorCode, err.Error()), true
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) {
+		// 读写超时同样是超时
+		if ne.Timeout() {
+			return NewFrameworkError(DeadlineExceededErrorCode, err.Error()), true
+		}
+		return NewFrameworkError(UnavailableErrorCode, err.Error()), true
+	}
+
+	return NewFrameworkError(UnknownErrorCode, err.Error()), false
+}
+
+// Code returns the code of err, OK if err is nil
+func Code(err error) uint32 {
+	e, _ := FromError(err)
+	if e == nil {
+		return OK
+	}
+	return e.Code
+}
+
+// EncodeDetails serializes details into protocol.Detail so that they can travel in protocol.Response
+func EncodeDetails(details []proto.Message) ([]*protocol.Detail, error) {
+	if len(details) == 0 {
+		return nil, nil
+	}
+
+	encoded := make([]*protocol.Detail, 0, len(details))
+	for _, detail := range details {
+		value, err := proto.Marshal(detail)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, &protocol.Detail{
+			Type:  proto.MessageName(detail),
+			Value: value,
+		})
+	}
+
+	return encoded, nil
+}
+
+// DecodeDetails restores the details of a response, details whose types are not registered in this binary are skipped
+func DecodeDetails(details []*protocol.Detail) []proto.Message {
+	if len(details) == 0 {
+		return nil
+	}
+
+	decoded := make([]proto.Message, 0, len(details))
+	for _, detail := range details {
+		typ := proto.MessageType(detail.Type)
+		if typ == nil {
+			continue
+		}
+		msg, ok := reflect.New(typ.Elem()).Interface().(proto.Message)
+		if !ok {
+			continue
+		}
+		if err := proto.Unmarshal(detail.Value, msg); err != nil {
+			continue
+		}
+		decoded = append(decoded, msg)
+	}
+
+	return decoded
+}
+
+// FromResponse rebuilds the error carried by a response, nil if the call succeeded
+func FromResponse(response *protocol.Response) error {
+	if response.RetCode == OK {
+		return nil
+	}
+
+	// 保留错误类型：框架错误 or 业务错误
+	e := New(response.RetCode, response.RetMsg)
+	if response.RetType == FrameworkError {
+		e = NewFrameworkError(response.RetCode, response.RetMsg)
+	}
+	e.Details = DecodeDetails(response.Details)
+
+	return e
+}
@@ -0,0 +1,65 @@
+package codes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/protocol"
+)
+
+func TestCode(t *testing.T) {
+	assert.Equal(t, uint32(OK), Code(nil))
+	assert.Equal(t, uint32(1001), Code(New(1001, "user not found")))
+	assert.Equal(t, uint32(NotFoundErrorCode), Code(fmt.Errorf("route: %w", NewFrameworkError(NotFoundErrorCode, "not found"))))
+	assert.Equal(t, uint32(DeadlineExceededErrorCode), Code(context.DeadlineExceeded))
+	assert.Equal(t, uint32(CanceledErrorCode), Code(context.Canceled))
+	assert.Equal(t, uint32(UnavailableErrorCode), Code(io.EOF))
+	assert.Equal(t, uint32(UnavailableErrorCode), Code(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.Equal(t, uint32(UnknownErrorCode), Code(errors.New("unknown")))
+
+	e, ok := FromError(context.DeadlineExceeded)
+	assert.True(t, ok)
+	assert.Equal(t, FrameworkError, e.Type)
+
+	_, ok = FromError(errors.New("unknown"))
+	assert.False(t, ok)
+
+	var typedNil *Error
+	e, ok = FromError(typedNil)
+	assert.True(t, ok)
+	assert.Nil(t, e)
+	assert.Equal(t, uint32(OK), Code(typedNil))
+}
+
+func TestDetailsRoundTrip(t *testing.T) {
+	base := New(1001, "invalid argument")
+	err := base.WithDetails(&protocol.Request{ServicePath: "/user.User/Get"})
+	assert.Nil(t, base.Details)
+
+	details, encodeErr := EncodeDetails(err.Details)
+	assert.Nil(t, encodeErr)
+
+	// 未注册的详情类型被跳过
+	details = append(details, &protocol.Detail{Type: "unknown.Detail"})
+
+	rsp := &protocol.Response{RetCode: err.Code, RetMsg: err.Message, RetType: uint32(err.Type), Details: details}
+	buf, marshalErr := proto.Marshal(rsp)
+	assert.Nil(t, marshalErr)
+	decoded := &protocol.Response{}
+	assert.Nil(t, proto.Unmarshal(buf, decoded))
+
+	got := FromResponse(decoded).(*Error)
+	assert.Equal(t, uint32(1001), got.Code)
+	assert.Equal(t, BusyError, got.Type)
+	assert.Equal(t, 1, len(got.Details))
+	assert.Equal(t, "/user.User/Get", got.Details[0].(*protocol.Request).ServicePath)
+
+	assert.Nil(t, FromResponse(&protocol.Response{}))
+	assert.Equal(t, FrameworkError, FromResponse(&protocol.Response{RetCode: 202, RetType: FrameworkError}).(*Error).Type)
+}
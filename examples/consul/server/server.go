@@ -18,7 +18,7 @@ func main() {
 		novarpc.WithPlugin(consul.Name),
 	}
 	s := novarpc.NewServer(opts...)
-	if err := s.RegisterService("hello.Greeter", new(testdata.Service)); err != nil {
+	if err := s.RegisterService("helloworld.Greeter", new(testdata.Service)); err != nil {
 		panic(err)
 	}
 	s.Serve()
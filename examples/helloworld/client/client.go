@@ -21,6 +21,6 @@ func main() {
 		Msg: "hello",
 	}
 	rsp := &testdata.HelloReply{}
-	err := c.Call(context.Background(), "/user.Greeter/SayHello", req, rsp, opts...)
+	err := c.Call(context.Background(), "/goods.Greeter/SayHello", req, rsp, opts...)
 	fmt.Println(rsp.Msg, err)
 }
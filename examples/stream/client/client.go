@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/xing-you-ji/novarpc/client"
+	"github.com/xing-you-ji/novarpc/testdata"
+)
+
+func main() {
+	opts := []client.Option{
+		client.WithTarget("127.0.0.1:8000"),
+		client.WithNetwork("tcp"),
+		client.WithSerializationType("msgpack"),
+	}
+
+	// desc 为 nil 表示双向流
+	cs, err := client.DefaultClient.NewStream(context.Background(), nil, "/helloworld.Chat/Chat", opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, msg := range []string{"hello", "hi", "hey"} {
+		if err = cs.SendMsg(&testdata.HelloRequest{Msg: msg}); err != nil {
+			panic(err)
+		}
+	}
+	cs.CloseSend()
+
+	for {
+		rsp := &testdata.HelloReply{}
+		err = cs.RecvMsg(rsp)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(rsp.Msg)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xing-you-ji/novarpc"
+	"github.com/xing-you-ji/novarpc/stream"
+	"github.com/xing-you-ji/novarpc/testdata"
+)
+
+type chatService struct{}
+
+// Chat replies to every message until the client closes sending
+func (c *chatService) Chat(ss *stream.ServerStream) error {
+	for {
+		req := &testdata.HelloRequest{}
+		err := ss.RecvMsg(req)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("recv Msg : ", req.Msg)
+		if err = ss.SendMsg(&testdata.HelloReply{Msg: req.Msg + " world"}); err != nil {
+			return err
+		}
+	}
+}
+
+func main() {
+	opts := []novarpc.ServerOption{
+		novarpc.WithAddress("127.0.0.1:8000"),
+		novarpc.WithNetwork("tcp"),
+		novarpc.WithSerializationType("msgpack"),
+	}
+	s := novarpc.NewServer(opts...)
+	if err := s.RegisterService("helloworld.Chat", new(chatService)); err != nil {
+		panic(err)
+	}
+
+	// 启动服务
+	s.Serve()
+}
@@ -0,0 +1,56 @@
+// Package health implements the health checking protocol. Every novarpc Server registers a health service
+// that reports whether it is serving, overall and per service. On the client side client.HealthChecker
+// checks it and takes the nodes that are not serving out of the selector results.
+package health
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// ServiceName is the name of the health service registered on every Server
+const ServiceName = "novarpc.health.v1.Health"
+
+const (
+	CheckPath = "/" + ServiceName + "/Check" // 查询一次服务状态
+	WatchPath = "/" + ServiceName + "/Watch" // 服务端流，状态变化时推送
+)
+
+// ServingStatus is the serving status of a service
+type ServingStatus int32
+
+const (
+	StatusUnknown        ServingStatus = iota // 状态未知
+	StatusServing                             // 正常提供服务
+	StatusNotServing                          // 不提供服务，例如正在关闭
+	StatusServiceUnknown                      // Server 中没有这个服务
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	case StatusServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	}
+	return "UNKNOWN"
+}
+
+// HealthCheckRequest asks for the status of Service, "" means the whole server
+type HealthCheckRequest struct {
+	Service string `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+}
+
+func (m *HealthCheckRequest) Reset()         { *m = HealthCheckRequest{} }
+func (m *HealthCheckRequest) String() string { return proto.CompactTextString(m) }
+func (*HealthCheckRequest) ProtoMessage()    {}
+
+// HealthCheckResponse carries the status of the service
+type HealthCheckResponse struct {
+	Status ServingStatus `protobuf:"varint,1,opt,name=status,proto3,enum=novarpc.health.v1.ServingStatus" json:"status,omitempty"`
+}
+
+func (m *HealthCheckResponse) Reset()         { *m = HealthCheckResponse{} }
+func (m *HealthCheckResponse) String() string { return proto.CompactTextString(m) }
+func (*HealthCheckResponse) ProtoMessage()    {}
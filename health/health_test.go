@@ -0,0 +1,119 @@
+package health
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/stream"
+)
+
+// fakeTransport 记录服务端发送的消息
+type fakeTransport struct {
+	recv [][]byte
+	sent chan []byte
+}
+
+func (t *fakeTransport) Send(data []byte) error {
+	t.sent <- data
+	return nil
+}
+
+func (t *fakeTransport) Recv() ([]byte, error) {
+	if len(t.recv) == 0 {
+		return nil, io.EOF
+	}
+	data := t.recv[0]
+	t.recv = t.recv[1:]
+	return data, nil
+}
+
+func (t *fakeTransport) CloseSend() error {
+	return nil
+}
+
+func TestServerStatus(t *testing.T) {
+	s := NewServer()
+	assert.Equal(t, StatusServing, s.Status(""))
+	assert.Equal(t, StatusServiceUnknown, s.Status("helloworld.Greeter"))
+
+	s.SetServingStatus("helloworld.Greeter", StatusServing)
+	rsp, err := s.Check(context.Background(), &HealthCheckRequest{Service: "helloworld.Greeter"})
+	assert.Nil(t, err)
+	assert.Equal(t, StatusServing, rsp.Status)
+
+	// 关闭后所有服务都不提供服务，并且不再接受更新
+	s.Shutdown()
+	s.SetServingStatus("helloworld.Greeter", StatusServing)
+	assert.Equal(t, StatusNotServing, s.Status(""))
+	assert.Equal(t, StatusNotServing, s.Status("helloworld.Greeter"))
+
+	s.Resume()
+	assert.Equal(t, StatusServing, s.Status("helloworld.Greeter"))
+}
+
+func TestServerWatch(t *testing.T) {
+	s := NewServer()
+	serialization := codec.GetSerialization(codec.Proto)
+
+	// 服务名为空的请求编码后长度为 0
+	req, err := serialization.Marshal(&HealthCheckRequest{Service: "helloworld.Greeter"})
+	assert.Nil(t, err)
+	st := &fakeTransport{recv: [][]byte{req}, sent: make(chan []byte, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, ss := stream.NewStreamingServerStream(ctx)
+	ss.WithTransport(st).WithSerialization(serialization)
+
+	done := make(chan error)
+	go func() {
+		done <- s.Watch(ss)
+	}()
+
+	next := func() ServingStatus {
+		select {
+		case data := <-st.sent:
+			rsp := &HealthCheckResponse{}
+			assert.Nil(t, serialization.Unmarshal(data, rsp))
+			return rsp.Status
+		case <-time.After(time.Second):
+			t.Fatal("no status sent")
+		}
+		return StatusUnknown
+	}
+
+	assert.Equal(t, StatusServiceUnknown, next())
+	s.SetServingStatus("helloworld.Greeter", StatusServing)
+	assert.Equal(t, StatusServing, next())
+	s.SetServingStatus("helloworld.Greeter", StatusNotServing)
+	assert.Equal(t, StatusNotServing, next())
+	s.SetServingStatus("helloworld.Greeter", StatusServing)
+	assert.Equal(t, StatusServing, next())
+
+	// 关闭时推送 NOT_SERVING 并结束流
+	s.Shutdown()
+	assert.Equal(t, StatusNotServing, next())
+	assert.Nil(t, <-done)
+	assert.Empty(t, s.watchers)
+}
+
+func TestMessages(t *testing.T) {
+	for _, name := range []string{codec.Proto, codec.MsgPack} {
+		serialization := codec.GetSerialization(name)
+		data, err := serialization.Marshal(&HealthCheckResponse{Status: StatusNotServing})
+		assert.Nil(t, err)
+		rsp := &HealthCheckResponse{}
+		assert.Nil(t, serialization.Unmarshal(data, rsp))
+		assert.Equal(t, StatusNotServing, rsp.Status)
+	}
+
+	// 默认值的 proto 消息编码后为空
+	data, err := codec.GetSerialization(codec.Proto).Marshal(&HealthCheckRequest{})
+	assert.Nil(t, err)
+	req := &HealthCheckRequest{Service: "stale"}
+	assert.Nil(t, codec.UnmarshalPayload(codec.GetSerialization(codec.Proto), data, req))
+	assert.Equal(t, "", req.Service)
+}
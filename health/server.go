@@ -0,0 +1,142 @@
+package health
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xing-you-ji/novarpc/stream"
+)
+
+// Server keeps the serving status of the services of a novarpc Server and answers Check and Watch
+type Server struct {
+	mu       sync.Mutex
+	shutdown bool                                   // 已经关闭，不再接受状态更新
+	statuses map[string]ServingStatus               // 服务名 -> 状态，"" 表示整个 Server
+	watchers map[string]map[chan ServingStatus]bool // 服务名 -> 正在 Watch 的流
+}
+
+// NewServer creates a Server whose overall status is SERVING
+func NewServer() *Server {
+	return &Server{
+		statuses: map[string]ServingStatus{"": StatusServing},
+		watchers: make(map[string]map[chan ServingStatus]bool),
+	}
+}
+
+// SetServingStatus sets the status of service, "" for the whole server, and notifies the watchers.
+// It does nothing after Shutdown.
+func (s *Server) SetServingStatus(service string, status ServingStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shutdown {
+		return
+	}
+	s.setStatus(service, status)
+}
+
+// Status returns the status of service, StatusServiceUnknown if it is not registered
+func (s *Server) Status(service string) ServingStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if status, ok := s.statuses[service]; ok {
+		return status
+	}
+	return StatusServiceUnknown
+}
+
+// Shutdown sets every service to NOT_SERVING and ignores later updates, watch streams end after
+// sending the last status so they do not hold the graceful shutdown of the server
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shutdown = true
+	for service := range s.statuses {
+		s.setStatus(service, StatusNotServing)
+	}
+}
+
+// Resume sets every service back to SERVING and accepts updates again
+func (s *Server) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.shutdown = false
+	for service := range s.statuses {
+		s.setStatus(service, StatusServing)
+	}
+}
+
+func (s *Server) setStatus(service string, status ServingStatus) {
+	s.statuses[service] = status
+	for ch := range s.watchers[service] {
+		// 只保留最新的状态，慢的读者不会阻塞更新
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- status
+	}
+}
+
+// Check returns the current status of the service, a service the server does not have is SERVICE_UNKNOWN
+func (s *Server) Check(ctx context.Context, req *HealthCheckRequest) (*HealthCheckResponse, error) {
+	return &HealthCheckResponse{Status: s.Status(req.Service)}, nil
+}
+
+// Watch sends the current status of the service and then every change, until the client cancels or
+// the server shuts down. A service the server does not have is reported as SERVICE_UNKNOWN, it is
+// reported again once the service gets a status.
+func (s *Server) Watch(ss *stream.ServerStream) error {
+	req := &HealthCheckRequest{}
+	if err := ss.RecvMsg(req); err != nil {
+		return err
+	}
+
+	ch := make(chan ServingStatus, 1)
+	s.mu.Lock()
+	status, ok := s.statuses[req.Service]
+	if !ok {
+		status = StatusServiceUnknown
+	}
+	ch <- status
+	if s.watchers[req.Service] == nil {
+		s.watchers[req.Service] = make(map[chan ServingStatus]bool)
+	}
+	s.watchers[req.Service][ch] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers[req.Service], ch)
+		if len(s.watchers[req.Service]) == 0 {
+			delete(s.watchers, req.Service)
+		}
+		s.mu.Unlock()
+	}()
+
+	// 相同的状态不重复推送
+	last := StatusUnknown
+	for {
+		select {
+		case status := <-ch:
+			if status != last {
+				if err := ss.SendMsg(&HealthCheckResponse{Status: status}); err != nil {
+					return err
+				}
+				last = status
+			}
+		case <-ss.Context().Done():
+			return nil
+		}
+
+		s.mu.Lock()
+		shutdown := s.shutdown && len(ch) == 0
+		s.mu.Unlock()
+		if shutdown {
+			return nil
+		}
+	}
+}
@@ -51,7 +51,7 @@ func (s *httpServerTransport) ListenAndServe(ctx context.Context, opts ...transp
 
 	s.Server.Handler = DefaultRouter
 	go func() {
-		if err = s.Server.Serve(lis); err != nil {
+		if err = s.Server.Serve(lis); err != nil && err != http.ErrServerClosed {
 			log.Errorf("http serve error, %v", err)
 		}
 	}()
@@ -59,6 +59,11 @@ func (s *httpServerTransport) ListenAndServe(ctx context.Context, opts ...transp
 	return nil
 }
 
+// Shutdown stops the listener and waits for in-flight requests until ctx is done
+func (s *httpServerTransport) Shutdown(ctx context.Context) error {
+	return s.Server.Shutdown(ctx)
+}
+
 // HandlerFunc is an adapter which allows the usage of an http handler
 // request handle.
 func HandleFunc(method, path string, handler func(http.ResponseWriter, *http.Request)) error {
@@ -0,0 +1,114 @@
+package novarpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/xing-you-ji/novarpc/auth"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/limiter"
+	"github.com/xing-you-ji/novarpc/utils"
+)
+
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+type callerRateLimit struct {
+	rateLimit
+	key string // 区分调用方的元数据 key
+}
+
+// serverLimiter 按服务端的限流配置决定是否处理请求
+type serverLimiter struct {
+	buckets     map[string]*limiter.TokenBucket // 服务名或者服务路径 -> 令牌桶
+	callerKey   string
+	callers     *limiter.KeyedBuckets
+	concurrency *limiter.ConcurrencyLimiter
+}
+
+// newServerLimiter returns nil if no limit is set
+func newServerLimiter(opts *ServerOptions) *serverLimiter {
+	if len(opts.rateLimits) == 0 && opts.callerRateLimit == nil && opts.maxConcurrent <= 0 {
+		return nil
+	}
+
+	l := &serverLimiter{
+		buckets: make(map[string]*limiter.TokenBucket),
+	}
+	for name, limit := range opts.rateLimits {
+		l.buckets[name] = limiter.NewTokenBucket(limit.rate, limit.burst)
+	}
+	if c := opts.callerRateLimit; c != nil {
+		l.callerKey = c.key
+		l.callers = limiter.NewKeyedBuckets(c.rate, c.burst)
+	}
+	if opts.maxConcurrent > 0 {
+		if opts.adaptiveConcurrency {
+			l.concurrency = limiter.NewAdaptiveLimiter(opts.maxConcurrent, opts.maxQueue)
+		} else {
+			l.concurrency = limiter.NewConcurrencyLimiter(opts.maxConcurrent, opts.maxQueue)
+		}
+	}
+
+	return l
+}
+
+// allow checks the rate limits of the method and the caller, path is the service path of the request
+func (l *serverLimiter) allow(ctx context.Context, path string, md map[string][]byte) error {
+	serviceName, _, _ := utils.ParseServicePath(path)
+
+	// 方法的限流优先于服务的限流
+	bucket, ok := l.buckets[path]
+	if !ok {
+		bucket, ok = l.buckets[serviceName]
+	}
+	if ok && !bucket.Allow() {
+		return codes.NewFrameworkError(codes.ResourceExhaustedErrorCode, fmt.Sprintf("rate limit of %s exceeded", path))
+	}
+
+	if l.callers == nil {
+		return nil
+	}
+	if caller := callerOf(ctx, md, l.callerKey); !l.callers.Allow(caller) {
+		return codes.NewFrameworkError(codes.ResourceExhaustedErrorCode,
+			fmt.Sprintf("rate limit of caller %s exceeded", caller))
+	}
+
+	return nil
+}
+
+// callerOf 返回调用方的标识，元数据中没有时使用对端的 IP，不同的连接端口属于同一个调用方
+func callerOf(ctx context.Context, md map[string][]byte, key string) string {
+	if caller := md[key]; len(caller) > 0 {
+		return string(caller)
+	}
+
+	p, ok := auth.GetPeer(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+// acquire checks the rate limits and takes a concurrency slot, the returned func releases the slot
+func (l *serverLimiter) acquire(ctx context.Context, path string, md map[string][]byte) (func(), error) {
+	if err := l.allow(ctx, path, md); err != nil {
+		return nil, err
+	}
+
+	if l.concurrency == nil {
+		return func() {}, nil
+	}
+
+	release, err := l.concurrency.Acquire(ctx)
+	if err != nil {
+		return nil, codes.NewFrameworkError(codes.ResourceExhaustedErrorCode, err.Error())
+	}
+	return release, nil
+}
@@ -0,0 +1,162 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded is returned when the concurrency limit is reached and the queue is full
+var ErrLimitExceeded = errors.New("too many concurrent requests")
+
+const (
+	// 自适应模式：延迟超过最小延迟的 tolerance 倍时认为过载
+	tolerance = 2
+	// 过载时限制乘以 backoffRatio
+	backoffRatio = 0.9
+	// 最小延迟的统计窗口，过期后重新统计，适应延迟的正常变化
+	minLatencyWindow = 30 * time.Second
+)
+
+// ConcurrencyLimiter limits the number of requests handled at the same time, requests over the limit
+// wait in a FIFO queue of at most queue requests. In adaptive mode the limit goes down when latency rises
+// above twice the lowest latency seen recently and goes back up while latency is normal (AIMD).
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	max      int
+	queue    int
+	adaptive bool
+	inflight int
+	waiters  []chan struct{} // 排队的请求，有空位时按顺序唤醒
+
+	minLatency   time.Duration // 统计窗口内的最小延迟
+	minLatencyAt time.Time     // 统计窗口的开始时间
+	lastDecrease time.Time     // 上一次降低限制的时间，每个往返时间最多降低一次
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter that handles at most max requests at the same time
+func NewConcurrencyLimiter(max int, queue int) *ConcurrencyLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &ConcurrencyLimiter{
+		limit: float64(max),
+		max:   max,
+		queue: queue,
+	}
+}
+
+// NewAdaptiveLimiter creates a ConcurrencyLimiter whose limit adapts to latency, it never exceeds max
+func NewAdaptiveLimiter(max int, queue int) *ConcurrencyLimiter {
+	l := NewConcurrencyLimiter(max, queue)
+	l.adaptive = true
+	return l
+}
+
+// Acquire takes a slot, waiting in the queue if the limit is reached.
+// The returned func must be called when the request is done.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	if l.inflight < int(l.limit) {
+		l.inflight++
+		l.mu.Unlock()
+		return l.releaseFunc(), nil
+	}
+
+	if len(l.waiters) >= l.queue {
+		l.mu.Unlock()
+		return nil, ErrLimitExceeded
+	}
+
+	wait := make(chan struct{})
+	l.waiters = append(l.waiters, wait)
+	l.mu.Unlock()
+
+	select {
+	case <-wait:
+		return l.releaseFunc(), nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	for i, w := range l.waiters {
+		if w == wait {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	l.mu.Unlock()
+
+	// 已经分配到了空位，归还
+	<-wait
+	l.release(0)
+	return nil, ctx.Err()
+}
+
+func (l *ConcurrencyLimiter) releaseFunc() func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.release(time.Since(start))
+		})
+	}
+}
+
+// release 归还空位，有请求排队时直接交给队首的请求
+func (l *ConcurrencyLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.adaptive && latency > 0 {
+		l.adapt(latency, time.Now())
+	}
+
+	if len(l.waiters) > 0 && l.inflight <= int(l.limit) {
+		wait := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		close(wait)
+		return
+	}
+	l.inflight--
+}
+
+// adapt 延迟正常且并发较高时限制加一，过载时限制按比例降低
+func (l *ConcurrencyLimiter) adapt(latency time.Duration, now time.Time) {
+	if l.minLatency == 0 || latency < l.minLatency || now.Sub(l.minLatencyAt) > minLatencyWindow {
+		l.minLatency = latency
+		l.minLatencyAt = now
+	}
+
+	if latency > tolerance*l.minLatency {
+		if now.Sub(l.lastDecrease) >= latency {
+			l.limit *= backoffRatio
+			if l.limit < 1 {
+				l.limit = 1
+			}
+			l.lastDecrease = now
+		}
+		return
+	}
+
+	if float64(l.inflight)*2 >= l.limit && l.limit < float64(l.max) {
+		l.limit++
+	}
+}
+
+// Limit returns the current limit, e.g. for metrics
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Inflight returns the number of requests being handled
+func (l *ConcurrencyLimiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
@@ -0,0 +1,143 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(100, 2)
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	// 每 10ms 补充一个令牌
+	time.Sleep(15 * time.Millisecond)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+}
+
+func TestKeyedBuckets(t *testing.T) {
+	k := NewKeyedBuckets(1000, 1)
+	assert.True(t, k.Allow("app-a"))
+	assert.False(t, k.Allow("app-a"))
+	assert.True(t, k.Allow("app-b"))
+
+	// 空闲的令牌桶被清理
+	time.Sleep(5 * time.Millisecond)
+	k.mu.Lock()
+	k.prune(time.Now())
+	assert.Empty(t, k.buckets)
+	k.mu.Unlock()
+}
+
+func TestKeyedBucketsMaxKeys(t *testing.T) {
+	k := NewKeyedBuckets(1, 1)
+	k.maxKeys = 3
+	for i := 0; i < 10; i++ {
+		assert.True(t, k.Allow(fmt.Sprintf("app-%d", i)))
+	}
+
+	// 令牌桶的数量不超过上限
+	k.mu.Lock()
+	assert.Len(t, k.buckets, 3)
+	k.mu.Unlock()
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := NewConcurrencyLimiter(2, 1)
+	ctx := context.Background()
+
+	r1, err := l.Acquire(ctx)
+	assert.Nil(t, err)
+	r2, err := l.Acquire(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, l.Inflight())
+
+	// 第三个请求排队，第四个请求被拒绝
+	acquired := make(chan func())
+	go func() {
+		r3, err := l.Acquire(ctx)
+		assert.Nil(t, err)
+		acquired <- r3
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_, err = l.Acquire(ctx)
+	assert.Equal(t, ErrLimitExceeded, err)
+
+	// 有空位时排队的请求继续处理
+	r1()
+	r1()
+	r3 := <-acquired
+	assert.Equal(t, 2, l.Inflight())
+
+	r2()
+	r3()
+	assert.Equal(t, 0, l.Inflight())
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	l := NewConcurrencyLimiter(1, 10)
+	release, err := l.Acquire(context.Background())
+	assert.Nil(t, err)
+
+	// 排队超时
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	release()
+	assert.Equal(t, 0, l.Inflight())
+	assert.Empty(t, l.waiters)
+
+	// 并发获取和释放后状态一致
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			if release, err := l.Acquire(ctx); err == nil {
+				time.Sleep(time.Millisecond)
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 0, l.Inflight())
+	assert.Empty(t, l.waiters)
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := NewAdaptiveLimiter(100, 0)
+	now := time.Now()
+
+	// 延迟正常时保持上限
+	l.inflight = 60
+	l.adapt(10*time.Millisecond, now)
+	assert.Equal(t, 100, l.Limit())
+
+	// 延迟升高时降低限制，每个往返时间最多降低一次
+	l.adapt(50*time.Millisecond, now.Add(time.Second))
+	assert.Equal(t, 90, l.Limit())
+	l.adapt(50*time.Millisecond, now.Add(time.Second+time.Millisecond))
+	assert.Equal(t, 90, l.Limit())
+	l.adapt(50*time.Millisecond, now.Add(2*time.Second))
+	assert.Equal(t, 81, l.Limit())
+
+	// 恢复后逐步提高
+	l.adapt(10*time.Millisecond, now.Add(3*time.Second))
+	assert.Equal(t, 82, l.Limit())
+
+	// 并发很低时不提高
+	l.inflight = 10
+	l.adapt(10*time.Millisecond, now.Add(4*time.Second))
+	assert.Equal(t, 82, l.Limit())
+}
@@ -0,0 +1,137 @@
+// Package limiter provides the building blocks of server side load limiting: token buckets
+// for rate limits and a concurrency limiter with an optional queue and an adaptive limit.
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket allows rate requests per second on average and bursts of up to burst requests
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64   // 每秒补充的令牌数
+	burst  float64   // 桶的容量
+	tokens float64   // 当前的令牌数
+	last   time.Time // 上一次补充令牌的时间
+}
+
+// NewTokenBucket creates a full TokenBucket
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token, it returns false if the bucket is empty
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full 令牌已经补满，说明一段时间没有请求
+func (b *TokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// KeyedBuckets keeps a TokenBucket for every key, e.g. the identity of the caller.
+// Buckets that have been refilled are dropped, so idle keys do not use memory, and at most
+// DefaultMaxKeys buckets are kept, so callers sending many distinct keys cannot exhaust it.
+type KeyedBuckets struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     int
+	maxKeys   int
+	buckets   map[string]*TokenBucket
+	lastPrune time.Time
+}
+
+const (
+	// pruneInterval 清理空闲令牌桶的间隔
+	pruneInterval = time.Minute
+	// fullPruneInterval 令牌桶数量达到上限时清理的最小间隔，避免每个新的 key 都遍历所有令牌桶
+	fullPruneInterval = time.Second
+)
+
+// DefaultMaxKeys is the maximum number of buckets KeyedBuckets keeps
+const DefaultMaxKeys = 10000
+
+// NewKeyedBuckets creates KeyedBuckets whose buckets allow rate requests per second and bursts of burst requests
+func NewKeyedBuckets(rate float64, burst int) *KeyedBuckets {
+	return &KeyedBuckets{
+		rate:      rate,
+		burst:     burst,
+		maxKeys:   DefaultMaxKeys,
+		buckets:   make(map[string]*TokenBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key
+func (k *KeyedBuckets) Allow(key string) bool {
+	k.mu.Lock()
+	now := time.Now()
+	if now.Sub(k.lastPrune) > pruneInterval {
+		k.prune(now)
+	}
+	b, ok := k.buckets[key]
+	if !ok {
+		if len(k.buckets) >= k.maxKeys {
+			k.evict(now)
+		}
+		b = NewTokenBucket(k.rate, k.burst)
+		k.buckets[key] = b
+	}
+	k.mu.Unlock()
+
+	return b.Allow()
+}
+
+// evict 令牌桶数量达到上限时先清理空闲的令牌桶，仍然达到上限时随机淘汰一个
+func (k *KeyedBuckets) evict(now time.Time) {
+	if now.Sub(k.lastPrune) > fullPruneInterval {
+		k.prune(now)
+	}
+	for key := range k.buckets {
+		if len(k.buckets) < k.maxKeys {
+			return
+		}
+		delete(k.buckets, key)
+	}
+}
+
+func (k *KeyedBuckets) prune(now time.Time) {
+	for key, b := range k.buckets {
+		if b.full(now) {
+			delete(k.buckets, key)
+		}
+	}
+	k.lastPrune = now
+}
@@ -1,6 +1,11 @@
 package metadata
 
-import "context"
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
 
 type clientMD struct{}
 type serverMD struct{}
@@ -38,3 +43,74 @@ func ServerMetadata(ctx context.Context) serverMetadata {
 func WithServerMetadata(ctx context.Context, metadata map[string][]byte) context.Context {
 	return context.WithValue(ctx, serverMD{}, serverMetadata(metadata))
 }
+
+// TimeoutKey is the metadata key carrying the time the caller has left, in milliseconds
+const TimeoutKey = "novarpc-timeout"
+
+// SetTimeout writes the time the caller has left into the request metadata, it is at least 1ms
+func SetTimeout(md map[string][]byte, timeout time.Duration) {
+	ms := timeout.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	md[TimeoutKey] = []byte(strconv.FormatInt(ms, 10))
+}
+
+// Timeout returns the time the caller has left, false if the request does not carry one
+func Timeout(md map[string][]byte) (time.Duration, bool) {
+	v, ok := md[TimeoutKey]
+	if !ok {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil || ms <= 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+type responseMD struct{}
+
+// responseMetadata is filled by handlers and interceptors, possibly from several goroutines
+type responseMetadata struct {
+	mu sync.Mutex
+	md map[string][]byte
+}
+
+// WithResponseMetadata creates a new context carrying empty response metadata,
+// the server transport does it before calling the handler and sends the metadata back with the response
+func WithResponseMetadata(ctx context.Context) context.Context {
+	return context.WithValue(ctx, responseMD{}, &responseMetadata{md: make(map[string][]byte)})
+}
+
+// SetResponseMetadata sets a key-value pair sent back to the client with the response,
+// it returns false if the context is not the one of a request being handled
+func SetResponseMetadata(ctx context.Context, key string, value []byte) bool {
+	rmd, ok := ctx.Value(responseMD{}).(*responseMetadata)
+	if !ok {
+		return false
+	}
+	rmd.mu.Lock()
+	rmd.md[key] = value
+	rmd.mu.Unlock()
+	return true
+}
+
+// ResponseMetadata returns a copy of the response metadata set so far, nil if there is none
+func ResponseMetadata(ctx context.Context) map[string][]byte {
+	rmd, ok := ctx.Value(responseMD{}).(*responseMetadata)
+	if !ok {
+		return nil
+	}
+	rmd.mu.Lock()
+	defer rmd.mu.Unlock()
+
+	if len(rmd.md) == 0 {
+		return nil
+	}
+	md := make(map[string][]byte, len(rmd.md))
+	for k, v := range rmd.md {
+		md[k] = v
+	}
+	return md
+}
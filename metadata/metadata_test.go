@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestClientMetadata(t *testing.T) {
@@ -27,3 +28,41 @@ func TestServerMetadata(t *testing.T) {
 	md = ServerMetadata(newCtx)
 	assert.Equal(t, string(md["test"]), "test_server_metadata")
 }
+
+func TestTimeout(t *testing.T) {
+	md := map[string][]byte{}
+	_, ok := Timeout(md)
+	assert.False(t, ok)
+
+	SetTimeout(md, 1500*time.Millisecond)
+	timeout, ok := Timeout(md)
+	assert.True(t, ok)
+	assert.Equal(t, 1500*time.Millisecond, timeout)
+
+	// 剩余时间不足 1ms 时按 1ms 传递
+	SetTimeout(md, time.Microsecond)
+	timeout, ok = Timeout(md)
+	assert.True(t, ok)
+	assert.Equal(t, time.Millisecond, timeout)
+
+	md[TimeoutKey] = []byte("abc")
+	_, ok = Timeout(md)
+	assert.False(t, ok)
+}
+
+func TestResponseMetadata(t *testing.T) {
+	// 不是正在处理的请求的上下文
+	assert.False(t, SetResponseMetadata(context.Background(), "cursor", []byte("10")))
+	assert.Nil(t, ResponseMetadata(context.Background()))
+
+	ctx := WithResponseMetadata(context.Background())
+	assert.Nil(t, ResponseMetadata(ctx))
+
+	assert.True(t, SetResponseMetadata(ctx, "cursor", []byte("10")))
+	md := ResponseMetadata(ctx)
+	assert.Equal(t, "10", string(md["cursor"]))
+
+	// 返回的是副本
+	md["cursor"] = []byte("20")
+	assert.Equal(t, "10", string(ResponseMetadata(ctx)["cursor"]))
+}
@@ -1,6 +1,7 @@
 package novarpc
 
 import (
+	"github.com/xing-you-ji/novarpc/auth"
 	"github.com/xing-you-ji/novarpc/interceptor"
 	"time"
 )
@@ -12,12 +13,30 @@ type ServerOptions struct {
 	protocol          string        // 协议类型
 	timeout           time.Duration // timeout
 	serializationType string        // 请求体序列化协议
+	shutdownTimeout   time.Duration // 收到关闭信号后等待请求处理完成的最长时间，0 表示一直等待
 
 	selectorSvrAddr string   // service discovery server address, required when using the third-party service discovery plugin
 	tracingSvrAddr  string   // tracing plugin server address, required when using the third-party tracing plugin
 	tracingSpanName string   // tracing span name, required when using the third-party tracing plugin
 	pluginNames     []string // plugin name
 	interceptors    []interceptor.ServerInterceptor
+
+	transportAuth auth.TransportAuth // 连接握手，例如 TLS / mTLS，nil 表示明文
+
+	compressType      uint8 // 响应体压缩类型，例如 codec.CompressTypeGzip，默认不压缩
+	compressThreshold int   // 响应体小于这个大小时不压缩
+
+	weight     *int              // 发布到服务发现的节点权重，用于灰度发布，nil 表示没有设置
+	tags       []string          // 发布到服务发现的节点标签，例如 canary
+	attributes map[string]string // 发布到服务发现的节点属性，例如 zone、version
+	ttl        time.Duration     // 注册信息的存活时间，节点在这个时间内没有续约时被服务发现摘除
+	check      string            // 服务发现检查节点的方式，例如 consul.CheckTCP
+
+	rateLimits          map[string]rateLimit // 服务或方法的限流，key 为服务名或者服务路径
+	callerRateLimit     *callerRateLimit     // 按调用方限流
+	maxConcurrent       int                  // 同时处理的最大请求数，0 表示不限制
+	maxQueue            int                  // 超过并发限制时最多排队的请求数
+	adaptiveConcurrency bool                 // 延迟升高时自动降低并发限制
 }
 
 // option function
@@ -51,6 +70,13 @@ func WithTimeout(timeout time.Duration) ServerOption {
 	}
 }
 
+// WithShutdownTimeout set the longest time to wait for in-flight requests when shutting down on a signal
+func WithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.shutdownTimeout = timeout
+	}
+}
+
 // WithSerializationType set server serialization type
 func WithSerializationType(serializationType string) ServerOption {
 	return func(o *ServerOptions) {
@@ -92,3 +118,102 @@ func WithTracingSpanName(name string) ServerOption {
 		o.tracingSpanName = name
 	}
 }
+
+// WithTransportAuth set the handshake of every connection, e.g. auth.NewServerTLSAuthFromFile
+func WithTransportAuth(transportAuth auth.TransportAuth) ServerOption {
+	return func(o *ServerOptions) {
+		o.transportAuth = transportAuth
+	}
+}
+
+// WithCompressType set the compress type of responses, e.g. codec.CompressTypeGzip
+func WithCompressType(compressType uint8) ServerOption {
+	return func(o *ServerOptions) {
+		o.compressType = compressType
+	}
+}
+
+// WithCompressThreshold set the size below which responses are sent uncompressed
+func WithCompressThreshold(threshold int) ServerOption {
+	return func(o *ServerOptions) {
+		o.compressThreshold = threshold
+	}
+}
+
+// WithWeight set the weight of the server published to service discovery, selector.DefaultWeight is used if not set,
+// 0 drains the server, e.g. to take a canary out
+func WithWeight(weight int) ServerOption {
+	return func(o *ServerOptions) {
+		o.weight = &weight
+	}
+}
+
+// WithTags set the tags of the server published to service discovery, e.g. canary
+func WithTags(tags ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+// WithAttribute set an attribute of the server published to service discovery, e.g. zone, version
+func WithAttribute(key string, value string) ServerOption {
+	return func(o *ServerOptions) {
+		if o.attributes == nil {
+			o.attributes = make(map[string]string)
+		}
+		o.attributes[key] = value
+	}
+}
+
+// WithRegistryTTL set how long the registration of the server lives without being refreshed, the plugin
+// refreshes it in the background and service discovery removes the server once it stops, e.g. after a crash
+func WithRegistryTTL(ttl time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithRegistryHealthCheck set how service discovery checks the server, e.g. consul.CheckTTL, consul.CheckTCP
+func WithRegistryHealthCheck(check string) ServerOption {
+	return func(o *ServerOptions) {
+		o.check = check
+	}
+}
+
+// WithRateLimit limits the requests of a service, e.g. helloworld.Greeter, or of a method,
+// e.g. /helloworld.Greeter/SayHello, to rate per second with bursts of burst requests.
+// Requests over the limit fail with codes.ResourceExhaustedErrorCode.
+func WithRateLimit(name string, rate float64, burst int) ServerOption {
+	return func(o *ServerOptions) {
+		if o.rateLimits == nil {
+			o.rateLimits = make(map[string]rateLimit)
+		}
+		o.rateLimits[name] = rateLimit{rate: rate, burst: burst}
+	}
+}
+
+// WithCallerRateLimit limits the requests of every caller to rate per second with bursts of burst requests,
+// callers are told apart by the value of the metadata key, e.g. an app ID set by the client,
+// or by the IP of the connection when the key is missing
+func WithCallerRateLimit(metadataKey string, rate float64, burst int) ServerOption {
+	return func(o *ServerOptions) {
+		o.callerRateLimit = &callerRateLimit{key: metadataKey, rateLimit: rateLimit{rate: rate, burst: burst}}
+	}
+}
+
+// WithMaxConcurrentRequests limits the number of requests handled at the same time, at most queue requests
+// wait for a slot until their deadline, the others fail with codes.ResourceExhaustedErrorCode
+func WithMaxConcurrentRequests(max int, queue int) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxConcurrent = max
+		o.maxQueue = queue
+	}
+}
+
+// WithAdaptiveConcurrency lowers the concurrency limit set by WithMaxConcurrentRequests when latency rises,
+// so that an overloaded server sheds load instead of queuing it
+func WithAdaptiveConcurrency(adaptive bool) ServerOption {
+	return func(o *ServerOptions) {
+		o.adaptiveConcurrency = adaptive
+	}
+}
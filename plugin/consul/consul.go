@@ -1,36 +1,69 @@
 package consul
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"net"
 	"net/http"
-	"strings"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/xing-you-ji/novarpc/plugin"
 	"github.com/xing-you-ji/novarpc/selector"
+	"go.uber.org/zap"
 )
 
 // Consul implements the server discovery specification
 type Consul struct {
-	opts         *plugin.Options
-	client       *api.Client
-	config       *api.Config
-	balancerName string // 负载均衡算法：目前支持随机、轮询、
-	writeOptions *api.WriteOptions
-	queryOptions *api.QueryOptions
+	*selector.RegistrySelector // 缓存服务的节点，通过阻塞查询更新
+
+	opts   *plugin.Options
+	client *api.Client
+	config *api.Config
+
+	mu            sync.Mutex
+	registrations []*api.AgentServiceRegistration // Register 注册的服务，DeRegister 时注销
+	stop          chan struct{}                   // 停止 TTL 心跳
 }
 
 const Name = "consul"
 
+// consul 检查节点的方式
+const (
+	CheckTTL = "ttl" // 节点定期刷新 TTL 检查，默认
+	CheckTCP = "tcp" // consul agent 定期连接节点的地址
+)
+
+const (
+	defaultTTL = 10 * time.Second
+	// deregisterAfter 检查失败超过这个时间后 consul 注销服务，例如节点崩溃后没有注销
+	deregisterAfter = time.Minute
+	// watchWaitTime 阻塞查询的最长等待时间
+	watchWaitTime = 5 * time.Minute
+	// zeroWeightKey 权重为 0 的节点在 Meta 中的标记，consul 的权重不能小于 1
+	zeroWeightKey = "novarpc-zero-weight"
+)
+
+// minHeartbeatInterval 心跳的最小间隔，TTL 很短时避免频繁请求 agent
+var minHeartbeatInterval = time.Second
+
 func init() {
 	plugin.Register(Name, ConsulSvr)
 	selector.RegisterSelector(Name, ConsulSvr)
 }
 
 // global consul objects for framework
-var ConsulSvr = &Consul{
-	opts: &plugin.Options{},
+var ConsulSvr = newConsul(&plugin.Options{})
+
+// newConsul creates a Consul that selects nodes with the balancer named by opts.BalancerName
+func newConsul(opts *plugin.Options) *Consul {
+	c := &Consul{opts: opts}
+	c.RegistrySelector = selector.NewRegistrySelector(c, func() string {
+		return c.opts.BalancerName
+	})
+	return c
 }
 
 func (c *Consul) InitConfig() error {
@@ -52,55 +85,139 @@ func (c *Consul) InitConfig() error {
 	return nil
 }
 
+// Resolve queries consul for the instances of the service whose health checks are passing,
+// Select uses the nodes cached by a watch instead
 func (c *Consul) Resolve(serviceName string) ([]*selector.Node, error) {
 
-	pairs, _, err := c.client.KV().List(serviceName, nil)
+	nodes, _, err := c.Watch(context.Background(), serviceName, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(pairs) == 0 {
+	if len(nodes) == 0 {
 		return nil, fmt.Errorf("no services find in path : %s", serviceName)
 	}
+	return nodes, nil
+}
+
+// Watch implements selector.Registry with a blocking query, version is the consul index
+func (c *Consul) Watch(ctx context.Context, serviceName string, version uint64) ([]*selector.Node, uint64, error) {
+
+	q := &api.QueryOptions{WaitIndex: version, WaitTime: watchWaitTime}
+	entries, meta, err := c.client.Health().Service(serviceName, "", true, q.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// index 变小时（例如 consul 集群重建）从头开始查询
+	index := meta.LastIndex
+	if index < version {
+		index = 0
+	}
+
 	var nodes []*selector.Node
-	for _, pair := range pairs {
-		nodes = append(nodes, &selector.Node{
-			Key:   pair.Key,
-			Value: pair.Value,
-		})
+	for _, entry := range entries {
+		nodes = append(nodes, decodeNode(entry))
 	}
-	return nodes, nil
+	return nodes, index, nil
 }
 
-// implements selector Select method
-func (c *Consul) Select(serviceName string) (string, error) {
+// newRegistration 节点的权重、标签和属性分别对应 consul 服务的 Weights、Tags 和 Meta
+func newRegistration(serviceName string, opts *plugin.Options) (*api.AgentServiceRegistration, error) {
+	host, portStr, err := net.SplitHostPort(opts.SvrAddr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
 
-	nodes, err := c.Resolve(serviceName)
+	weight, meta := selector.DefaultWeight, opts.Attributes
+	if opts.Weight != nil {
+		weight = *opts.Weight
+	}
+	// consul 的权重至少是 1，不分配流量的节点在 Meta 中标记权重 0
+	if weight <= 0 {
+		meta = make(map[string]string, len(opts.Attributes)+1)
+		for k, v := range opts.Attributes {
+			meta[k] = v
+		}
+		meta[zeroWeightKey] = "true"
+		weight = 1
+	}
 
-	if nodes == nil || len(nodes) == 0 || err != nil {
-		return "", err
+	ttl := ttlOf(opts)
+	id := fmt.Sprintf("%s-%s", serviceName, opts.SvrAddr)
+	check := &api.AgentServiceCheck{
+		CheckID:                        checkID(id),
+		DeregisterCriticalServiceAfter: deregisterAfter.String(),
+	}
+	switch opts.HealthCheck {
+	case CheckTCP:
+		check.TCP = opts.SvrAddr
+		check.Interval = ttl.String()
+		check.Timeout = ttl.String()
+	case "", CheckTTL:
+		check.TTL = ttl.String()
+	default:
+		return nil, fmt.Errorf("consul health check %s not supported", opts.HealthCheck)
 	}
 
-	balancer := selector.GetBalancer(c.balancerName)
-	node := balancer.Balance(serviceName, nodes)
+	return &api.AgentServiceRegistration{
+		ID:      id,
+		Name:    serviceName,
+		Address: host,
+		Port:    port,
+		Tags:    opts.Tags,
+		Meta:    meta,
+		Weights: &api.AgentWeights{Passing: weight, Warning: 1},
+		Check:   check,
+	}, nil
+}
 
-	if node == nil {
-		return "", fmt.Errorf("no services find in %s", serviceName)
+func ttlOf(opts *plugin.Options) time.Duration {
+	if opts.TTL > 0 {
+		return opts.TTL
 	}
+	return defaultTTL
+}
 
-	return parseAddrFromNode(node)
+func checkID(serviceID string) string {
+	return "service:" + serviceID
 }
 
-func parseAddrFromNode(node *selector.Node) (string, error) {
-	if node.Key == "" {
-		return "", errors.New("addr is empty")
+func decodeNode(entry *api.ServiceEntry) *selector.Node {
+	service := entry.Service
+
+	// 没有指定地址的服务使用 consul agent 所在节点的地址
+	host := service.Address
+	if host == "" && entry.Node != nil {
+		host = entry.Node.Address
 	}
 
-	strs := strings.Split(node.Key, "/")
+	weight, meta := service.Weights.Passing, service.Meta
+	if _, ok := meta[zeroWeightKey]; ok {
+		weight = 0
+		meta = make(map[string]string, len(service.Meta))
+		for k, v := range service.Meta {
+			if k != zeroWeightKey {
+				meta[k] = v
+			}
+		}
+	}
 
-	return strs[len(strs)-1], nil
+	return &selector.Node{
+		Key:        service.ID,
+		Address:    net.JoinHostPort(host, strconv.Itoa(service.Port)),
+		Weight:     selector.WeightOf(weight),
+		Tags:       service.Tags,
+		Attributes: meta,
+	}
 }
 
+// Register registers every service of the server to the local consul agent with a health check,
+// the TTL check is refreshed in the background until DeRegister
 func (c *Consul) Register(opts ...plugin.Option) error {
 
 	for _, o := range opts {
@@ -112,33 +229,129 @@ func (c *Consul) Register(opts ...plugin.Option) error {
 			len(c.opts.Services), c.opts.SvrAddr, c.opts.SelectorSvrAddr)
 	}
 
-	if err := c.InitConfig(); err != nil {
-		return err
+	// 重复注册时复用已有的 client，心跳还在使用它
+	if c.config == nil || c.config.Address != c.opts.SelectorSvrAddr {
+		if err := c.InitConfig(); err != nil {
+			return err
+		}
 	}
 
+	var registrations []*api.AgentServiceRegistration
 	for _, serviceName := range c.opts.Services {
-		nodeName := fmt.Sprintf("%s/%s", serviceName, c.opts.SvrAddr)
-
-		kvPair := &api.KVPair{
-			Key:   nodeName,
-			Value: []byte(c.opts.SvrAddr),
-			Flags: api.LockFlagValue,
+		registration, err := newRegistration(serviceName, c.opts)
+		if err != nil {
+			return err
 		}
+		registrations = append(registrations, registration)
+	}
 
-		if _, err := c.client.KV().Put(kvPair, c.writeOptions); err != nil {
+	for _, registration := range registrations {
+		if err := c.register(registration); err != nil {
 			return err
 		}
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 重复注册同一个服务时替换之前的注册信息，心跳只有一个
+	for _, registration := range registrations {
+		replaced := false
+		for i, r := range c.registrations {
+			if r.ID == registration.ID {
+				c.registrations[i], replaced = registration, true
+				break
+			}
+		}
+		if !replaced {
+			c.registrations = append(c.registrations, registration)
+		}
+	}
+
+	if c.stop == nil && registrations[0].Check.TTL != "" {
+		c.stop = make(chan struct{})
+		go c.heartbeat(c.stop, heartbeatInterval(ttlOf(c.opts)))
+	}
+
 	return nil
 }
 
-func (c *Consul) DeRegister() error {
-	if _, err := c.client.KV().Delete(c.opts.SvrAddr, c.writeOptions); err != nil {
+// register 注册服务，TTL 检查注册后立即置为 passing，不需要等第一次心跳
+func (c *Consul) register(registration *api.AgentServiceRegistration) error {
+	if err := c.client.Agent().ServiceRegister(registration); err != nil {
 		return err
 	}
+	if registration.Check.TTL == "" {
+		return nil
+	}
+	return c.client.Agent().UpdateTTL(registration.Check.CheckID, "", api.HealthPassing)
+}
 
-	return nil
+// heartbeatInterval 每隔 TTL 的三分之一刷新一次 TTL 检查，不小于 minHeartbeatInterval
+func heartbeatInterval(ttl time.Duration) time.Duration {
+	if interval := ttl / 3; interval > minHeartbeatInterval {
+		return interval
+	}
+	return minHeartbeatInterval
+}
+
+// heartbeat 定期刷新 TTL 检查，consul agent 丢失了注册信息时重新注册，例如 agent 重启
+func (c *Consul) heartbeat(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// 请求 agent 时不持有锁，不阻塞 Register 和 DeRegister
+		c.mu.Lock()
+		registrations := append([]*api.AgentServiceRegistration(nil), c.registrations...)
+		c.mu.Unlock()
+
+		for _, registration := range registrations {
+			err := c.client.Agent().UpdateTTL(registration.Check.CheckID, "", api.HealthPassing)
+			if err == nil {
+				continue
+			}
+			zap.L().Warn("consul update ttl failed, register again", zap.String("service", registration.ID), zap.Error(err))
+			if err = c.register(registration); err != nil {
+				zap.L().Error("consul register failed", zap.String("service", registration.ID), zap.Error(err))
+			}
+
+			// 重新注册期间已经注销，删除重新注册的服务
+			select {
+			case <-stop:
+				c.client.Agent().ServiceDeregister(registration.ID)
+				return
+			default:
+			}
+		}
+	}
+}
+
+// DeRegister stops the heartbeat and removes the services registered by Register from the consul agent
+func (c *Consul) DeRegister() error {
+	c.mu.Lock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	registrations := c.registrations
+	c.registrations = nil
+	c.mu.Unlock()
+
+	var err error
+	for _, registration := range registrations {
+		if e := c.client.Agent().ServiceDeregister(registration.ID); e != nil {
+			err = e
+		}
+	}
+
+	return err
 }
 
 // Init implements the initialization of the consul configuration when the framework is loaded
@@ -1,9 +1,19 @@
 package consul
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/plugin"
+	"github.com/xing-you-ji/novarpc/selector"
 )
 
 func TestInit(t *testing.T) {
@@ -11,3 +21,396 @@ func TestInit(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestNodeInfo(t *testing.T) {
+	opts := &plugin.Options{}
+	for _, o := range []plugin.Option{
+		plugin.WithSvrAddr("127.0.0.1:8000"),
+		plugin.WithWeight(5),
+		plugin.WithTags([]string{"canary"}),
+		plugin.WithAttributes(map[string]string{"zone": "sh-1", "version": "v2"}),
+	} {
+		o(opts)
+	}
+
+	registration, err := newRegistration("Greeter", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "Greeter-127.0.0.1:8000", registration.ID)
+	assert.Equal(t, "10s", registration.Check.TTL)
+
+	node := decodeNode(&api.ServiceEntry{Service: &api.AgentService{
+		ID:      registration.ID,
+		Address: registration.Address,
+		Port:    registration.Port,
+		Weights: *registration.Weights,
+		Tags:    registration.Tags,
+		Meta:    registration.Meta,
+	}})
+	assert.Equal(t, "127.0.0.1:8000", node.Address)
+	assert.Equal(t, 5, node.EffectiveWeight())
+	assert.Equal(t, []string{"canary"}, node.Tags)
+	assert.Equal(t, "v2", node.Attributes["version"])
+
+	// 没有指定地址的服务使用 agent 所在节点的地址，没有设置权重时使用默认权重
+	opts = &plugin.Options{SvrAddr: ":8001", HealthCheck: CheckTCP}
+	registration, err = newRegistration("Greeter", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, ":8001", registration.Check.TCP)
+	node = decodeNode(&api.ServiceEntry{
+		Node:    &api.Node{Address: "10.0.0.1"},
+		Service: &api.AgentService{ID: registration.ID, Port: registration.Port, Weights: *registration.Weights},
+	})
+	assert.Equal(t, "10.0.0.1:8001", node.Address)
+	assert.Equal(t, selector.DefaultWeight, node.EffectiveWeight())
+
+	assert.Equal(t, "10.0.0.1:8001", node.Addr())
+
+	// 权重 0 通过 Meta 传递，不会出现在节点属性中
+	opts = &plugin.Options{SvrAddr: "127.0.0.1:8002", Weight: selector.WeightOf(0), Attributes: map[string]string{"zone": "sh-1"}}
+	registration, err = newRegistration("Greeter", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, registration.Weights.Passing)
+	assert.Len(t, opts.Attributes, 1)
+	node = decodeNode(&api.ServiceEntry{Service: &api.AgentService{
+		ID:      registration.ID,
+		Port:    registration.Port,
+		Weights: *registration.Weights,
+		Meta:    registration.Meta,
+	}})
+	assert.Equal(t, 0, node.EffectiveWeight())
+	assert.Equal(t, map[string]string{"zone": "sh-1"}, node.Attributes)
+
+	_, err = newRegistration("Greeter", &plugin.Options{SvrAddr: ":8001", HealthCheck: "http"})
+	assert.NotNil(t, err)
+}
+
+// fakeConsul 实现了 consul agent 注册、注销、TTL 检查和健康服务阻塞查询的 HTTP 接口
+type fakeConsul struct {
+	mu         sync.Mutex
+	services   map[string]*api.AgentServiceRegistration
+	status     map[string]string // checkID -> 检查状态
+	ttlUpdates int
+	index      uint64        // 每次变化加一
+	changed    chan struct{} // 变化时关闭，唤醒阻塞查询
+	queries    int
+	block      chan struct{} // 不为 nil 时 TTL 检查的刷新等到它关闭，模拟很慢的 agent
+	blocked    int           // 正在等待 block 的刷新
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		services: make(map[string]*api.AgentServiceRegistration),
+		status:   make(map[string]string),
+		index:    1,
+		changed:  make(chan struct{}),
+	}
+}
+
+// touch 在持有锁时调用
+func (f *fakeConsul) touch() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPut && path == "/v1/agent/service/register":
+		registration := &api.AgentServiceRegistration{}
+		if err := json.NewDecoder(r.Body).Decode(registration); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.services[registration.ID] = registration
+		// 新注册的检查是 critical，TTL 刷新或者 TCP 检查成功后才是 passing
+		f.status[registration.Check.CheckID] = api.HealthCritical
+		f.touch()
+
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(path, "/v1/agent/service/deregister/")
+		delete(f.services, id)
+		delete(f.status, checkID(id))
+		f.touch()
+
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/agent/check/update/"):
+		id := strings.TrimPrefix(path, "/v1/agent/check/update/")
+		if block := f.block; block != nil {
+			f.blocked++
+			f.mu.Unlock()
+			<-block
+			f.mu.Lock()
+		}
+		if _, ok := f.status[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		update := &struct{ Status string }{}
+		json.NewDecoder(r.Body).Decode(update)
+		if f.status[id] != update.Status {
+			f.status[id] = update.Status
+			f.touch()
+		}
+		f.ttlUpdates++
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/v1/health/service/"):
+		name := strings.TrimPrefix(path, "/v1/health/service/")
+		f.queries++
+		// 阻塞查询：等到 index 变化
+		waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		for f.index <= waitIndex {
+			changed := f.changed
+			f.mu.Unlock()
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				f.mu.Lock()
+				return
+			}
+			f.mu.Lock()
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+
+		entries := []*api.ServiceEntry{}
+		for id, registration := range f.services {
+			status := f.status[registration.Check.CheckID]
+			if registration.Name != name || (r.URL.Query().Get("passing") != "" && status != api.HealthPassing) {
+				continue
+			}
+			entries = append(entries, &api.ServiceEntry{
+				Node: &api.Node{Address: "127.0.0.1"},
+				Service: &api.AgentService{
+					ID:      id,
+					Service: registration.Name,
+					Address: registration.Address,
+					Port:    registration.Port,
+					Tags:    registration.Tags,
+					Meta:    registration.Meta,
+					Weights: *registration.Weights,
+				},
+				Checks: api.HealthChecks{{CheckID: registration.Check.CheckID, Status: status}},
+			})
+		}
+		json.NewEncoder(w).Encode(entries)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsul) setStatus(id string, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status[id] = status
+	f.touch()
+}
+
+// restart 模拟 agent 重启，丢失所有注册信息
+func (f *fakeConsul) restart() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.services = make(map[string]*api.AgentServiceRegistration)
+	f.status = make(map[string]string)
+	f.touch()
+}
+
+func (f *fakeConsul) updates() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ttlUpdates
+}
+
+// shortHeartbeat 测试中使用很短的 TTL，放开心跳的最小间隔
+func shortHeartbeat(t *testing.T) {
+	interval := minHeartbeatInterval
+	minHeartbeatInterval = 10 * time.Millisecond
+	t.Cleanup(func() { minHeartbeatInterval = interval })
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Second, heartbeatInterval(30*time.Second))
+	assert.Equal(t, minHeartbeatInterval, heartbeatInterval(time.Second))
+	assert.Equal(t, minHeartbeatInterval, heartbeatInterval(time.Nanosecond))
+}
+
+func TestRegister(t *testing.T) {
+	shortHeartbeat(t)
+	fake := newFakeConsul()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	consulAddr := strings.TrimPrefix(ts.URL, "http://")
+
+	c := newConsul(&plugin.Options{})
+	err := c.Register(
+		plugin.WithSelectorSvrAddr(consulAddr),
+		plugin.WithSvrAddr("127.0.0.1:8000"),
+		plugin.WithServices([]string{"helloworld.Greeter"}),
+		plugin.WithWeight(5),
+		plugin.WithTags([]string{"canary"}),
+		plugin.WithAttributes(map[string]string{"zone": "sh-1"}),
+		plugin.WithTTL(30*time.Millisecond))
+	assert.Nil(t, err)
+
+	nodes, err := c.Resolve("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, "127.0.0.1:8000", nodes[0].Addr())
+	assert.Equal(t, 5, nodes[0].EffectiveWeight())
+	assert.Equal(t, []string{"canary"}, nodes[0].Tags)
+	assert.Equal(t, "sh-1", nodes[0].Attributes["zone"])
+
+	// TCP 检查由 agent 完成，检查通过之前不会被选择
+	tcp := newConsul(&plugin.Options{})
+	err = tcp.Register(
+		plugin.WithSelectorSvrAddr(consulAddr),
+		plugin.WithSvrAddr("127.0.0.1:8001"),
+		plugin.WithServices([]string{"helloworld.Greeter"}),
+		plugin.WithHealthCheck(CheckTCP))
+	assert.Nil(t, err)
+	nodes, err = c.Resolve("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+
+	fake.setStatus(checkID("helloworld.Greeter-127.0.0.1:8001"), api.HealthPassing)
+	nodes, err = c.Resolve("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 2)
+	assert.Nil(t, tcp.DeRegister())
+
+	// 心跳刷新 TTL，agent 丢失注册信息后重新注册
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, fake.updates() >= 2)
+	fake.restart()
+	time.Sleep(50 * time.Millisecond)
+	nodes, err = c.Resolve("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+
+	// 注销后不再返回节点，也不再刷新 TTL
+	assert.Nil(t, c.DeRegister())
+	_, err = c.Resolve("helloworld.Greeter")
+	assert.NotNil(t, err)
+	// 注销之前发出的心跳可能在注销之后才到达
+	time.Sleep(10 * time.Millisecond)
+	updates := fake.updates()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, updates, fake.updates())
+}
+
+func TestRegisterTwice(t *testing.T) {
+	shortHeartbeat(t)
+	fake := newFakeConsul()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	c := newConsul(&plugin.Options{})
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, c.Register(
+			plugin.WithSelectorSvrAddr(strings.TrimPrefix(ts.URL, "http://")),
+			plugin.WithSvrAddr("127.0.0.1:8000"),
+			plugin.WithServices([]string{"helloworld.Greeter"}),
+			plugin.WithTTL(30*time.Millisecond)))
+	}
+	c.mu.Lock()
+	assert.Len(t, c.registrations, 1)
+	c.mu.Unlock()
+
+	// 心跳请求 agent 时不阻塞注销
+	block := make(chan struct{})
+	fake.mu.Lock()
+	fake.block = block
+	fake.mu.Unlock()
+	for blocked := 0; blocked == 0; {
+		time.Sleep(time.Millisecond)
+		fake.mu.Lock()
+		blocked = fake.blocked
+		fake.mu.Unlock()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.DeRegister()
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("DeRegister blocked by heartbeat")
+	}
+
+	// 注销之后心跳不再重新注册
+	fake.mu.Lock()
+	fake.block = nil
+	fake.mu.Unlock()
+	close(block)
+	time.Sleep(50 * time.Millisecond)
+	_, err := c.Resolve("helloworld.Greeter")
+	assert.NotNil(t, err)
+	fake.mu.Lock()
+	assert.Len(t, fake.services, 0)
+	fake.mu.Unlock()
+}
+
+func TestSelect(t *testing.T) {
+	fake := newFakeConsul()
+	ts := httptest.NewServer(fake)
+	consulAddr := strings.TrimPrefix(ts.URL, "http://")
+
+	register := func(addr string) *Consul {
+		c := newConsul(&plugin.Options{})
+		assert.Nil(t, c.Register(
+			plugin.WithSelectorSvrAddr(consulAddr),
+			plugin.WithSvrAddr(addr),
+			plugin.WithServices([]string{"helloworld.Greeter"})))
+		return c
+	}
+	a := register("127.0.0.1:8000")
+	defer a.DeRegister()
+
+	c := newConsul(&plugin.Options{})
+	assert.Nil(t, Init(consulAddr))
+	c.client = ConsulSvr.client
+	defer func() {
+		c.Close()
+	}()
+
+	addr, err := c.Select("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:8000", addr)
+
+	// 选择节点使用本地缓存，不查询 consul
+	for i := 0; i < 10; i++ {
+		c.Select("helloworld.Greeter")
+	}
+	time.Sleep(20 * time.Millisecond)
+	fake.mu.Lock()
+	assert.Equal(t, 2, fake.queries)
+	fake.mu.Unlock()
+
+	// 新节点通过阻塞查询加入缓存
+	b := register("127.0.0.1:8001")
+	var nodes []*selector.Node
+	for i := 0; i < 100 && len(nodes) < 2; i++ {
+		time.Sleep(time.Millisecond)
+		nodes, err = c.Nodes("helloworld.Greeter")
+		assert.Nil(t, err)
+	}
+	assert.Len(t, nodes, 2)
+
+	// consul 不可用时继续使用之前的节点
+	b.DeRegister()
+	for i := 0; i < 100 && len(nodes) > 1; i++ {
+		time.Sleep(time.Millisecond)
+		nodes, _ = c.Nodes("helloworld.Greeter")
+	}
+	ts.CloseClientConnections()
+	ts.Close()
+	for i := 0; i < 10; i++ {
+		addr, err = c.Select("helloworld.Greeter")
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1:8000", addr)
+	}
+}
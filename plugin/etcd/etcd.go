@@ -0,0 +1,353 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xing-you-ji/novarpc/plugin"
+	"github.com/xing-you-ji/novarpc/selector"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// Etcd implements the server discovery specification with etcd v3, every instance is a key
+// /novarpc/{service}/{addr} bound to a lease that the server keeps alive
+type Etcd struct {
+	*selector.RegistrySelector // 缓存服务的节点，通过 watch 更新
+
+	opts *plugin.Options
+
+	mu      sync.Mutex
+	client  *clientv3.Client
+	addr    string             // client 连接的地址，地址变化时重新连接
+	leaseID clientv3.LeaseID   // 注册使用的租约，DeRegister 时撤销
+	cancel  context.CancelFunc // 停止续约
+}
+
+const Name = "etcd"
+
+const (
+	// keyPrefix 所有节点都注册在这个前缀下
+	keyPrefix   = "/novarpc/"
+	defaultTTL  = 10 * time.Second
+	dialTimeout = 5 * time.Second
+	// retryInterval 租约丢失后重新注册的间隔
+	retryInterval = time.Second
+)
+
+func init() {
+	plugin.Register(Name, EtcdSvr)
+	selector.RegisterSelector(Name, EtcdSvr)
+}
+
+// global etcd objects for framework
+var EtcdSvr = newEtcd(&plugin.Options{})
+
+// newEtcd creates an Etcd that selects nodes with the balancer named by opts.BalancerName
+func newEtcd(opts *plugin.Options) *Etcd {
+	e := &Etcd{opts: opts}
+	e.RegistrySelector = selector.NewRegistrySelector(e, func() string {
+		return e.opts.BalancerName
+	})
+	return e
+}
+
+// InitConfig connects to etcd, SelectorSvrAddr is a comma separated list of endpoints. The client is
+// reused while SelectorSvrAddr is unchanged
+func (e *Etcd) InitConfig() error {
+	_, err := e.connection()
+	return err
+}
+
+// connection 返回 etcd 的连接，没有连接或者地址变化时重新连接，DeRegister 关闭的连接在下次使用时重新建立
+func (e *Etcd) connection() (*clientv3.Client, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil && e.addr == e.opts.SelectorSvrAddr {
+		return e.client, nil
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(e.opts.SelectorSvrAddr, ","),
+		DialTimeout: dialTimeout,
+		Logger:      zap.L(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if e.client != nil {
+		e.client.Close()
+	}
+	e.client, e.addr = client, e.opts.SelectorSvrAddr
+
+	return client, nil
+}
+
+func serviceKey(serviceName string) string {
+	return keyPrefix + serviceName + "/"
+}
+
+// nodeInfo 保存在节点 key 的 value 中
+type nodeInfo struct {
+	Address    string            `json:"address"`
+	Weight     *int              `json:"weight,omitempty"` // 0 表示不分配流量，没有设置时不写入
+	Tags       []string          `json:"tags,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func encodeNode(opts *plugin.Options) (string, error) {
+	value, err := json.Marshal(&nodeInfo{
+		Address:    opts.SvrAddr,
+		Weight:     opts.Weight,
+		Tags:       opts.Tags,
+		Attributes: opts.Attributes,
+	})
+	return string(value), err
+}
+
+func decodeNode(key string, value []byte) (*selector.Node, error) {
+	info := &nodeInfo{}
+	if err := json.Unmarshal(value, info); err != nil {
+		return nil, err
+	}
+
+	return &selector.Node{
+		Key:        key,
+		Value:      value,
+		Address:    info.Address,
+		Weight:     info.Weight,
+		Tags:       info.Tags,
+		Attributes: info.Attributes,
+	}, nil
+}
+
+// Resolve queries etcd for the instances of the service, Select uses the nodes cached by a watch instead
+func (e *Etcd) Resolve(serviceName string) ([]*selector.Node, error) {
+
+	nodes, _, err := e.get(context.Background(), serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no services find in path : %s", serviceName)
+	}
+	return nodes, nil
+}
+
+// get 按前缀读取服务的所有节点，同时返回读取时的 revision
+func (e *Etcd) get(ctx context.Context, serviceName string) ([]*selector.Node, uint64, error) {
+	client, err := e.connection()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rsp, err := client.Get(ctx, serviceKey(serviceName), clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var nodes []*selector.Node
+	for _, kv := range rsp.Kvs {
+		node, err := decodeNode(string(kv.Key), kv.Value)
+		if err != nil {
+			zap.L().Warn("invalid etcd node", zap.String("key", string(kv.Key)), zap.Error(err))
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, uint64(rsp.Header.Revision), nil
+}
+
+// Watch implements selector.Registry, version is the etcd revision the nodes were read at
+func (e *Etcd) Watch(ctx context.Context, serviceName string, version uint64) ([]*selector.Node, uint64, error) {
+	if version == 0 {
+		return e.get(ctx, serviceName)
+	}
+
+	client, err := e.connection()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 从读取之后的下一个 revision 开始 watch，期间的变化不会丢失
+	ch := client.Watch(clientv3.WithRequireLeader(ctx), serviceKey(serviceName),
+		clientv3.WithPrefix(), clientv3.WithRev(int64(version)+1))
+	for rsp := range ch {
+		// revision 已经被压缩时重新读取
+		if rsp.CompactRevision != 0 {
+			return e.get(ctx, serviceName)
+		}
+		if err := rsp.Err(); err != nil {
+			return nil, 0, err
+		}
+		if len(rsp.Events) > 0 {
+			return e.get(ctx, serviceName)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	return nil, 0, errors.New("etcd watch closed")
+}
+
+// Register puts a key for every service of the server under a lease and keeps the lease alive in the
+// background until DeRegister, the keys are removed by etcd when the server stops keeping it alive
+func (e *Etcd) Register(opts ...plugin.Option) error {
+
+	for _, o := range opts {
+		o(e.opts)
+	}
+
+	if len(e.opts.Services) == 0 || e.opts.SvrAddr == "" || e.opts.SelectorSvrAddr == "" {
+		return fmt.Errorf("etcd init error, len(services) : %d, svrAddr : %s, selectorSvrAddr : %s",
+			len(e.opts.Services), e.opts.SvrAddr, e.opts.SelectorSvrAddr)
+	}
+
+	// 重复注册时停止之前的续约并撤销租约，旧的租约和 key 不会残留到 TTL 过期
+	e.mu.Lock()
+	cancel, leaseID, client := e.cancel, e.leaseID, e.client
+	if cancel != nil {
+		// 持有锁时停止，续约的 goroutine 不会再更新 leaseID
+		cancel()
+		e.cancel = nil
+	}
+	e.mu.Unlock()
+	if cancel != nil {
+		revoke(client, leaseID)
+	}
+
+	client, err := e.connection()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaseID, err = e.register(ctx, client)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	e.mu.Lock()
+	e.leaseID = leaseID
+	e.cancel = cancel
+	e.mu.Unlock()
+
+	go e.keepAlive(ctx, client, leaseID)
+
+	return nil
+}
+
+// register 申请租约，并在租约下写入每个服务的节点
+func (e *Etcd) register(ctx context.Context, client *clientv3.Client) (clientv3.LeaseID, error) {
+	ttl := e.opts.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	value, err := encodeNode(e.opts)
+	if err != nil {
+		return 0, err
+	}
+
+	lease, err := client.Grant(ctx, int64((ttl+time.Second-1)/time.Second))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, serviceName := range e.opts.Services {
+		if _, err := client.Put(ctx, serviceKey(serviceName)+e.opts.SvrAddr, value, clientv3.WithLease(lease.ID)); err != nil {
+			revoke(client, lease.ID)
+			return 0, err
+		}
+	}
+
+	return lease.ID, nil
+}
+
+// keepAlive 续约，租约丢失时重新注册，例如节点和 etcd 断开的时间超过了 TTL
+func (e *Etcd) keepAlive(ctx context.Context, client *clientv3.Client, leaseID clientv3.LeaseID) {
+	for {
+		ch, err := client.KeepAlive(ctx, leaseID)
+		if err == nil {
+			for range ch {
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		zap.L().Warn("etcd lease lost, register again", zap.Int64("lease", int64(leaseID)), zap.Error(err))
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+
+			if leaseID, err = e.register(ctx, client); err == nil {
+				break
+			}
+			zap.L().Error("etcd register failed", zap.Error(err))
+		}
+
+		e.mu.Lock()
+		// 重新注册期间已经注销，撤销新的租约
+		if ctx.Err() != nil {
+			e.mu.Unlock()
+			revoke(client, leaseID)
+			return
+		}
+		e.leaseID = leaseID
+		e.mu.Unlock()
+	}
+}
+
+// DeRegister stops keeping the lease alive and revokes it, which removes the keys of the server,
+// then closes the client
+func (e *Etcd) DeRegister() error {
+	e.mu.Lock()
+	if e.cancel == nil {
+		e.mu.Unlock()
+		return nil
+	}
+	e.cancel()
+	leaseID, client := e.leaseID, e.client
+	e.cancel, e.client = nil, nil
+	e.mu.Unlock()
+
+	err := revoke(client, leaseID)
+	client.Close()
+	return err
+}
+
+// revoke 撤销租约，租约下的 key 随之删除
+func revoke(client *clientv3.Client, leaseID clientv3.LeaseID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	_, err := client.Revoke(ctx, leaseID)
+	return err
+}
+
+// Init implements the initialization of the etcd configuration when the framework is loaded
+func Init(etcdSvrAddr string, opts ...plugin.Option) error {
+	for _, o := range opts {
+		o(EtcdSvr.opts)
+	}
+
+	EtcdSvr.opts.SelectorSvrAddr = etcdSvrAddr
+	err := EtcdSvr.InitConfig()
+	return err
+}
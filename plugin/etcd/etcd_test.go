@@ -0,0 +1,241 @@
+package etcd
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/plugin"
+	"github.com/xing-you-ji/novarpc/selector"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// startEtcd 在进程内启动一个单节点的 etcd，返回 client 地址
+func startEtcd(t *testing.T) string {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Server.Stop()
+		t.Fatal("embedded etcd took too long to start")
+	}
+	return clientURL.Host
+}
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func newClient(t *testing.T, endpoint string) *clientv3.Client {
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: dialTimeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+// keys 返回服务下注册的 key 和对应的租约
+func keys(t *testing.T, client *clientv3.Client, serviceName string) map[string]int64 {
+	rsp, err := client.Get(context.Background(), serviceKey(serviceName), clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leases := make(map[string]int64)
+	for _, kv := range rsp.Kvs {
+		leases[string(kv.Key)] = kv.Lease
+	}
+	return leases
+}
+
+func TestNodeInfo(t *testing.T) {
+	value, err := encodeNode(&plugin.Options{
+		SvrAddr:    "127.0.0.1:8000",
+		Weight:     selector.WeightOf(5),
+		Tags:       []string{"canary"},
+		Attributes: map[string]string{"zone": "sh-1"},
+	})
+	assert.Nil(t, err)
+
+	node, err := decodeNode("/novarpc/Greeter/127.0.0.1:8000", []byte(value))
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:8000", node.Addr())
+	assert.Equal(t, 5, node.EffectiveWeight())
+	assert.Equal(t, []string{"canary"}, node.Tags)
+	assert.Equal(t, "sh-1", node.Attributes["zone"])
+
+	// 权重 0 和没有设置权重不同
+	value, err = encodeNode(&plugin.Options{SvrAddr: "127.0.0.1:8000", Weight: selector.WeightOf(0)})
+	assert.Nil(t, err)
+	node, err = decodeNode("/novarpc/Greeter/127.0.0.1:8000", []byte(value))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, node.EffectiveWeight())
+	value, err = encodeNode(&plugin.Options{SvrAddr: "127.0.0.1:8000"})
+	assert.Nil(t, err)
+	node, err = decodeNode("/novarpc/Greeter/127.0.0.1:8000", []byte(value))
+	assert.Nil(t, err)
+	assert.Equal(t, selector.DefaultWeight, node.EffectiveWeight())
+
+	_, err = decodeNode("/novarpc/Greeter/127.0.0.1:8000", []byte("127.0.0.1:8000"))
+	assert.NotNil(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	endpoint := startEtcd(t)
+	client := newClient(t, endpoint)
+
+	e := newEtcd(&plugin.Options{})
+	err := e.Register(
+		plugin.WithSelectorSvrAddr(endpoint),
+		plugin.WithSvrAddr("127.0.0.1:8000"),
+		plugin.WithServices([]string{"helloworld.Greeter", "helloworld.Hello"}),
+		plugin.WithWeight(5),
+		plugin.WithTags([]string{"canary"}),
+		plugin.WithAttributes(map[string]string{"zone": "sh-1"}),
+		plugin.WithTTL(2*time.Second))
+	assert.Nil(t, err)
+
+	nodes, err := e.Resolve("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, "127.0.0.1:8000", nodes[0].Addr())
+	assert.Equal(t, 5, nodes[0].EffectiveWeight())
+	assert.Equal(t, []string{"canary"}, nodes[0].Tags)
+	assert.Equal(t, "sh-1", nodes[0].Attributes["zone"])
+
+	// 所有服务的 key 绑定在同一个租约上
+	greeter, hello := keys(t, client, "helloworld.Greeter"), keys(t, client, "helloworld.Hello")
+	leaseID := greeter["/novarpc/helloworld.Greeter/127.0.0.1:8000"]
+	assert.NotZero(t, leaseID)
+	assert.Equal(t, leaseID, hello["/novarpc/helloworld.Hello/127.0.0.1:8000"])
+
+	// 续约使 key 在 TTL 之后仍然存在
+	time.Sleep(3 * time.Second)
+	assert.Len(t, keys(t, client, "helloworld.Greeter"), 1)
+
+	// 租约丢失后重新注册
+	_, err = client.Revoke(context.Background(), clientv3.LeaseID(leaseID))
+	assert.Nil(t, err)
+	for i := 0; i < 100 && len(keys(t, client, "helloworld.Greeter")) == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	greeter = keys(t, client, "helloworld.Greeter")
+	assert.Len(t, greeter, 1)
+	assert.NotEqual(t, leaseID, greeter["/novarpc/helloworld.Greeter/127.0.0.1:8000"])
+
+	// 注销时撤销租约，key 立即删除
+	assert.Nil(t, e.DeRegister())
+	assert.Len(t, keys(t, client, "helloworld.Greeter"), 0)
+	assert.Len(t, keys(t, client, "helloworld.Hello"), 0)
+	_, err = e.Resolve("helloworld.Greeter")
+	assert.NotNil(t, err)
+}
+
+func TestRegisterTwice(t *testing.T) {
+	endpoint := startEtcd(t)
+	client := newClient(t, endpoint)
+
+	e := newEtcd(&plugin.Options{})
+	register := func() *clientv3.Client {
+		assert.Nil(t, e.Register(
+			plugin.WithSelectorSvrAddr(endpoint),
+			plugin.WithSvrAddr("127.0.0.1:8000"),
+			plugin.WithServices([]string{"helloworld.Greeter"})))
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.client
+	}
+
+	// 地址没有变化时复用连接，之前的租约被撤销
+	first := register()
+	assert.Equal(t, first, register())
+	assert.Len(t, keys(t, client, "helloworld.Greeter"), 1)
+	leases, err := client.Leases(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, leases.Leases, 1)
+
+	// 注销后关闭连接
+	assert.Nil(t, e.DeRegister())
+	assert.Len(t, keys(t, client, "helloworld.Greeter"), 0)
+	e.mu.Lock()
+	assert.Nil(t, e.client)
+	e.mu.Unlock()
+}
+
+func TestSelect(t *testing.T) {
+	endpoint := startEtcd(t)
+
+	register := func(addr string) *Etcd {
+		e := newEtcd(&plugin.Options{})
+		assert.Nil(t, e.Register(
+			plugin.WithSelectorSvrAddr(endpoint),
+			plugin.WithSvrAddr(addr),
+			plugin.WithServices([]string{"helloworld.Greeter"})))
+		return e
+	}
+	a := register("127.0.0.1:8000")
+	defer a.DeRegister()
+
+	e := newEtcd(&plugin.Options{SelectorSvrAddr: endpoint})
+	assert.Nil(t, e.InitConfig())
+	defer func() {
+		e.Close()
+	}()
+
+	addr, err := e.Select("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:8000", addr)
+
+	// 新节点通过 watch 加入缓存
+	b := register("127.0.0.1:8001")
+	var nodes []*selector.Node
+	for i := 0; i < 100 && len(nodes) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		nodes, err = e.Nodes("helloworld.Greeter")
+		assert.Nil(t, err)
+	}
+	assert.Len(t, nodes, 2)
+
+	// 注销的节点从缓存中删除
+	assert.Nil(t, b.DeRegister())
+	for i := 0; i < 100 && len(nodes) > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+		nodes, _ = e.Nodes("helloworld.Greeter")
+	}
+	assert.Len(t, nodes, 1)
+	for i := 0; i < 10; i++ {
+		addr, err = e.Select("helloworld.Greeter")
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1:8000", addr)
+	}
+
+	_, err = e.Select("helloworld.Unknown")
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,10 @@
 package plugin
 
-import "github.com/opentracing/opentracing-go"
+import (
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
 
 // Plugin defines the standard for all plug-ins
 type Plugin interface {
@@ -34,6 +38,14 @@ type Options struct {
 	Services        []string // service arrays
 	SelectorSvrAddr string   // server discovery address ，e.g. consul server address
 	TracingSvrAddr  string   // tracing server address，e.g. jaeger server address
+	BalancerName    string   // balancer used by the selector, e.g. selector.ConsistentHash
+
+	Weight     *int              // weight of the server published to service discovery, nil if not set
+	Tags       []string          // tags of the server published to service discovery, e.g. canary
+	Attributes map[string]string // attributes of the server published to service discovery, e.g. zone, version
+
+	TTL         time.Duration // the registration expires if the server does not refresh it within TTL, e.g. consul TTL check
+	HealthCheck string        // how service discovery checks the server, e.g. consul.CheckTTL, consul.CheckTCP
 }
 
 // Option provides operations on Options
@@ -66,3 +78,45 @@ func WithTracingSvrAddr(addr string) Option {
 		o.TracingSvrAddr = addr
 	}
 }
+
+// WithBalancerName allows you to set BalancerName of Options
+func WithBalancerName(name string) Option {
+	return func(o *Options) {
+		o.BalancerName = name
+	}
+}
+
+// WithWeight allows you to set Weight of Options, 0 means the server takes no traffic
+func WithWeight(weight int) Option {
+	return func(o *Options) {
+		o.Weight = &weight
+	}
+}
+
+// WithTags allows you to set Tags of Options
+func WithTags(tags []string) Option {
+	return func(o *Options) {
+		o.Tags = tags
+	}
+}
+
+// WithAttributes allows you to set Attributes of Options
+func WithAttributes(attributes map[string]string) Option {
+	return func(o *Options) {
+		o.Attributes = attributes
+	}
+}
+
+// WithTTL allows you to set TTL of Options
+func WithTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.TTL = ttl
+	}
+}
+
+// WithHealthCheck allows you to set HealthCheck of Options
+func WithHealthCheck(check string) Option {
+	return func(o *Options) {
+		o.HealthCheck = check
+	}
+}
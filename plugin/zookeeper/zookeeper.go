@@ -0,0 +1,454 @@
+package zookeeper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/xing-you-ji/novarpc/plugin"
+	"github.com/xing-you-ji/novarpc/selector"
+	"go.uber.org/zap"
+)
+
+// Zookeeper implements the server discovery specification with ZooKeeper, every instance is an ephemeral
+// sequential znode under /novarpc/{service} that lives as long as the session of the server
+type Zookeeper struct {
+	*selector.RegistrySelector // 缓存服务的节点，通过 child watch 更新
+
+	opts *plugin.Options
+	conn zkConn
+
+	mu          sync.Mutex
+	registered  bool              // Register 之后、DeRegister 之前为 true，会话重建时需要重新注册
+	paths       map[string]string // service -> 注册的 znode，DeRegister 时删除
+	stopSession chan struct{}     // 关闭时停止当前连接的 watchSession
+
+	registerMu sync.Mutex // 同一时间只有一个 register 创建节点，不持有 z.mu，不会阻塞 DeRegister
+
+}
+
+// zkConn 是用到的 *zk.Conn 的方法
+type zkConn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	CreateProtectedEphemeralSequential(path string, data []byte, acl []zk.ACL) (string, error)
+	Exists(path string) (bool, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Delete(path string, version int32) error
+	Close()
+}
+
+const Name = "zookeeper"
+
+const (
+	// basePath 所有服务都注册在这个路径下
+	basePath = "/novarpc"
+	// nodePrefix 节点 znode 的名字前缀，zookeeper 在后面加上序号
+	nodePrefix            = "node-"
+	defaultSessionTimeout = 10 * time.Second
+	// retryInterval 会话重建后重新注册失败时重试的间隔
+	retryInterval = time.Second
+	// noNodeVersion 服务的 znode 还不存在时返回的版本，再次 Watch 时等待它被创建
+	noNodeVersion = math.MaxUint64
+)
+
+func init() {
+	plugin.Register(Name, ZookeeperSvr)
+	selector.RegisterSelector(Name, ZookeeperSvr)
+}
+
+// global zookeeper objects for framework
+var ZookeeperSvr = newZookeeper(&plugin.Options{})
+
+// newZookeeper creates a Zookeeper that selects nodes with the balancer named by opts.BalancerName
+func newZookeeper(opts *plugin.Options) *Zookeeper {
+	z := &Zookeeper{opts: opts}
+	z.RegistrySelector = selector.NewRegistrySelector(z, func() string {
+		return z.opts.BalancerName
+	})
+	return z
+}
+
+// connect 建立 zookeeper 会话，返回的 channel 传递会话的状态变化
+var connect = func(servers []string, sessionTimeout time.Duration) (zkConn, <-chan zk.Event, error) {
+	return zk.Connect(servers, sessionTimeout, zk.WithLogger(logger{}))
+}
+
+// logger 把 zk 的日志输出到 zap
+type logger struct{}
+
+func (logger) Printf(format string, args ...interface{}) {
+	zap.L().Info(fmt.Sprintf("zookeeper: "+format, args...))
+}
+
+// InitConfig connects to zookeeper, SelectorSvrAddr is a comma separated list of servers,
+// TTL of the options is used as the session timeout
+func (z *Zookeeper) InitConfig() error {
+
+	sessionTimeout := z.opts.TTL
+	if sessionTimeout <= 0 {
+		sessionTimeout = defaultSessionTimeout
+	}
+
+	conn, events, err := connect(strings.Split(z.opts.SelectorSvrAddr, ","), sessionTimeout)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	z.mu.Lock()
+	oldConn, oldStop := z.conn, z.stopSession
+	z.conn, z.stopSession = conn, stop
+	z.mu.Unlock()
+
+	// 先停止旧连接的 watchSession，zk 关闭连接时不会关闭事件的 channel
+	if oldConn != nil {
+		close(oldStop)
+		oldConn.Close()
+	}
+	go z.watchSession(events, stop)
+
+	return nil
+}
+
+// connection 返回当前的连接，InitConfig 重新连接时会替换
+func (z *Zookeeper) connection() zkConn {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.conn
+}
+
+// servicePath 返回服务的 znode 路径，以 / 开头的服务名是完整的路径，例如其他框架注册的服务
+func servicePath(serviceName string) string {
+	if strings.HasPrefix(serviceName, "/") {
+		return serviceName
+	}
+	return basePath + "/" + serviceName
+}
+
+// nodeInfo 保存在节点 znode 的数据中
+type nodeInfo struct {
+	Address    string            `json:"address"`
+	Port       int               `json:"port,omitempty"`   // 地址中没有端口时使用，例如 Curator 注册的 ServiceInstance
+	Weight     *int              `json:"weight,omitempty"` // 0 表示不分配流量，没有设置时不写入
+	Tags       []string          `json:"tags,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func encodeNode(opts *plugin.Options) ([]byte, error) {
+	return json.Marshal(&nodeInfo{
+		Address:    opts.SvrAddr,
+		Weight:     opts.Weight,
+		Tags:       opts.Tags,
+		Attributes: opts.Attributes,
+	})
+}
+
+func decodeNode(key string, data []byte) (*selector.Node, error) {
+	info := &nodeInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		// 只写入了 host:port 的节点
+		if _, _, err := net.SplitHostPort(string(data)); err != nil {
+			return nil, fmt.Errorf("invalid node data : %q", data)
+		}
+		info.Address = string(data)
+	}
+
+	if info.Address == "" {
+		return nil, fmt.Errorf("node address is empty : %q", data)
+	}
+	if _, _, err := net.SplitHostPort(info.Address); err != nil && info.Port > 0 {
+		info.Address = net.JoinHostPort(info.Address, strconv.Itoa(info.Port))
+	}
+
+	return &selector.Node{
+		Key:        key,
+		Value:      data,
+		Address:    info.Address,
+		Weight:     info.Weight,
+		Tags:       info.Tags,
+		Attributes: info.Attributes,
+	}, nil
+}
+
+// Resolve queries zookeeper for the instances of the service, Select uses the nodes cached by a watch instead
+func (z *Zookeeper) Resolve(serviceName string) ([]*selector.Node, error) {
+
+	nodes, _, err := z.Watch(context.Background(), serviceName, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no services find in path : %s", serviceName)
+	}
+	return nodes, nil
+}
+
+// Watch implements selector.Registry, version is the pzxid of the service znode, which changes whenever a
+// node is added or removed. A service that nobody has registered yet has no nodes and version noNodeVersion,
+// watching it again blocks until the service is registered.
+func (z *Zookeeper) Watch(ctx context.Context, serviceName string, version uint64) ([]*selector.Node, uint64, error) {
+
+	conn := z.connection()
+	path := servicePath(serviceName)
+	for {
+		children, stat, ch, err := conn.ChildrenW(path)
+		if err == zk.ErrNoNode {
+			if version != noNodeVersion {
+				return nil, noNodeVersion, nil
+			}
+			// 服务还没有注册过，等待服务的 znode 被创建，不反复轮询
+			if err = waitCreated(ctx, conn, path); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if pzxid := uint64(stat.Pzxid); version == 0 || pzxid != version {
+			nodes, err := getNodes(conn, path, children)
+			if err != nil {
+				return nil, 0, err
+			}
+			return nodes, pzxid, nil
+		}
+
+		// 等待子节点变化，watch 触发一次后失效，下一轮重新设置
+		select {
+		case ev := <-ch:
+			if ev.Err != nil {
+				return nil, 0, ev.Err
+			}
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// waitCreated 等待 path 被创建，path 已经存在时立即返回
+func waitCreated(ctx context.Context, conn zkConn, path string) error {
+	exists, _, ch, err := conn.ExistsW(path)
+	if err != nil || exists {
+		return err
+	}
+
+	select {
+	case ev := <-ch:
+		return ev.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getNodes 读取每个子节点的数据
+func getNodes(conn zkConn, path string, children []string) ([]*selector.Node, error) {
+	sort.Strings(children)
+
+	var nodes []*selector.Node
+	for _, child := range children {
+		key := path + "/" + child
+		data, _, err := conn.Get(key)
+		if err == zk.ErrNoNode {
+			// 读取期间节点已经下线
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		node, err := decodeNode(key, data)
+		if err != nil {
+			zap.L().Warn("invalid zookeeper node", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Register creates an ephemeral sequential znode for every service of the server, zookeeper removes them
+// when the session ends. Nodes lost with an expired session are registered again once a new session is
+// established, until DeRegister.
+func (z *Zookeeper) Register(opts ...plugin.Option) error {
+
+	for _, o := range opts {
+		o(z.opts)
+	}
+
+	if len(z.opts.Services) == 0 || z.opts.SvrAddr == "" || z.opts.SelectorSvrAddr == "" {
+		return fmt.Errorf("zookeeper init error, len(services) : %d, svrAddr : %s, selectorSvrAddr : %s",
+			len(z.opts.Services), z.opts.SvrAddr, z.opts.SelectorSvrAddr)
+	}
+
+	if err := z.InitConfig(); err != nil {
+		return err
+	}
+
+	z.mu.Lock()
+	z.registered = true
+	z.paths = make(map[string]string)
+	z.mu.Unlock()
+
+	if err := z.register(); err != nil {
+		z.DeRegister()
+		return err
+	}
+
+	return nil
+}
+
+// register 为还没有节点的服务创建节点，网络请求不持有 z.mu，期间注销时删除刚创建的节点
+func (z *Zookeeper) register() error {
+	z.registerMu.Lock()
+	defer z.registerMu.Unlock()
+
+	data, err := encodeNode(z.opts)
+	if err != nil {
+		return err
+	}
+
+	for _, serviceName := range z.opts.Services {
+		z.mu.Lock()
+		conn, registered := z.conn, z.registered
+		node, ok := z.paths[serviceName]
+		z.mu.Unlock()
+
+		if !registered {
+			return nil
+		}
+
+		if ok {
+			exists, _, err := conn.Exists(node)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+
+		path := servicePath(serviceName)
+		if err := createPath(conn, path); err != nil {
+			return err
+		}
+
+		// 创建请求因为连接断开重试时，不会重复创建节点
+		node, err = conn.CreateProtectedEphemeralSequential(path+"/"+nodePrefix, data, zk.WorldACL(zk.PermAll))
+		if err != nil {
+			return err
+		}
+
+		z.mu.Lock()
+		if registered = z.registered; registered {
+			z.paths[serviceName] = node
+		}
+		z.mu.Unlock()
+
+		if !registered {
+			conn.Delete(node, -1)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// createPath 逐级创建服务的持久 znode
+func createPath(conn zkConn, path string) error {
+	var current string
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		current += "/" + name
+		_, err := conn.Create(current, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchSession 会话过期后临时节点被 zookeeper 删除，重新建立会话时重新注册，stop 关闭时退出
+func (z *Zookeeper) watchSession(events <-chan zk.Event, stop chan struct{}) {
+	for {
+		var ev zk.Event
+		var ok bool
+		select {
+		case <-stop:
+			return
+		case ev, ok = <-events:
+		}
+		if !ok {
+			return
+		}
+		if ev.Type != zk.EventSession {
+			continue
+		}
+
+		switch ev.State {
+		case zk.StateExpired:
+			zap.L().Warn("zookeeper session expired", zap.String("server", ev.Server))
+		case zk.StateHasSession:
+			z.recover(stop)
+		}
+	}
+}
+
+func (z *Zookeeper) recover(stop chan struct{}) {
+	for {
+		err := z.register()
+		if err == nil || err == zk.ErrClosing {
+			return
+		}
+		zap.L().Error("zookeeper register failed", zap.Error(err))
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// DeRegister deletes the znodes of the server, they are not registered again when the session is re-established
+func (z *Zookeeper) DeRegister() error {
+	z.mu.Lock()
+	if !z.registered {
+		z.mu.Unlock()
+		return nil
+	}
+	z.registered = false
+	conn, paths := z.conn, z.paths
+	z.paths = nil
+	z.mu.Unlock()
+
+	var err error
+	for _, node := range paths {
+		if e := conn.Delete(node, -1); e != nil && e != zk.ErrNoNode {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Init implements the initialization of the zookeeper configuration when the framework is loaded
+func Init(zookeeperSvrAddr string, opts ...plugin.Option) error {
+	for _, o := range opts {
+		o(ZookeeperSvr.opts)
+	}
+
+	ZookeeperSvr.opts.SelectorSvrAddr = zookeeperSvrAddr
+	err := ZookeeperSvr.InitConfig()
+	return err
+}
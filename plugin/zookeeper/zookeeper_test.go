@@ -0,0 +1,488 @@
+package zookeeper
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/plugin"
+	"github.com/xing-you-ji/novarpc/selector"
+)
+
+// fakeZookeeper 是内存中的 znode 树，每个 fakeConn 是一个会话
+type fakeZookeeper struct {
+	mu       sync.Mutex
+	zxid     int64
+	znodes   map[string]*znode
+	watches  map[string][]chan zk.Event // path -> child watch
+	exists   map[string][]chan zk.Event // path -> exists watch，创建时触发
+	existsW  int                        // ExistsW 调用的次数
+	sessions int64
+	block    chan struct{} // 不为 nil 时 Create 等到它关闭，模拟很慢的请求
+	blocked  int           // 正在等待 block 的 Create
+}
+
+type znode struct {
+	data     []byte
+	owner    int64 // 临时节点所属的会话
+	cversion int32
+	pzxid    int64
+}
+
+func newFakeZookeeper() *fakeZookeeper {
+	return &fakeZookeeper{
+		zxid:    1,
+		znodes:  map[string]*znode{"/": {pzxid: 1}},
+		watches: make(map[string][]chan zk.Event),
+		exists:  make(map[string][]chan zk.Event),
+	}
+}
+
+// connect 替换 zk.Connect，建立新的会话
+func (f *fakeZookeeper) connect(t *testing.T) {
+	connect = func(servers []string, sessionTimeout time.Duration) (zkConn, <-chan zk.Event, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.sessions++
+		c := &fakeConn{f: f, session: f.sessions, events: make(chan zk.Event, 6)}
+		c.events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+		return c, c.events, nil
+	}
+	t.Cleanup(func() {
+		connect = func(servers []string, sessionTimeout time.Duration) (zkConn, <-chan zk.Event, error) {
+			return zk.Connect(servers, sessionTimeout, zk.WithLogger(logger{}))
+		}
+	})
+}
+
+// childChanged 在持有锁时调用
+func (f *fakeZookeeper) childChanged(parent string) {
+	f.zxid++
+	f.znodes[parent].cversion++
+	f.znodes[parent].pzxid = f.zxid
+	for _, ch := range f.watches[parent] {
+		ch <- zk.Event{Type: zk.EventNodeChildrenChanged, Path: parent}
+	}
+	delete(f.watches, parent)
+}
+
+// closeSession 删除会话的临时节点，在持有锁时调用
+func (f *fakeZookeeper) closeSession(session int64) {
+	for p, n := range f.znodes {
+		if n.owner == session {
+			delete(f.znodes, p)
+			f.childChanged(path.Dir(p))
+		}
+	}
+}
+
+type fakeConn struct {
+	f       *fakeZookeeper
+	session int64
+	events  chan zk.Event
+	closed  bool
+}
+
+// expire 模拟会话过期后重新建立会话
+func (c *fakeConn) expire() {
+	c.f.mu.Lock()
+	c.f.closeSession(c.session)
+	c.f.sessions++
+	c.session = c.f.sessions
+	c.f.mu.Unlock()
+
+	c.events <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+	c.events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+}
+
+func (c *fakeConn) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	c.f.mu.Lock()
+	block := c.f.block
+	if block != nil {
+		c.f.blocked++
+	}
+	c.f.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if c.closed {
+		return "", zk.ErrClosing
+	}
+
+	parent, ok := c.f.znodes[path.Dir(p)]
+	if !ok {
+		return "", zk.ErrNoNode
+	}
+	if flags&zk.FlagSequence != 0 {
+		p += fmt.Sprintf("%010d", parent.cversion)
+	}
+	if _, ok := c.f.znodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+
+	n := &znode{data: data}
+	if flags&zk.FlagEphemeral != 0 {
+		n.owner = c.session
+	}
+	c.f.zxid++
+	n.pzxid = c.f.zxid
+	c.f.znodes[p] = n
+	c.f.childChanged(path.Dir(p))
+	for _, ch := range c.f.exists[p] {
+		ch <- zk.Event{Type: zk.EventNodeCreated, Path: p}
+	}
+	delete(c.f.exists, p)
+	return p, nil
+}
+
+func (c *fakeConn) CreateProtectedEphemeralSequential(p string, data []byte, acl []zk.ACL) (string, error) {
+	c.f.mu.Lock()
+	session := c.session
+	c.f.mu.Unlock()
+
+	dir, name := path.Split(p)
+	return c.Create(dir+"_c_"+fmt.Sprintf("%032d", session)+"-"+name, data, zk.FlagEphemeral|zk.FlagSequence, acl)
+}
+
+func (c *fakeConn) Exists(p string) (bool, *zk.Stat, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if c.closed {
+		return false, nil, zk.ErrClosing
+	}
+	_, ok := c.f.znodes[p]
+	return ok, &zk.Stat{}, nil
+}
+
+func (c *fakeConn) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if c.closed {
+		return false, nil, nil, zk.ErrClosing
+	}
+	c.f.existsW++
+	ch := make(chan zk.Event, 1)
+	if _, ok := c.f.znodes[p]; ok {
+		return true, &zk.Stat{}, ch, nil
+	}
+	c.f.exists[p] = append(c.f.exists[p], ch)
+	return false, &zk.Stat{}, ch, nil
+}
+
+func (c *fakeConn) Get(p string) ([]byte, *zk.Stat, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if c.closed {
+		return nil, nil, zk.ErrClosing
+	}
+	n, ok := c.f.znodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return n.data, &zk.Stat{}, nil
+}
+
+func (c *fakeConn) ChildrenW(p string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if c.closed {
+		return nil, nil, nil, zk.ErrClosing
+	}
+	n, ok := c.f.znodes[p]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+
+	var children []string
+	for child := range c.f.znodes {
+		if child != "/" && path.Dir(child) == p {
+			children = append(children, path.Base(child))
+		}
+	}
+	sort.Strings(children)
+
+	ch := make(chan zk.Event, 1)
+	c.f.watches[p] = append(c.f.watches[p], ch)
+	return children, &zk.Stat{Cversion: n.cversion, Pzxid: n.pzxid}, ch, nil
+}
+
+func (c *fakeConn) Delete(p string, version int32) error {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if c.closed {
+		return zk.ErrClosing
+	}
+	if _, ok := c.f.znodes[p]; !ok {
+		return zk.ErrNoNode
+	}
+	delete(c.f.znodes, p)
+	c.f.childChanged(path.Dir(p))
+	return nil
+}
+
+func (c *fakeConn) Close() {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.f.closeSession(c.session)
+	close(c.events)
+}
+
+func TestNodeInfo(t *testing.T) {
+	data, err := encodeNode(&plugin.Options{
+		SvrAddr:    "127.0.0.1:8000",
+		Weight:     selector.WeightOf(5),
+		Tags:       []string{"canary"},
+		Attributes: map[string]string{"zone": "sh-1"},
+	})
+	assert.Nil(t, err)
+
+	node, err := decodeNode("/novarpc/Greeter/node-0000000000", data)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:8000", node.Addr())
+	assert.Equal(t, 5, node.EffectiveWeight())
+	assert.Equal(t, []string{"canary"}, node.Tags)
+	assert.Equal(t, "sh-1", node.Attributes["zone"])
+
+	// Curator ServiceInstance 的地址和端口是分开的
+	node, err = decodeNode("/services/Greeter/1", []byte(`{"name":"Greeter","id":"1","address":"10.0.0.1","port":8080,"payload":null}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:8080", node.Addr())
+
+	node, err = decodeNode("/services/Greeter/2", []byte("10.0.0.2:8080"))
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2:8080", node.Addr())
+
+	_, err = decodeNode("/services/Greeter/3", []byte("10.0.0.3"))
+	assert.NotNil(t, err)
+	_, err = decodeNode("/services/Greeter/4", []byte(`{"port":8080}`))
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "/novarpc/helloworld.Greeter", servicePath("helloworld.Greeter"))
+	assert.Equal(t, "/services/Greeter", servicePath("/services/Greeter"))
+}
+
+func TestRegister(t *testing.T) {
+	f := newFakeZookeeper()
+	f.connect(t)
+
+	z := newZookeeper(&plugin.Options{})
+	err := z.Register(
+		plugin.WithSelectorSvrAddr("127.0.0.1:2181"),
+		plugin.WithSvrAddr("127.0.0.1:8000"),
+		plugin.WithServices([]string{"helloworld.Greeter", "helloworld.Hello"}),
+		plugin.WithWeight(5),
+		plugin.WithTags([]string{"canary"}),
+		plugin.WithAttributes(map[string]string{"zone": "sh-1"}))
+	assert.Nil(t, err)
+
+	nodes, err := z.Resolve("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, "127.0.0.1:8000", nodes[0].Addr())
+	assert.Equal(t, 5, nodes[0].EffectiveWeight())
+	assert.Equal(t, []string{"canary"}, nodes[0].Tags)
+	assert.Equal(t, "sh-1", nodes[0].Attributes["zone"])
+	assert.True(t, strings.HasPrefix(nodes[0].Key, "/novarpc/helloworld.Greeter/_c_"))
+	assert.True(t, strings.Contains(nodes[0].Key, "-node-"))
+	key := nodes[0].Key
+
+	nodes, err = z.Resolve("helloworld.Hello")
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+
+	// 会话过期后临时节点被删除，重新建立会话后重新注册
+	conn := z.conn.(*fakeConn)
+	conn.expire()
+	for i := 0; i < 100; i++ {
+		if nodes, err = z.Resolve("helloworld.Greeter"); err == nil && nodes[0].Key != key {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+	assert.NotEqual(t, key, nodes[0].Key)
+
+	// 注销后删除节点，之后会话重建也不再注册
+	assert.Nil(t, z.DeRegister())
+	_, err = z.Resolve("helloworld.Greeter")
+	assert.NotNil(t, err)
+	_, err = z.Resolve("helloworld.Hello")
+	assert.NotNil(t, err)
+
+	conn.expire()
+	time.Sleep(10 * time.Millisecond)
+	_, err = z.Resolve("helloworld.Greeter")
+	assert.NotNil(t, err)
+}
+
+func TestDeRegisterDuringRegister(t *testing.T) {
+	f := newFakeZookeeper()
+	f.connect(t)
+
+	z := newZookeeper(&plugin.Options{})
+	assert.Nil(t, z.Register(
+		plugin.WithSelectorSvrAddr("127.0.0.1:2181"),
+		plugin.WithSvrAddr("127.0.0.1:8000"),
+		plugin.WithServices([]string{"helloworld.Greeter"})))
+
+	// 会话重建后重新注册的请求很慢，注销不需要等待它
+	block := make(chan struct{})
+	f.mu.Lock()
+	f.block = block
+	f.mu.Unlock()
+	z.conn.(*fakeConn).expire()
+	for blocked := 0; blocked == 0; {
+		time.Sleep(time.Millisecond)
+		f.mu.Lock()
+		blocked = f.blocked
+		f.mu.Unlock()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- z.DeRegister()
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("DeRegister blocked by register")
+	}
+
+	// 注销之后创建的节点被删除
+	f.mu.Lock()
+	f.block = nil
+	f.mu.Unlock()
+	close(block)
+	time.Sleep(10 * time.Millisecond)
+	_, err := z.Resolve("helloworld.Greeter")
+	assert.NotNil(t, err)
+
+	// 重新连接后旧连接的会话事件不再处理
+	old := z.conn.(*fakeConn)
+	assert.Nil(t, z.InitConfig())
+	assert.True(t, old.closed)
+}
+
+func TestSelect(t *testing.T) {
+	f := newFakeZookeeper()
+	f.connect(t)
+
+	register := func(addr string) *Zookeeper {
+		z := newZookeeper(&plugin.Options{})
+		assert.Nil(t, z.Register(
+			plugin.WithSelectorSvrAddr("127.0.0.1:2181"),
+			plugin.WithSvrAddr(addr),
+			plugin.WithServices([]string{"helloworld.Greeter"})))
+		return z
+	}
+	a := register("127.0.0.1:8000")
+	defer a.DeRegister()
+
+	z := newZookeeper(&plugin.Options{SelectorSvrAddr: "127.0.0.1:2181"})
+	assert.Nil(t, z.InitConfig())
+	defer func() {
+		z.Close()
+	}()
+
+	addr, err := z.Select("helloworld.Greeter")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:8000", addr)
+
+	// 新节点通过 child watch 加入缓存
+	b := register("127.0.0.1:8001")
+	var nodes []*selector.Node
+	for i := 0; i < 100 && len(nodes) < 2; i++ {
+		time.Sleep(time.Millisecond)
+		nodes, err = z.Nodes("helloworld.Greeter")
+		assert.Nil(t, err)
+	}
+	assert.Len(t, nodes, 2)
+
+	// 节点的会话关闭后从缓存中删除
+	b.conn.Close()
+	for i := 0; i < 100 && len(nodes) > 1; i++ {
+		time.Sleep(time.Millisecond)
+		nodes, _ = z.Nodes("helloworld.Greeter")
+	}
+	assert.Len(t, nodes, 1)
+	for i := 0; i < 10; i++ {
+		addr, err = z.Select("helloworld.Greeter")
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1:8000", addr)
+	}
+
+	// 没有注册过的服务没有节点
+	_, err = z.Select("helloworld.Unknown")
+	assert.NotNil(t, err)
+}
+
+func TestWatchNoNode(t *testing.T) {
+	f := newFakeZookeeper()
+	f.connect(t)
+
+	z := newZookeeper(&plugin.Options{SelectorSvrAddr: "127.0.0.1:2181"})
+	assert.Nil(t, z.InitConfig())
+
+	// 服务的 znode 不存在时第一次立即返回
+	nodes, version, err := z.Watch(context.Background(), "helloworld.Greeter", 0)
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 0)
+	assert.Equal(t, uint64(noNodeVersion), version)
+
+	// 之后等待 znode 被创建，不反复轮询
+	type result struct {
+		nodes []*selector.Node
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		nodes, _, err := z.Watch(context.Background(), "helloworld.Greeter", version)
+		done <- result{nodes, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("watch returned before the service was registered")
+	default:
+	}
+	f.mu.Lock()
+	assert.Equal(t, 1, f.existsW)
+	f.mu.Unlock()
+
+	s := newZookeeper(&plugin.Options{})
+	assert.Nil(t, s.Register(
+		plugin.WithSelectorSvrAddr("127.0.0.1:2181"),
+		plugin.WithSvrAddr("127.0.0.1:8000"),
+		plugin.WithServices([]string{"helloworld.Greeter"})))
+	defer s.DeRegister()
+
+	select {
+	case r := <-done:
+		assert.Nil(t, r.err)
+		assert.Len(t, r.nodes, 1)
+	case <-time.After(time.Second):
+		t.Fatal("watch did not return after the service was registered")
+	}
+
+	// ctx 结束时返回
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = z.Watch(ctx, "helloworld.Unknown", noNodeVersion)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -3,7 +3,6 @@ package connpool
 import (
 	"context"
 	"errors"
-	"io"
 	"net"
 	"sync"
 	"time"
@@ -21,7 +20,6 @@ type pool struct {
 }
 
 var poolMap = make(map[string]Pool)
-var oneByte = make([]byte, 1)
 
 func init() {
 	registorPool("default", DefaultPool)
@@ -48,6 +46,10 @@ func NewConnPool(opt ...Option) *pool {
 		maxCap:      1000,
 		idleTimeout: 1 * time.Minute,
 		dialTimeout: 200 * time.Millisecond,
+
+		heartbeatInterval: 10 * time.Second,
+		heartbeatTimeout:  time.Second,
+		maxMissedPongs:    3,
 	}
 	m := &sync.Map{}
 
@@ -92,6 +94,10 @@ type channelPool struct {
 	Dial        func(context.Context) (net.Conn, error)
 	conns       chan *PoolConn
 	mu          sync.RWMutex
+
+	heartbeatInterval time.Duration // 空闲连接发送心跳的间隔
+	heartbeatTimeout  time.Duration // 等待 pong 的最长时间
+	maxMissedPongs    int           // 连续丢失多少个 pong 后淘汰连接
 }
 
 func (p *pool) NewChannelPool(ctx context.Context, network string, address string) (*channelPool, error) {
@@ -110,19 +116,34 @@ func (p *pool) NewChannelPool(ctx context.Context, network string, address strin
 				timeout = t.Sub(time.Now())
 			}
 
-			return net.DialTimeout(network, address, timeout)
+			conn, err := net.DialTimeout(network, address, timeout)
+			if err != nil || p.opts.transportAuth == nil {
+				return conn, err
+			}
+
+			// 握手成功后连接才能放入连接池
+			authConn, _, err := p.opts.transportAuth.ClientHandshake(ctx, address, conn)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return authConn, nil
 		},
 		conns:       make(chan *PoolConn, p.opts.maxCap),
 		idleTimeout: p.opts.idleTimeout,
 		dialTimeout: p.opts.dialTimeout,
+
+		heartbeatInterval: p.opts.heartbeatInterval,
+		heartbeatTimeout:  p.opts.heartbeatTimeout,
+		maxMissedPongs:    p.opts.maxMissedPongs,
 	}
 
-	if p.opts.initialCap == 0 {
-		// default initialCap is 1
-		p.opts.initialCap = 1
+	// default initialCap is 1, 并发建立连接池时不修改共享的 opts
+	if c.initialCap == 0 {
+		c.initialCap = 1
 	}
 
-	for i := 0; i < p.opts.initialCap; i++ {
+	for i := 0; i < c.initialCap; i++ {
 		conn, err := c.Dial(ctx)
 		if err != nil {
 			return nil, err
@@ -130,7 +151,7 @@ func (p *pool) NewChannelPool(ctx context.Context, network string, address strin
 		c.Put(c.wrapConn(conn))
 	}
 
-	c.RegisterChecker(3*time.Second, c.Checker)
+	c.RegisterChecker(c.heartbeatInterval, c.Checker)
 	return c, nil
 }
 
@@ -148,6 +169,13 @@ func (c *channelPool) Get(ctx context.Context) (net.Conn, error) {
 			return nil, ErrConnClosed
 		}
 
+		// 空闲时收到了 GOAWAY 或者连接已经被服务端关闭，换一个连接
+		if !pc.unwatch() {
+			pc.MarkUnusable()
+			pc.Close()
+			return c.Get(ctx)
+		}
+
 		return pc, nil
 	default:
 		conn, err := c.Dial(ctx)
@@ -186,11 +214,14 @@ func (c *channelPool) Put(conn *PoolConn) error {
 		conn.Close()
 	}
 
+	// 放入连接池之前开始读取，其他请求取出时可能立即 unwatch
+	conn.watchIdle()
 	select {
 	case c.conns <- conn:
 		return nil
 	default:
 		// 连接池满
+		conn.unwatch()
 		return conn.Close()
 	}
 }
@@ -233,26 +264,16 @@ func (c *channelPool) RegisterChecker(internal time.Duration, checker func(conn
 
 func (c *channelPool) Checker(pc *PoolConn) bool {
 
-	// check timeout
-	if pc.t.Add(c.idleTimeout).Before(time.Now()) {
-		return false
-	}
-
-	// check conn is alive or not
-	if !isConnAlive(pc.Conn) {
+	// 空闲时收到了 GOAWAY 或者连接已经被服务端关闭
+	if !pc.unwatch() {
 		return false
 	}
 
-	return true
-}
-
-func isConnAlive(conn net.Conn) bool {
-	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
-
-	if n, err := conn.Read(oneByte); n > 0 || err == io.EOF {
+	// check timeout
+	if pc.t.Add(c.idleTimeout).Before(time.Now()) {
 		return false
 	}
 
-	conn.SetReadDeadline(time.Time{})
-	return true
+	// 通过心跳检查连接是否存活，连续 maxMissedPongs 次没有收到 pong 时淘汰
+	return c.heartbeat(pc)
 }
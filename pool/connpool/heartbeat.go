@@ -0,0 +1,63 @@
+package connpool
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/xing-you-ji/novarpc/codec"
+)
+
+// heartbeat pings an idle connection and waits for the pong, it reports whether the connection can be kept.
+// A pong that misses the timeout is not fatal, it arrives later and is skipped by the next reader of the connection.
+func (c *channelPool) heartbeat(pc *PoolConn) bool {
+	if c.heartbeatTimeout <= 0 {
+		return true
+	}
+
+	// 直接使用底层连接，PoolConn 在读超时时会关闭连接
+	conn := pc.Conn
+	conn.SetDeadline(time.Now().Add(c.heartbeatTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// 写入一半的帧会破坏连接
+	if _, err := conn.Write(codec.HeartbeatFrame()); err != nil {
+		return false
+	}
+
+	header := make([]byte, codec.FrameHeadLen)
+	if n, err := io.ReadFull(conn, header); err != nil {
+		// 没有读到任何数据，记一次丢失的 pong
+		if n == 0 && isTimeout(err) {
+			pc.missedPongs++
+			return pc.missedPongs < c.maxMissedPongs
+		}
+		return false
+	}
+
+	if !discardPong(conn, header) {
+		return false
+	}
+
+	pc.missedPongs = 0
+	return true
+}
+
+// discardPong checks that the frame of header is a pong and skips its payload. Idle connections only receive
+// pongs, any other frame (e.g. GOAWAY) means the connection can no longer be used.
+func discardPong(conn net.Conn, header []byte) bool {
+	if header[0] != codec.Magic || codec.MsgType(header) != codec.HeartbeatMsg {
+		return false
+	}
+	length := binary.BigEndian.Uint32(header[7:11])
+	_, err := io.CopyN(ioutil.Discard, conn, int64(length))
+	return err == nil
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,149 @@
+package connpool
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codec"
+)
+
+// newTestServer answers every frame read from a connection with reply, nil means never answer
+func newTestServer(t *testing.T, reply func(frame []byte) []byte) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				frame := make([]byte, codec.FrameHeadLen)
+				for {
+					if _, err := io.ReadFull(conn, frame); err != nil {
+						return
+					}
+					if reply != nil {
+						conn.Write(reply(frame))
+					}
+				}
+			}()
+		}
+	}()
+
+	return ln
+}
+
+func newTestChannelPool(t *testing.T, addr string) *channelPool {
+	p := NewConnPool(WithHeartbeat(time.Hour, 20*time.Millisecond), WithMaxMissedPongs(2))
+	cp, err := p.NewChannelPool(context.Background(), "tcp", addr)
+	assert.Nil(t, err)
+	return cp
+}
+
+func TestHeartbeat(t *testing.T) {
+	ln := newTestServer(t, func(frame []byte) []byte { return frame })
+	defer ln.Close()
+
+	cp := newTestChannelPool(t, ln.Addr().String())
+	defer cp.Close()
+
+	pc := <-cp.conns
+	assert.True(t, cp.Checker(pc))
+	assert.True(t, cp.Checker(pc))
+	assert.Equal(t, 0, pc.missedPongs)
+}
+
+func TestHeartbeatMissedPongs(t *testing.T) {
+	ln := newTestServer(t, nil)
+	defer ln.Close()
+
+	cp := newTestChannelPool(t, ln.Addr().String())
+	defer cp.Close()
+
+	// 连续丢失 2 个 pong 后淘汰
+	pc := <-cp.conns
+	assert.True(t, cp.Checker(pc))
+	assert.False(t, cp.Checker(pc))
+}
+
+func TestHeartbeatUnexpectedFrame(t *testing.T) {
+	ln := newTestServer(t, func(frame []byte) []byte {
+		goAway := codec.HeartbeatFrame()
+		codec.SetMsgType(goAway, codec.GoAwayMsg)
+		return goAway
+	})
+	defer ln.Close()
+
+	cp := newTestChannelPool(t, ln.Addr().String())
+	defer cp.Close()
+
+	pc := <-cp.conns
+	assert.False(t, cp.Checker(pc))
+}
+
+func TestIdleConnGoAway(t *testing.T) {
+	for _, goAway := range []bool{true, false} {
+		// 服务端在连接空闲时发送 GOAWAY 或者直接关闭连接
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if goAway {
+				frame := codec.HeartbeatFrame()
+				codec.SetMsgType(frame, codec.GoAwayMsg)
+				conn.Write(frame)
+				return
+			}
+			conn.Close()
+		}()
+
+		cp := newTestChannelPool(t, ln.Addr().String())
+		stale := <-cp.conns
+		cp.conns <- stale
+		select {
+		case <-stale.idle:
+		case <-time.After(time.Second):
+			t.Fatal("idle connection was not marked stale")
+		}
+
+		// 取出连接时跳过失效的连接，重新建立连接
+		conn, err := cp.Get(context.Background())
+		assert.Nil(t, err)
+		assert.True(t, conn.(*PoolConn).Conn != stale.Conn)
+		assert.True(t, stale.unusable)
+
+		cp.Close()
+		ln.Close()
+	}
+}
+
+func TestIdleConnLatePong(t *testing.T) {
+	ln := newTestServer(t, func(frame []byte) []byte {
+		time.Sleep(30 * time.Millisecond)
+		return frame
+	})
+	defer ln.Close()
+
+	cp := newTestChannelPool(t, ln.Addr().String())
+	defer cp.Close()
+
+	// pong 在心跳超时之后到达，连接空闲时跳过它，连接仍然可以使用
+	pc := <-cp.conns
+	assert.True(t, cp.Checker(pc))
+	assert.Nil(t, cp.Put(pc))
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := cp.Get(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, conn.(*PoolConn).Conn == pc.Conn)
+}
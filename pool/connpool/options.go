@@ -1,13 +1,22 @@
 package connpool
 
-import "time"
+import (
+	"time"
+
+	"github.com/xing-you-ji/novarpc/auth"
+)
 
 type Options struct {
-	initialCap  int // initial capacity
-	maxCap      int // max capacity
-	idleTimeout time.Duration
-	maxIdle     int           // max idle connections
-	dialTimeout time.Duration // dial timeout
+	initialCap    int // initial capacity
+	maxCap        int // max capacity
+	idleTimeout   time.Duration
+	maxIdle       int                // max idle connections
+	dialTimeout   time.Duration      // dial timeout
+	transportAuth auth.TransportAuth // handshake of new connections, nil means plaintext
+
+	heartbeatInterval time.Duration // ping interval of idle connections
+	heartbeatTimeout  time.Duration // how long to wait for a pong
+	maxMissedPongs    int           // idle connections missing more pongs in a row are evicted
 }
 
 type Option func(*Options)
@@ -41,3 +50,25 @@ func WithDialTimeout(dialTimeout time.Duration) Option {
 		o.dialTimeout = dialTimeout
 	}
 }
+
+// WithTransportAuth handshakes every new connection before it is used
+func WithTransportAuth(transportAuth auth.TransportAuth) Option {
+	return func(o *Options) {
+		o.transportAuth = transportAuth
+	}
+}
+
+// WithHeartbeat sets how often idle connections are pinged and how long a pong is waited for
+func WithHeartbeat(interval time.Duration, timeout time.Duration) Option {
+	return func(o *Options) {
+		o.heartbeatInterval = interval
+		o.heartbeatTimeout = timeout
+	}
+}
+
+// WithMaxMissedPongs evicts an idle connection after it misses the given number of pongs in a row
+func WithMaxMissedPongs(maxMissedPongs int) Option {
+	return func(o *Options) {
+		o.maxMissedPongs = maxMissedPongs
+	}
+}
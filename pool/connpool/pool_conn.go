@@ -2,9 +2,12 @@ package connpool
 
 import (
 	"errors"
+	"io"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/xing-you-ji/novarpc/codec"
 )
 
 var (
@@ -18,6 +21,9 @@ type PoolConn struct {
 	mu          sync.RWMutex
 	t           time.Time     // connection idle time
 	dialTimeout time.Duration // connection timeout duration
+	missedPongs int           // 连续丢失的 pong，只由 checker 访问
+	idle        chan struct{} // 空闲时在后台读取连接，读取结束时关闭
+	stale       bool          // 空闲时收到了 pong 以外的帧或者连接断开，只在 idle 关闭之后读取
 }
 
 // overwrite conn Close for connection reuse
@@ -67,6 +73,47 @@ func (p *PoolConn) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// watchIdle reads the connection while it is idle in the pool, a GOAWAY or the server closing the connection
+// marks it stale so that it is not reused. Late pongs are skipped.
+func (p *PoolConn) watchIdle() {
+	idle := make(chan struct{})
+	p.idle = idle
+
+	go func() {
+		defer close(idle)
+
+		header := make([]byte, codec.FrameHeadLen)
+		for {
+			n, err := io.ReadFull(p.Conn, header)
+			if err != nil {
+				// 被 unwatch 打断，连接仍然可以使用
+				if n == 0 && isTimeout(err) {
+					return
+				}
+				break
+			}
+			if !discardPong(p.Conn, header) {
+				break
+			}
+		}
+		p.stale = true
+	}()
+}
+
+// unwatch stops watchIdle before the connection is used, it returns false if the connection is stale
+func (p *PoolConn) unwatch() bool {
+	if p.idle == nil {
+		return !p.stale
+	}
+
+	p.Conn.SetReadDeadline(time.Now())
+	<-p.idle
+	p.idle = nil
+	p.Conn.SetReadDeadline(time.Time{})
+
+	return !p.stale
+}
+
 func (c *channelPool) wrapConn(conn net.Conn) *PoolConn {
 	p := &PoolConn{
 		c:           c,
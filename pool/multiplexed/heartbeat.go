@@ -0,0 +1,53 @@
+package multiplexed
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/xing-you-ji/novarpc/codec"
+)
+
+// keepalive pings the connection whenever nothing has been received for an interval,
+// the connection is closed after maxMissedPongs pings in a row go unanswered so that its streams fail fast
+func (mc *muxConn) keepalive(interval time.Duration, maxMissedPongs int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	pinged := false
+	for {
+		select {
+		case <-mc.done:
+			return
+		case <-ticker.C:
+		}
+
+		// 一个周期内收到过帧（包括 pong），连接是活的；readLoop 等待慢读者时不检查
+		if time.Since(mc.lastReadTime()) < interval || atomic.LoadInt32(&mc.blocked) == 1 {
+			missed = 0
+			pinged = false
+			continue
+		}
+
+		if pinged {
+			missed++
+			if missed >= maxMissedPongs {
+				mc.close(ErrHeartbeatTimeout)
+				return
+			}
+		}
+
+		if err := mc.write(codec.HeartbeatFrame()); err != nil {
+			return
+		}
+		pinged = true
+	}
+}
+
+func (mc *muxConn) touch() {
+	atomic.StoreInt64(&mc.lastRead, time.Now().UnixNano())
+}
+
+func (mc *muxConn) lastReadTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&mc.lastRead))
+}
@@ -0,0 +1,342 @@
+// Package multiplexed lets many concurrent requests share a few long-lived connections.
+// Every request frame is tagged with a stream ID, and a reader goroutine per connection
+// routes each reply back to its caller by the same ID.
+package multiplexed
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xing-you-ji/novarpc/codec"
+)
+
+var (
+	ErrConnClosed       = errors.New("multiplexed connection closed ...")
+	ErrStreamsExhausted = errors.New("no stream ID available ...")
+	ErrHeartbeatTimeout = errors.New("multiplexed connection missed heartbeats ...")
+)
+
+// Framer reads a full frame from a connection, transport.Framer satisfies it
+type Framer interface {
+	ReadFrame(net.Conn) ([]byte, error)
+}
+
+// Pool provides virtual connections on top of shared long-lived connections
+type Pool interface {
+	Get(ctx context.Context, network string, address string) (*VirtualConn, error)
+}
+
+// pool client -> All server multiplexed connections
+type pool struct {
+	opts      *Options
+	newFramer func() Framer
+	mu        sync.Mutex
+	groups    map[string]*connGroup
+}
+
+// NewPool creates a Pool, newFramer builds the framer used by each connection's reader
+func NewPool(newFramer func() Framer, opt ...Option) *pool {
+	// default options
+	opts := &Options{
+		connsPerAddr: 2,
+		dialTimeout:  200 * time.Millisecond,
+
+		heartbeatInterval: 10 * time.Second,
+		maxMissedPongs:    3,
+	}
+	for _, o := range opt {
+		o(opts)
+	}
+
+	return &pool{
+		opts:      opts,
+		newFramer: newFramer,
+		groups:    make(map[string]*connGroup),
+	}
+}
+
+func (p *pool) Get(ctx context.Context, network string, address string) (*VirtualConn, error) {
+	p.mu.Lock()
+	group, ok := p.groups[address]
+	if !ok {
+		group = &connGroup{
+			conns: make([]*muxConn, p.opts.connsPerAddr),
+			dials: make([]*dialCall, p.opts.connsPerAddr),
+		}
+		p.groups[address] = group
+	}
+	p.mu.Unlock()
+
+	return group.get(ctx, p, network, address)
+}
+
+func (p *pool) dial(ctx context.Context, network string, address string) (*muxConn, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	timeout := p.opts.dialTimeout
+	if t, ok := ctx.Deadline(); ok {
+		timeout = t.Sub(time.Now())
+	}
+
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.opts.transportAuth != nil {
+		authConn, _, err := p.opts.transportAuth.ClientHandshake(ctx, address, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = authConn
+	}
+
+	mc := &muxConn{
+		Conn:    conn,
+		framer:  p.newFramer(),
+		streams: make(map[uint16]*VirtualConn),
+		done:    make(chan struct{}),
+	}
+	mc.touch()
+	go mc.readLoop()
+
+	if p.opts.heartbeatInterval > 0 {
+		go mc.keepalive(p.opts.heartbeatInterval, p.opts.maxMissedPongs)
+	}
+
+	return mc, nil
+}
+
+// connGroup client -> one Server multiplexed connections
+type connGroup struct {
+	mu    sync.Mutex
+	conns []*muxConn
+	dials []*dialCall // 每个槽位上正在进行的建连
+	next  int         // 轮询使用的下一个连接
+}
+
+// dialCall is an in-flight dial, concurrent requests for the same slot wait for it instead of dialing again
+type dialCall struct {
+	done chan struct{}
+	mc   *muxConn
+	err  error
+}
+
+func (g *connGroup) get(ctx context.Context, p *pool, network string, address string) (*VirtualConn, error) {
+	for i := 0; i < len(g.conns); i++ {
+		g.mu.Lock()
+		index := g.next
+		g.next = (g.next + 1) % len(g.conns)
+		mc := g.conns[index]
+		g.mu.Unlock()
+
+		// 连接不存在或者已经断开，重新建立，建连时不持有锁，其他槽位的请求不受影响
+		if mc == nil || mc.isClosed() || mc.isDraining() {
+			var err error
+			if mc, err = g.dial(ctx, p, index, network, address); err != nil {
+				return nil, err
+			}
+		}
+
+		vc, err := mc.newVirtualConn()
+		if err == ErrConnClosed || err == ErrStreamsExhausted {
+			continue
+		}
+		return vc, err
+	}
+
+	return nil, ErrStreamsExhausted
+}
+
+// dial replaces the connection in the slot, only one dial per slot runs at a time
+func (g *connGroup) dial(ctx context.Context, p *pool, index int, network string, address string) (*muxConn, error) {
+	g.mu.Lock()
+	// 等待锁的时候其他请求可能已经建好了连接
+	if mc := g.conns[index]; mc != nil && !mc.isClosed() && !mc.isDraining() {
+		g.mu.Unlock()
+		return mc, nil
+	}
+
+	call := g.dials[index]
+	if call != nil {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.mc, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	call = &dialCall{done: make(chan struct{})}
+	g.dials[index] = call
+	g.mu.Unlock()
+
+	// 建连不使用发起者的 ctx，发起者取消时等待同一个建连的其他请求不受影响
+	go g.doDial(call, p, index, network, address)
+
+	select {
+	case <-call.done:
+		return call.mc, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// doDial dials for the slot with the dial timeout of the pool and publishes the connection
+func (g *connGroup) doDial(call *dialCall, p *pool, index int, network string, address string) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.dialTimeout)
+	defer cancel()
+
+	call.mc, call.err = p.dial(ctx, network, address)
+
+	g.mu.Lock()
+	if call.err == nil {
+		g.conns[index] = call.mc
+	}
+	g.dials[index] = nil
+	g.mu.Unlock()
+	close(call.done)
+}
+
+// muxConn is a long-lived connection shared by many virtual connections
+type muxConn struct {
+	lastRead int64 // 最近一次收到帧的时间，UnixNano，放在第一个字段保证原子操作的对齐
+	blocked  int32 // 为 1 时 readLoop 在等待读者取走帧，这期间收不到 pong 不代表连接断开
+	net.Conn
+	framer   Framer
+	writeMu  sync.Mutex // 保证帧的写入不会交错
+	mu       sync.Mutex
+	streams  map[uint16]*VirtualConn
+	lastID   uint16
+	draining bool          // 服务端正在关闭，不再在这个连接上发起新的流
+	err      error         // 连接断开的原因
+	done     chan struct{} // 连接断开时关闭
+}
+
+func (mc *muxConn) newVirtualConn() (*VirtualConn, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.err != nil || mc.draining {
+		return nil, ErrConnClosed
+	}
+
+	for i := 0; i <= 0xffff; i++ {
+		mc.lastID++
+		// stream ID 0 is left for non-multiplexed requests
+		if mc.lastID == 0 {
+			continue
+		}
+		if _, ok := mc.streams[mc.lastID]; ok {
+			continue
+		}
+
+		vc := &VirtualConn{
+			id:     mc.lastID,
+			conn:   mc,
+			notify: make(chan struct{}, 1),
+			space:  make(chan struct{}, 1),
+			closed: make(chan struct{}),
+		}
+		mc.streams[vc.id] = vc
+		return vc, nil
+	}
+
+	return nil, ErrStreamsExhausted
+}
+
+func (mc *muxConn) readLoop() {
+	for {
+		frame, err := mc.framer.ReadFrame(mc.Conn)
+		if err != nil {
+			mc.close(err)
+			return
+		}
+		mc.touch()
+
+		// pongs only prove the connection is alive
+		if codec.MsgType(frame) == codec.HeartbeatMsg {
+			continue
+		}
+
+		// the server is going away, streams already on the connection still get their replies
+		if codec.MsgType(frame) == codec.GoAwayMsg {
+			mc.mu.Lock()
+			mc.draining = true
+			mc.mu.Unlock()
+			continue
+		}
+
+		mc.mu.Lock()
+		vc := mc.streams[codec.StreamID(frame)]
+		mc.mu.Unlock()
+
+		// the caller has already given up on this stream
+		if vc == nil {
+			continue
+		}
+		vc.dispatch(frame)
+	}
+}
+
+func (mc *muxConn) write(frame []byte) error {
+	mc.writeMu.Lock()
+	defer mc.writeMu.Unlock()
+
+	if mc.isClosed() {
+		return ErrConnClosed
+	}
+
+	sendNum := 0
+	for sendNum < len(frame) {
+		num, err := mc.Conn.Write(frame[sendNum:])
+		if err != nil {
+			// a partly written frame corrupts the connection for every stream
+			mc.close(err)
+			return err
+		}
+		sendNum += num
+	}
+
+	return nil
+}
+
+func (mc *muxConn) isDraining() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return mc.draining
+}
+
+func (mc *muxConn) isClosed() bool {
+	select {
+	case <-mc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mc *muxConn) close(err error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.err != nil {
+		return
+	}
+	if err == nil {
+		err = ErrConnClosed
+	}
+	mc.err = err
+	close(mc.done)
+	mc.Conn.Close()
+}
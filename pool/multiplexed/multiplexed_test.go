@@ -0,0 +1,396 @@
+package multiplexed
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/auth"
+	"github.com/xing-you-ji/novarpc/codec"
+)
+
+type testFramer struct{}
+
+func (f *testFramer) ReadFrame(conn net.Conn) ([]byte, error) {
+	frame := make([]byte, codec.FrameHeadLen)
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(frame[7:11]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	return append(frame, payload...), nil
+}
+
+// newEchoServer answers every frame after a random delay, so replies come back out of order
+func newEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				var mu sync.Mutex
+				framer := &testFramer{}
+				for {
+					frame, err := framer.ReadFrame(conn)
+					if err != nil {
+						conn.Close()
+						return
+					}
+					go func() {
+						time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+						mu.Lock()
+						conn.Write(frame)
+						mu.Unlock()
+					}()
+				}
+			}()
+		}
+	}()
+
+	return ln
+}
+
+func TestPoolConcurrentRequests(t *testing.T) {
+	ln := newEchoServer(t)
+	defer ln.Close()
+
+	p := NewPool(func() Framer { return &testFramer{} }, WithConnsPerAddr(1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			vc, err := p.Get(ctx, "tcp", ln.Addr().String())
+			assert.Nil(t, err)
+			defer vc.Close()
+
+			msg := fmt.Sprintf("request-%d", i)
+			frame, err := codec.DefaultCodec.Encode([]byte(msg))
+			assert.Nil(t, err)
+			assert.Nil(t, vc.Write(frame))
+
+			rsp, err := vc.Read(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, vc.StreamID(), codec.StreamID(rsp))
+			payload, err := codec.DefaultCodec.Decode(rsp)
+			assert.Nil(t, err)
+			assert.Equal(t, msg, string(payload))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, len(p.groups))
+}
+
+func TestPoolOutOfOrderReplies(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	// the server reads two requests on one connection and answers the second one first
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		framer := &testFramer{}
+		first, err := framer.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		second, err := framer.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		conn.Write(second)
+		conn.Write(first)
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	p := NewPool(func() Framer { return &testFramer{} }, WithConnsPerAddr(1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var vcs []*VirtualConn
+	for i := 0; i < 2; i++ {
+		vc, err := p.Get(ctx, "tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		defer vc.Close()
+		frame, err := codec.DefaultCodec.Encode([]byte(fmt.Sprintf("request-%d", i)))
+		assert.Nil(t, err)
+		assert.Nil(t, vc.Write(frame))
+		vcs = append(vcs, vc)
+	}
+	assert.True(t, vcs[0].conn == vcs[1].conn)
+
+	// every reply goes to the stream that sent the request
+	for i := 1; i >= 0; i-- {
+		rsp, err := vcs[i].Read(ctx)
+		assert.Nil(t, err)
+		payload, err := codec.DefaultCodec.Decode(rsp)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("request-%d", i), string(payload))
+	}
+}
+
+func TestVirtualConnBackpressure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	// the server streams more frames than the stream buffers
+	total := maxStreamFrames + 5
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := (&testFramer{}).ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		for i := 0; i < total; i++ {
+			frame, _ := codec.DefaultCodec.Encode([]byte(fmt.Sprint(i)))
+			codec.SetStreamID(frame, codec.StreamID(req))
+			conn.Write(frame)
+		}
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	p := NewPool(func() Framer { return &testFramer{} }, WithConnsPerAddr(1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	vc, err := p.Get(ctx, "tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer vc.Close()
+	frame, err := codec.DefaultCodec.Encode(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, vc.Write(frame))
+
+	// the reader goroutine waits for the stream to be read instead of buffering without limit
+	buffered := func() int {
+		vc.mu.Lock()
+		defer vc.mu.Unlock()
+		return len(vc.frames)
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&vc.conn.blocked) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, maxStreamFrames, buffered())
+
+	for i := 0; i < total; i++ {
+		rsp, err := vc.Read(ctx)
+		assert.Nil(t, err)
+		payload, err := codec.DefaultCodec.Decode(rsp)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprint(i), string(payload))
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&vc.conn.blocked))
+}
+
+func TestVirtualConnReadAfterConnClosed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	p := NewPool(func() Framer { return &testFramer{} })
+	vc, err := p.Get(context.Background(), "tcp", ln.Addr().String())
+	assert.Nil(t, err)
+
+	_, err = vc.Read(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestPoolGoAway(t *testing.T) {
+	ln := newEchoServer(t)
+	defer ln.Close()
+
+	p := NewPool(func() Framer { return &testFramer{} }, WithConnsPerAddr(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	vc, err := p.Get(ctx, "tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer vc.Close()
+
+	// the echo server sends the GOAWAY frame back, as a server shutting down would
+	goAway, err := codec.DefaultCodec.Encode(nil)
+	assert.Nil(t, err)
+	codec.SetMsgType(goAway, codec.GoAwayMsg)
+	assert.Nil(t, vc.conn.write(goAway))
+
+	frame, err := codec.DefaultCodec.Encode([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Nil(t, vc.Write(frame))
+
+	// streams already on the connection still get their replies
+	rsp, err := vc.Read(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, vc.StreamID(), codec.StreamID(rsp))
+	assert.Eventually(t, vc.conn.isDraining, time.Second, 5*time.Millisecond)
+
+	// new streams go to a new connection
+	next, err := p.Get(ctx, "tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer next.Close()
+	assert.True(t, vc.conn != next.conn)
+}
+
+func TestPoolHeartbeat(t *testing.T) {
+	ln := newEchoServer(t)
+	defer ln.Close()
+
+	// the echo server answers pings within 20ms, the idle connection is kept
+	p := NewPool(func() Framer { return &testFramer{} }, WithConnsPerAddr(1),
+		WithHeartbeat(50*time.Millisecond), WithMaxMissedPongs(2))
+	vc, err := p.Get(context.Background(), "tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer vc.Close()
+
+	time.Sleep(300 * time.Millisecond)
+	assert.False(t, vc.conn.isClosed())
+}
+
+func TestPoolHeartbeatTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	// a half-open peer: reads everything and never answers
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	p := NewPool(func() Framer { return &testFramer{} }, WithConnsPerAddr(1),
+		WithHeartbeat(20*time.Millisecond), WithMaxMissedPongs(2))
+	vc, err := p.Get(context.Background(), "tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer vc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = vc.Read(ctx)
+	assert.Equal(t, ErrHeartbeatTimeout, err)
+}
+
+// blockingAuth holds the first handshake until release is closed
+type blockingAuth struct {
+	handshakes int32
+	release    chan struct{}
+}
+
+func (a *blockingAuth) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, auth.AuthInfo, error) {
+	if atomic.AddInt32(&a.handshakes, 1) == 1 {
+		<-a.release
+	}
+	return conn, nil, nil
+}
+
+func (a *blockingAuth) ServerHandshake(conn net.Conn) (net.Conn, auth.AuthInfo, error) {
+	return conn, nil, nil
+}
+
+func TestPoolDialOutsideLock(t *testing.T) {
+	ln := newEchoServer(t)
+	defer ln.Close()
+
+	a := &blockingAuth{release: make(chan struct{})}
+	p := NewPool(func() Framer { return &testFramer{} }, WithConnsPerAddr(2), WithTransportAuth(a))
+
+	// the first slot is stuck dialing
+	first := make(chan error, 1)
+	go func() {
+		vc, err := p.Get(context.Background(), "tcp", ln.Addr().String())
+		if err == nil {
+			vc.Close()
+		}
+		first <- err
+	}()
+	for atomic.LoadInt32(&a.handshakes) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the second slot is not blocked by it
+	vc, err := p.Get(context.Background(), "tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	vc.Close()
+
+	// a request for the first slot waits for the dial in flight instead of dialing again
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(ctx, "tcp", ln.Addr().String())
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(a.release)
+	assert.Nil(t, <-first)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&a.handshakes))
+}
+
+func TestPoolDialDetached(t *testing.T) {
+	ln := newEchoServer(t)
+	defer ln.Close()
+
+	a := &blockingAuth{release: make(chan struct{})}
+	p := NewPool(func() Framer { return &testFramer{} }, WithConnsPerAddr(1), WithTransportAuth(a),
+		WithDialTimeout(time.Second))
+
+	// the request that starts the dial gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := p.Get(ctx, "tcp", ln.Addr().String())
+		first <- err
+	}()
+	for atomic.LoadInt32(&a.handshakes) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a request waiting for the same dial still gets the connection
+	second := make(chan error, 1)
+	go func() {
+		vc, err := p.Get(context.Background(), "tcp", ln.Addr().String())
+		if err == nil {
+			vc.Close()
+		}
+		second <- err
+	}()
+	cancel()
+	assert.Equal(t, context.Canceled, <-first)
+
+	close(a.release)
+	assert.Nil(t, <-second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&a.handshakes))
+}
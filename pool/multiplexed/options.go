@@ -0,0 +1,51 @@
+package multiplexed
+
+import (
+	"time"
+
+	"github.com/xing-you-ji/novarpc/auth"
+)
+
+type Options struct {
+	connsPerAddr  int                // long-lived connections kept for each address
+	dialTimeout   time.Duration      // dial timeout
+	transportAuth auth.TransportAuth // handshake of new connections, nil means plaintext
+
+	heartbeatInterval time.Duration // ping interval of idle connections, 0 disables heartbeats
+	maxMissedPongs    int           // connections missing more pongs in a row are closed
+}
+
+type Option func(*Options)
+
+func WithConnsPerAddr(connsPerAddr int) Option {
+	return func(o *Options) {
+		o.connsPerAddr = connsPerAddr
+	}
+}
+
+func WithDialTimeout(dialTimeout time.Duration) Option {
+	return func(o *Options) {
+		o.dialTimeout = dialTimeout
+	}
+}
+
+// WithTransportAuth handshakes every new connection before streams are opened on it
+func WithTransportAuth(transportAuth auth.TransportAuth) Option {
+	return func(o *Options) {
+		o.transportAuth = transportAuth
+	}
+}
+
+// WithHeartbeat pings a connection that has received nothing for the interval, 0 disables heartbeats
+func WithHeartbeat(interval time.Duration) Option {
+	return func(o *Options) {
+		o.heartbeatInterval = interval
+	}
+}
+
+// WithMaxMissedPongs closes a connection after it misses the given number of pongs in a row
+func WithMaxMissedPongs(maxMissedPongs int) Option {
+	return func(o *Options) {
+		o.maxMissedPongs = maxMissedPongs
+	}
+}
@@ -0,0 +1,115 @@
+package multiplexed
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xing-you-ji/novarpc/codec"
+)
+
+// maxStreamFrames 每个流缓存的未读帧数，缓存满时暂停读取连接，直到读者取走帧，压力通过 TCP 传回服务端
+const maxStreamFrames = 64
+
+// VirtualConn is one stream on a shared connection, frames written through it
+// carry its stream ID and only frames tagged with that ID can be read from it
+type VirtualConn struct {
+	id        uint16
+	conn      *muxConn
+	mu        sync.Mutex
+	frames    [][]byte      // 已收到但还未读取的帧，最多 maxStreamFrames 个
+	notify    chan struct{} // 收到新帧时通知读者
+	space     chan struct{} // 读者取走帧时通知等待缓存的 dispatch
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// StreamID returns the stream ID of the virtual connection
+func (vc *VirtualConn) StreamID() uint16 {
+	return vc.id
+}
+
+// Write tags a complete frame with the stream ID and sends it
+func (vc *VirtualConn) Write(frame []byte) error {
+	select {
+	case <-vc.closed:
+		return ErrConnClosed
+	default:
+	}
+
+	codec.SetStreamID(frame, vc.id)
+	return vc.conn.write(frame)
+}
+
+// Read waits for the next frame of this stream
+func (vc *VirtualConn) Read(ctx context.Context) ([]byte, error) {
+	for {
+		vc.mu.Lock()
+		if len(vc.frames) > 0 {
+			frame := vc.frames[0]
+			vc.frames[0] = nil
+			vc.frames = vc.frames[1:]
+			vc.mu.Unlock()
+
+			select {
+			case vc.space <- struct{}{}:
+			default:
+			}
+			return frame, nil
+		}
+		vc.mu.Unlock()
+
+		select {
+		case <-vc.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-vc.closed:
+			return nil, ErrConnClosed
+		case <-vc.conn.done:
+			return nil, vc.conn.err
+		}
+	}
+}
+
+// Close releases the stream ID, frames arriving later for it are dropped
+func (vc *VirtualConn) Close() error {
+	vc.closeOnce.Do(func() {
+		close(vc.closed)
+
+		vc.conn.mu.Lock()
+		delete(vc.conn.streams, vc.id)
+		vc.conn.mu.Unlock()
+	})
+	return nil
+}
+
+// dispatch 缓存收到的帧，缓存满时等待读者取走帧或者流关闭，等待期间连接上的其他帧也不会被读取
+func (vc *VirtualConn) dispatch(frame []byte) {
+	vc.mu.Lock()
+	for len(vc.frames) >= maxStreamFrames {
+		vc.mu.Unlock()
+		atomic.StoreInt32(&vc.conn.blocked, 1)
+		select {
+		case <-vc.space:
+		case <-vc.closed:
+		case <-vc.conn.done:
+		}
+		atomic.StoreInt32(&vc.conn.blocked, 0)
+
+		select {
+		case <-vc.closed:
+			return
+		case <-vc.conn.done:
+			return
+		default:
+		}
+		vc.mu.Lock()
+	}
+	vc.frames = append(vc.frames, frame)
+	vc.mu.Unlock()
+
+	select {
+	case vc.notify <- struct{}{}:
+	default:
+	}
+}
@@ -80,6 +80,8 @@ type Response struct {
 	RetMsg               string            `protobuf:"bytes,2,opt,name=ret_msg,json=retMsg,proto3" json:"ret_msg,omitempty"`
 	Metadata             map[string][]byte `protobuf:"bytes,3,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	Payload              []byte            `protobuf:"bytes,4,opt,name=payload,proto3" json:"payload,omitempty"`
+	RetType              uint32            `protobuf:"varint,5,opt,name=ret_type,json=retType,proto3" json:"ret_type,omitempty"`
+	Details              []*Detail         `protobuf:"bytes,6,rep,name=details,proto3" json:"details,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
 	XXX_unrecognized     []byte            `json:"-"`
 	XXX_sizecache        int32             `json:"-"`
@@ -138,33 +140,98 @@ func (m *Response) GetPayload() []byte {
 	return nil
 }
 
+func (m *Response) GetRetType() uint32 {
+	if m != nil {
+		return m.RetType
+	}
+	return 0
+}
+
+func (m *Response) GetDetails() []*Detail {
+	if m != nil {
+		return m.Details
+	}
+	return nil
+}
+
+type Detail struct {
+	Type                 string   `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Value                []byte   `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Detail) Reset()         { *m = Detail{} }
+func (m *Detail) String() string { return proto.CompactTextString(m) }
+func (*Detail) ProtoMessage()    {}
+func (*Detail) Descriptor() ([]byte, []int) {
+	return fileDescriptor_c06e4cca6c2cc899, []int{2}
+}
+
+func (m *Detail) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Detail.Unmarshal(m, b)
+}
+func (m *Detail) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Detail.Marshal(b, m, deterministic)
+}
+func (m *Detail) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Detail.Merge(m, src)
+}
+func (m *Detail) XXX_Size() int {
+	return xxx_messageInfo_Detail.Size(m)
+}
+func (m *Detail) XXX_DiscardUnknown() {
+	xxx_messageInfo_Detail.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Detail proto.InternalMessageInfo
+
+func (m *Detail) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Detail) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Request)(nil), "protocol.Request")
 	proto.RegisterMapType((map[string][]byte)(nil), "protocol.Request.MetadataEntry")
 	proto.RegisterType((*Response)(nil), "protocol.Response")
 	proto.RegisterMapType((map[string][]byte)(nil), "protocol.Response.MetadataEntry")
+	proto.RegisterType((*Detail)(nil), "protocol.Detail")
 }
 
 func init() { proto.RegisterFile("msg.proto", fileDescriptor_c06e4cca6c2cc899) }
 
 var fileDescriptor_c06e4cca6c2cc899 = []byte{
-	// 277 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x8f, 0x41, 0x4b, 0xc3, 0x40,
-	0x14, 0x84, 0xd9, 0x56, 0xdb, 0xf4, 0x35, 0x05, 0x59, 0x04, 0xa3, 0x17, 0x63, 0x45, 0xc8, 0x29,
-	0x01, 0xbd, 0x88, 0xf5, 0xa4, 0x78, 0x2c, 0xc8, 0x1e, 0xbd, 0x84, 0x4d, 0xf2, 0x48, 0xaa, 0x49,
-	0x76, 0xdd, 0x7d, 0x29, 0xe4, 0xdf, 0xf9, 0x1f, 0xfc, 0x43, 0xd2, 0x34, 0x11, 0xc5, 0xbb, 0xa7,
-	0x9d, 0x59, 0xde, 0x0c, 0xf3, 0xc1, 0xac, 0xb2, 0x79, 0xa8, 0x8d, 0x22, 0xc5, 0x9d, 0xee, 0x49,
-	0x55, 0xb9, 0xfc, 0x60, 0x30, 0x15, 0xf8, 0xde, 0xa0, 0x25, 0x7e, 0x01, 0xae, 0x45, 0xb3, 0xdd,
-	0xa4, 0x18, 0x6b, 0x49, 0x85, 0x37, 0xf2, 0x59, 0x30, 0x13, 0xf3, 0xfe, 0xef, 0x59, 0x52, 0xc1,
-	0x57, 0xe0, 0x54, 0x48, 0x32, 0x93, 0x24, 0xbd, 0xb1, 0x3f, 0x0e, 0xe6, 0xd7, 0xe7, 0xe1, 0xd0,
-	0x15, 0xf6, 0x3d, 0xe1, 0xba, 0xbf, 0x78, 0xaa, 0xc9, 0xb4, 0xe2, 0x3b, 0xc0, 0x3d, 0x98, 0x6a,
-	0xd9, 0x96, 0x4a, 0x66, 0xde, 0x81, 0xcf, 0x02, 0x57, 0x0c, 0xf6, 0x6c, 0x05, 0x8b, 0x5f, 0x21,
-	0x7e, 0x04, 0xe3, 0x37, 0x6c, 0x3d, 0xd6, 0x2d, 0xd8, 0x49, 0x7e, 0x0c, 0x87, 0x5b, 0x59, 0x36,
-	0xd8, 0xad, 0x72, 0xc5, 0xde, 0xdc, 0x8d, 0x6e, 0xd9, 0xf2, 0x93, 0x81, 0x23, 0xd0, 0x6a, 0x55,
-	0x5b, 0xe4, 0xa7, 0xe0, 0x18, 0xa4, 0x38, 0x55, 0x19, 0x76, 0xe9, 0x85, 0x98, 0x1a, 0xa4, 0x47,
-	0x95, 0x21, 0x3f, 0x81, 0x9d, 0x8c, 0x2b, 0x9b, 0xf7, 0x64, 0x13, 0x83, 0xb4, 0xb6, 0x39, 0xbf,
-	0xff, 0x03, 0xe5, 0xff, 0x84, 0xda, 0x37, 0xff, 0x33, 0xd5, 0xc3, 0xd5, 0xcb, 0x65, 0xbe, 0xa1,
-	0xa2, 0x49, 0xc2, 0x54, 0x55, 0x51, 0xd9, 0x24, 0xb2, 0xd6, 0x46, 0xbd, 0x46, 0xb9, 0x32, 0x3a,
-	0x8d, 0x86, 0x79, 0xc9, 0xa4, 0x53, 0x37, 0x5f, 0x01, 0x00, 0x00, 0xff, 0xff, 0x97, 0x05, 0x81,
-	0xd1, 0xdd, 0x01, 0x00, 0x00,
+	// 326 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x90, 0xcd, 0x4a, 0xfb, 0x40,
+	0x14, 0xc5, 0x49, 0x3f, 0x92, 0xf4, 0xb6, 0x85, 0x32, 0xfc, 0xe1, 0x3f, 0xba, 0x31, 0x56, 0x84,
+	0xe2, 0x22, 0x85, 0xba, 0x11, 0xeb, 0xca, 0x8f, 0x65, 0x41, 0x06, 0x57, 0x6e, 0xca, 0x34, 0xb9,
+	0xa4, 0xd5, 0x24, 0x33, 0xce, 0x4c, 0x0a, 0x79, 0x15, 0x9f, 0xc6, 0x47, 0x93, 0x4e, 0x92, 0xaa,
+	0xe8, 0x46, 0x5c, 0xe5, 0xdc, 0xe4, 0x9e, 0x73, 0x7f, 0x27, 0xd0, 0xcb, 0x74, 0x12, 0x4a, 0x25,
+	0x8c, 0x20, 0xbe, 0x7d, 0x44, 0x22, 0x1d, 0xbf, 0x39, 0xe0, 0x31, 0x7c, 0x29, 0x50, 0x1b, 0x72,
+	0x0c, 0x03, 0x8d, 0x6a, 0xbb, 0x89, 0x70, 0x29, 0xb9, 0x59, 0xd3, 0x56, 0xe0, 0x4c, 0x7a, 0xac,
+	0x5f, 0xbf, 0xbb, 0xe7, 0x66, 0x4d, 0xe6, 0xe0, 0x67, 0x68, 0x78, 0xcc, 0x0d, 0xa7, 0xed, 0xa0,
+	0x3d, 0xe9, 0xcf, 0x8e, 0xc2, 0x26, 0x2b, 0xac, 0x73, 0xc2, 0x45, 0xbd, 0x71, 0x97, 0x1b, 0x55,
+	0xb2, 0xbd, 0x81, 0x50, 0xf0, 0x24, 0x2f, 0x53, 0xc1, 0x63, 0xda, 0x09, 0x9c, 0xc9, 0x80, 0x35,
+	0xe3, 0xe1, 0x1c, 0x86, 0x5f, 0x4c, 0x64, 0x04, 0xed, 0x67, 0x2c, 0xa9, 0x63, 0x09, 0x76, 0x92,
+	0xfc, 0x83, 0xee, 0x96, 0xa7, 0x05, 0x5a, 0xaa, 0x01, 0xab, 0x86, 0xcb, 0xd6, 0x85, 0x33, 0x7e,
+	0x6d, 0x81, 0xcf, 0x50, 0x4b, 0x91, 0x6b, 0x24, 0x07, 0xe0, 0x2b, 0x34, 0xcb, 0x48, 0xc4, 0x68,
+	0xdd, 0x43, 0xe6, 0x29, 0x34, 0x37, 0x22, 0x46, 0xf2, 0x1f, 0x76, 0x72, 0x99, 0xe9, 0xa4, 0x6e,
+	0xe6, 0x2a, 0x34, 0x0b, 0x9d, 0x90, 0xab, 0x6f, 0xa5, 0x82, 0xcf, 0xa5, 0xaa, 0xe4, 0xdf, 0xb7,
+	0x6a, 0x58, 0x4c, 0x29, 0x91, 0x76, 0xf7, 0x2c, 0x0f, 0xa5, 0x44, 0x72, 0x06, 0x5e, 0x8c, 0x86,
+	0x6f, 0x52, 0x4d, 0x5d, 0x7b, 0x71, 0xf4, 0x71, 0xf1, 0xd6, 0x7e, 0x60, 0xcd, 0xc2, 0xdf, 0x7e,
+	0xce, 0x0c, 0xdc, 0x2a, 0x8f, 0x10, 0xe8, 0x58, 0x92, 0xca, 0x66, 0xf5, 0xcf, 0xbe, 0xeb, 0xd3,
+	0xc7, 0x93, 0x64, 0x63, 0xd6, 0xc5, 0x2a, 0x8c, 0x44, 0x36, 0x4d, 0x8b, 0x15, 0xcf, 0xa5, 0x12,
+	0x4f, 0xd3, 0x44, 0x28, 0x19, 0x4d, 0x1b, 0xce, 0x95, 0x6b, 0xd5, 0xf9, 0xfb, 0x00, 0x6f, 0xda,
+	0x45, 0x9a, 0x58, 0x02, 0x00, 0x00,
 }
@@ -17,6 +17,8 @@ const (
 	RoundRobin         = "roundRobin"
 	WeightedRoundRobin = "weightedRoundRobin"
 	ConsistentHash     = "consistentHash"
+	LeastLoaded        = "leastLoaded"
+	PowerOfTwoChoices  = "p2c"
 	Custom             = "custom"
 )
 
@@ -24,6 +26,9 @@ func init() {
 	RegisterBalancer(Random, DefaultBalancer)
 	RegisterBalancer(RoundRobin, RRBalancer)
 	RegisterBalancer(WeightedRoundRobin, WRRBalancer)
+	RegisterBalancer(ConsistentHash, CHBalancer)
+	RegisterBalancer(LeastLoaded, LLBalancer)
+	RegisterBalancer(PowerOfTwoChoices, P2CBalancer)
 }
 
 // RandomBalancer is adopted as the default load balancer
@@ -35,6 +40,16 @@ var RRBalancer = newRoundRobinBalancer()
 // A unique WeightedRoundRobinBalancer instance is used globally
 var WRRBalancer = newWeightedRoundRobinBalancer()
 
+// A unique consistent hash Balancer instance is used globally, register one built by
+// NewConsistentHashBalancer under ConsistentHash to change the virtual nodes or the hash function
+var CHBalancer = NewConsistentHashBalancer(DefaultReplicas, nil)
+
+// A unique least loaded Balancer instance is used globally, it learns the load of nodes through Feedback
+var LLBalancer = newLeastLoadedBalancer()
+
+// A unique power of two choices Balancer instance is used globally, it learns the load of nodes through Feedback
+var P2CBalancer = newP2CBalancer()
+
 // RegisterBalancer supports business custom registered Balancer
 func RegisterBalancer(name string, balancer Balancer) {
 	if balancerMap == nil {
@@ -0,0 +1,111 @@
+package selector
+
+import (
+	"context"
+	"sync"
+)
+
+// ContextSelector is a Selector that uses information of the call carried by the context,
+// e.g. the hash key of consistent hashing
+type ContextSelector interface {
+	Selector
+	SelectContext(context.Context, string) (string, error)
+}
+
+// ContextBalancer is a Balancer that uses information of the call carried by the context
+type ContextBalancer interface {
+	Balancer
+	BalanceContext(context.Context, string, []*Node) *Node
+}
+
+// Select selects a node for the call with s, passing ctx on if s is a ContextSelector
+func Select(ctx context.Context, s Selector, serviceName string) (string, error) {
+	if cs, ok := s.(ContextSelector); ok {
+		return cs.SelectContext(ctx, serviceName)
+	}
+	return s.Select(serviceName)
+}
+
+// Balance picks a node for the call with b, passing ctx on if b is a ContextBalancer
+func Balance(ctx context.Context, b Balancer, serviceName string, nodes []*Node) *Node {
+	if cb, ok := b.(ContextBalancer); ok {
+		return cb.BalanceContext(ctx, serviceName, nodes)
+	}
+	return b.Balance(serviceName, nodes)
+}
+
+type hashKey struct{}
+
+// WithHashKey creates a new context carrying the key the consistent hash balancer routes the call by,
+// e.g. a user ID, calls with the same key go to the same node
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKey returns the hash key of the call, false if the caller did not set one
+func HashKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKey{}).(string)
+	return key, ok
+}
+
+type triedKey struct{}
+
+// tried 记录一次调用已经尝试过的节点
+type tried struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+// WithTried creates a new context recording the nodes a call tries, so that its retries go to other nodes
+func WithTried(ctx context.Context) context.Context {
+	return context.WithValue(ctx, triedKey{}, &tried{})
+}
+
+// MarkTried records that the call has tried addr, it does nothing if ctx was not created by WithTried
+func MarkTried(ctx context.Context, addr string) {
+	if t, ok := ctx.Value(triedKey{}).(*tried); ok {
+		t.mu.Lock()
+		t.addrs = append(t.addrs, addr)
+		t.mu.Unlock()
+	}
+}
+
+// Tried returns the addresses the call has tried
+func Tried(ctx context.Context) []string {
+	t, ok := ctx.Value(triedKey{}).(*tried)
+	if !ok {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.addrs...)
+}
+
+// Exclude removes the nodes the call has tried, selectors call it before balancing.
+// All the nodes are returned if every node has been tried.
+func Exclude(ctx context.Context, nodes []*Node) []*Node {
+	addrs := Tried(ctx)
+	if len(addrs) == 0 {
+		return nodes
+	}
+
+	var rest []*Node
+	for _, node := range nodes {
+		if !contains(addrs, node.Addr()) {
+			rest = append(rest, node)
+		}
+	}
+	if len(rest) == 0 {
+		return nodes
+	}
+	return rest
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package selector
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DoneInfo is the outcome of a call to a node
+type DoneInfo struct {
+	Err      error         // transport error of the call, business errors are not included
+	Duration time.Duration // time from sending the request to receiving the response
+}
+
+// Feedback is implemented by selectors and balancers that learn from the outcome of calls,
+// the client transport reports every call to the Selector, which passes it on to its Balancer
+type Feedback interface {
+	// Start is called before the request is sent to addr
+	Start(serviceName string, addr string)
+	// Done is called when the call to addr ends
+	Done(serviceName string, addr string, info DoneInfo)
+}
+
+// Track reports the start of a call to v if v is a Feedback,
+// the returned func reports the end of the call and must be called exactly once
+func Track(v interface{}, serviceName string, addr string) func(err error) {
+	f, ok := v.(Feedback)
+	if !ok {
+		return func(error) {}
+	}
+
+	start := time.Now()
+	f.Start(serviceName, addr)
+	return func(err error) {
+		f.Done(serviceName, addr, DoneInfo{Err: err, Duration: time.Since(start)})
+	}
+}
+
+const (
+	// ewmaDecay 延迟的衰减时间，越大对历史延迟的记忆越长
+	ewmaDecay = 10 * time.Second
+	// errorPenalty 调用失败时按这个延迟计算，避免快速失败的节点被认为是最快的节点
+	errorPenalty = time.Second
+)
+
+// nodeStats 客户端观察到的节点负载：正在进行的请求数和 peak EWMA 延迟
+type nodeStats struct {
+	inflight int64 // 正在进行的请求数
+
+	mu    sync.Mutex
+	ewma  float64   // 延迟的指数加权平均值，单位纳秒
+	stamp time.Time // ewma 最近一次更新的时间
+}
+
+// observe 记录一次调用的延迟：比平均值高时直接取峰值，否则按时间衰减
+func (s *nodeStats) observe(rtt float64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decay(rtt, now)
+}
+
+// latency returns the ewma decayed to now, so a node that was slow a while ago gets traffic again
+func (s *nodeStats) latency(now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decay(0, now)
+	return s.ewma
+}
+
+func (s *nodeStats) decay(rtt float64, now time.Time) {
+	if rtt > s.ewma {
+		s.ewma = rtt
+	} else if elapsed := now.Sub(s.stamp); elapsed > 0 {
+		w := math.Exp(-float64(elapsed) / float64(ewmaDecay))
+		s.ewma = s.ewma*w + rtt*(1-w)
+	}
+	if now.After(s.stamp) {
+		s.stamp = now
+	}
+}
+
+// loadTracker 按服务和节点地址记录负载，为负载感知的 Balancer 实现 Feedback
+type loadTracker struct {
+	stats *sync.Map // serviceName/addr -> *nodeStats
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{
+		stats: new(sync.Map),
+	}
+}
+
+func (t *loadTracker) get(serviceName string, addr string) *nodeStats {
+	key := serviceName + "/" + addr
+	if s, ok := t.stats.Load(key); ok {
+		return s.(*nodeStats)
+	}
+	s, _ := t.stats.LoadOrStore(key, &nodeStats{stamp: time.Now()})
+	return s.(*nodeStats)
+}
+
+func (t *loadTracker) Start(serviceName string, addr string) {
+	atomic.AddInt64(&t.get(serviceName, addr).inflight, 1)
+}
+
+func (t *loadTracker) Done(serviceName string, addr string, info DoneInfo) {
+	s := t.get(serviceName, addr)
+	atomic.AddInt64(&s.inflight, -1)
+
+	rtt := info.Duration
+	if info.Err != nil && rtt < errorPenalty {
+		rtt = errorPenalty
+	}
+	s.observe(float64(rtt), time.Now())
+}
+
+// UpdateNodes implements NodesObserver, the stats of the nodes removed from the service are dropped
+func (t *loadTracker) UpdateNodes(serviceName string, nodes []*Node) {
+	addrs := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		addrs[serviceName+"/"+node.Addr()] = true
+	}
+
+	prefix := serviceName + "/"
+	t.stats.Range(func(key, value interface{}) bool {
+		k := key.(string)
+		// 还有请求在进行的节点等请求结束后再删除
+		if strings.HasPrefix(k, prefix) && !addrs[k] && atomic.LoadInt64(&value.(*nodeStats).inflight) == 0 {
+			t.stats.Delete(k)
+		}
+		return true
+	})
+}
@@ -0,0 +1,106 @@
+package selector
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrack(t *testing.T) {
+	// 不支持 Feedback 时什么也不做
+	Track(DefaultBalancer, "Greeter", "127.0.0.1:8000")(nil)
+
+	b := newLeastLoadedBalancer()
+	done := Track(b, "Greeter", "127.0.0.1:8000")
+	s := b.get("Greeter", "127.0.0.1:8000")
+	assert.Equal(t, int64(1), s.inflight)
+
+	done(errors.New("connection refused"))
+	assert.Equal(t, int64(0), s.inflight)
+	// 失败的调用按 errorPenalty 计算延迟
+	assert.True(t, s.latency(time.Now()) > float64(errorPenalty)/2)
+}
+
+func TestPeakEwma(t *testing.T) {
+	now := time.Now()
+	s := &nodeStats{stamp: now}
+
+	// 延迟升高时立即取峰值
+	s.observe(float64(100*time.Millisecond), now)
+	assert.Equal(t, float64(100*time.Millisecond), s.ewma)
+
+	// 延迟降低时按时间衰减
+	s.observe(float64(10*time.Millisecond), now.Add(ewmaDecay))
+	assert.True(t, s.ewma < float64(100*time.Millisecond))
+	assert.True(t, s.ewma > float64(10*time.Millisecond))
+
+	// 长时间没有请求后恢复
+	assert.True(t, s.latency(now.Add(20*ewmaDecay)) < float64(time.Millisecond))
+}
+
+func TestLeastLoadedBalance(t *testing.T) {
+	b := newLeastLoadedBalancer()
+	nodes := hashNodes(3)
+	assert.Nil(t, b.Balance("Greeter", nil))
+
+	// 两个节点上都有请求在进行，选择空闲的节点
+	b.Start("Greeter", "127.0.0.1:8000")
+	b.Start("Greeter", "127.0.0.1:8001")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, nodes[2], b.Balance("Greeter", nodes))
+	}
+
+	// 负载相同时所有节点都会被选中
+	b.Start("Greeter", "127.0.0.1:8002")
+	picked := make(map[*Node]bool)
+	for i := 0; i < 100; i++ {
+		picked[b.Balance("Greeter", nodes)] = true
+	}
+	assert.Len(t, picked, 3)
+
+	// 权重为 0 的节点即使空闲也不分配流量
+	b.Done("Greeter", "127.0.0.1:8000", DoneInfo{})
+	nodes[0].Weight = WeightOf(0)
+	for i := 0; i < 10; i++ {
+		assert.NotEqual(t, nodes[0], b.Balance("Greeter", nodes))
+	}
+}
+
+func TestP2CBalance(t *testing.T) {
+	b := newP2CBalancer()
+	nodes := hashNodes(3)
+	assert.Nil(t, b.Balance("Greeter", nil))
+	assert.Equal(t, nodes[0], b.Balance("Greeter", nodes[:1]))
+
+	// 慢节点只有在两次都被随机选中时才会收到请求，这里两次选择的节点总是不同的
+	for i, d := range []time.Duration{time.Second, 5 * time.Millisecond, 5 * time.Millisecond} {
+		addr := nodes[i].Addr()
+		b.Start("Greeter", addr)
+		b.Done("Greeter", addr, DoneInfo{Duration: d})
+	}
+	picked := make(map[*Node]int)
+	for i := 0; i < 300; i++ {
+		picked[b.Balance("Greeter", nodes)]++
+	}
+	assert.Equal(t, 0, picked[nodes[0]])
+	assert.True(t, picked[nodes[1]] > 0 && picked[nodes[2]] > 0)
+
+	// 正在进行的请求多的节点分到的流量更少
+	for i := 0; i < 10; i++ {
+		b.Start("Greeter", nodes[1].Addr())
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, nodes[2], b.Balance("Greeter", nodes[1:]))
+	}
+
+	// 权重为 0 的节点不分配流量
+	nodes[2].Weight = WeightOf(0)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, nodes[1], b.Balance("Greeter", nodes[1:]))
+	}
+
+	assert.Equal(t, GetBalancer(PowerOfTwoChoices), P2CBalancer)
+	assert.Equal(t, GetBalancer(LeastLoaded), LLBalancer)
+}
@@ -0,0 +1,127 @@
+package selector
+
+import (
+	"context"
+	"sync"
+)
+
+// NodeFilter is carried by the context of a call and keeps nodes from being selected,
+// e.g. a circuit breaker skips the nodes that keep failing
+type NodeFilter interface {
+	// Ready reports whether addr may be selected, it is called for every node while balancing
+	Ready(serviceName string, addr string) bool
+	// Admit is called once for the selected node before the request is sent, the call fails if it returns false
+	Admit(serviceName string, addr string) bool
+}
+
+type filterKey struct{}
+
+// WithFilter creates a new context carrying f in addition to the filters ctx already carries
+func WithFilter(ctx context.Context, f NodeFilter) context.Context {
+	filters, _ := ctx.Value(filterKey{}).([]NodeFilter)
+	filters = append(filters[:len(filters):len(filters)], f)
+	return context.WithValue(ctx, filterKey{}, filters)
+}
+
+// FilterNodes removes the nodes the filters of the call are not ready for and the nodes the call has tried,
+// Pick calls it before balancing. Tried nodes are kept if every ready node has been tried.
+func FilterNodes(ctx context.Context, serviceName string, nodes []*Node) []*Node {
+	filters, _ := ctx.Value(filterKey{}).([]NodeFilter)
+	if len(filters) > 0 {
+		var ready []*Node
+		for _, node := range nodes {
+			if isReady(filters, serviceName, node.Addr()) {
+				ready = append(ready, node)
+			}
+		}
+		nodes = ready
+	}
+
+	return Exclude(ctx, nodes)
+}
+
+// FilterBalancer is implemented by balancers that keep state for all the nodes of a service, e.g. the hash ring
+// or the current weights of the weighted round robin. They are given all the nodes and skip the ones ready
+// returns false for, instead of rebuilding their state for every subset the filters of a call leave.
+type FilterBalancer interface {
+	BalanceFilter(ctx context.Context, serviceName string, nodes []*Node, ready func(*Node) bool) *Node
+}
+
+// Pick balances the call over the nodes FilterNodes leaves, nodes are all the nodes of the service
+func Pick(ctx context.Context, b Balancer, serviceName string, nodes []*Node) *Node {
+	ready := FilterNodes(ctx, serviceName, nodes)
+
+	fb, ok := b.(FilterBalancer)
+	if !ok {
+		return Balance(ctx, b, serviceName, ready)
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+	if len(ready) == len(nodes) {
+		return fb.BalanceFilter(ctx, serviceName, nodes, allReady)
+	}
+
+	keys := make(map[string]struct{}, len(ready))
+	for _, node := range ready {
+		keys[node.Key] = struct{}{}
+	}
+	return fb.BalanceFilter(ctx, serviceName, nodes, func(node *Node) bool {
+		_, ok := keys[node.Key]
+		return ok
+	})
+}
+
+func allReady(*Node) bool {
+	return true
+}
+
+func isReady(filters []NodeFilter, serviceName string, addr string) bool {
+	for _, f := range filters {
+		if !f.Ready(serviceName, addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Admit asks the filters of the call whether the request may be sent to addr
+func Admit(ctx context.Context, serviceName string, addr string) bool {
+	filters, _ := ctx.Value(filterKey{}).([]NodeFilter)
+	for _, f := range filters {
+		if !f.Admit(serviceName, addr) {
+			return false
+		}
+	}
+	return true
+}
+
+type peerKey struct{}
+
+// Peer records the node one attempt of a call is sent to
+type Peer struct {
+	mu   sync.Mutex
+	addr string
+}
+
+// WithPeer creates a new context recording the node the request is sent to
+func WithPeer(ctx context.Context) (context.Context, *Peer) {
+	p := &Peer{}
+	return context.WithValue(ctx, peerKey{}, p), p
+}
+
+// SetPeer records that the request is sent to addr, it does nothing if ctx was not created by WithPeer
+func SetPeer(ctx context.Context, addr string) {
+	if p, ok := ctx.Value(peerKey{}).(*Peer); ok {
+		p.mu.Lock()
+		p.addr = addr
+		p.mu.Unlock()
+	}
+}
+
+// Addr returns the address the request has been sent to, "" if no node has been selected
+func (p *Peer) Addr() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addr
+}
@@ -1,8 +1,183 @@
 package selector
 
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultReplicas 每个节点在哈希环上的虚拟节点数，越多分布越均匀
+const DefaultReplicas = 160
+
+// HashFunc maps data to a position on the hash ring
+type HashFunc func(data []byte) uint32
+
+// hashBalancer 一致性哈希负载均衡：相同 key 的调用落到同一个节点，节点变化时只有少量 key 需要重新映射
 type hashBalancer struct {
+	replicas int
+	hash     HashFunc
+	rings    *sync.Map // serviceName -> *hashRing
+}
+
+// md5Hash 取 md5 的前 4 个字节，与 ketama 相同；crc32 等哈希对相似的 key 分布不够均匀
+func md5Hash(data []byte) uint32 {
+	sum := md5.Sum(data)
+	return binary.LittleEndian.Uint32(sum[:4])
+}
+
+// NewConsistentHashBalancer creates a consistent hash Balancer, replicas is the number of virtual nodes
+// of every node and hash the hash function, DefaultReplicas and an md5 based hash are used if they are not set
+func NewConsistentHashBalancer(replicas int, hash HashFunc) Balancer {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	if hash == nil {
+		hash = md5Hash
+	}
+
+	return &hashBalancer{
+		replicas: replicas,
+		hash:     hash,
+		rings:    new(sync.Map),
+	}
+}
+
+// hashRing 节点列表不变时可以复用，节点变化时重建
+type hashRing struct {
+	nodes  []*Node           // 构建哈希环的节点，Resolver 在节点变化之前返回同一个切片
+	byKey  map[string]*Node  // 节点 key -> 节点
+	hashes []uint32          // 排好序的虚拟节点
+	keys   map[uint32]string // 虚拟节点 -> 节点 key
+}
+
+// Balance routes by the service name when the caller gives no hash key, so all calls go to one node
+func (h *hashBalancer) Balance(serviceName string, nodes []*Node) *Node {
+	return h.pick(serviceName, serviceName, nodes, allReady)
+}
+
+// BalanceContext routes by the hash key of the call, see WithHashKey
+func (h *hashBalancer) BalanceContext(ctx context.Context, serviceName string, nodes []*Node) *Node {
+	return h.BalanceFilter(ctx, serviceName, nodes, allReady)
+}
+
+// BalanceFilter implements FilterBalancer, the ring of all the nodes is kept and the call goes to the first
+// ready node clockwise from its key, so only the keys of the nodes that are not ready move
+func (h *hashBalancer) BalanceFilter(ctx context.Context, serviceName string, nodes []*Node, ready func(*Node) bool) *Node {
+	key, ok := HashKey(ctx)
+	if !ok {
+		key = serviceName
+	}
+	return h.pick(serviceName, key, nodes, ready)
+}
+
+func (h *hashBalancer) pick(serviceName string, key string, nodes []*Node, ready func(*Node) bool) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	ring := h.ring(serviceName, nodes)
+
+	// 顺时针找到第一个虚拟节点
+	hash := h.hash([]byte(key))
+	index := sort.Search(len(ring.hashes), func(i int) bool {
+		return ring.hashes[i] >= hash
+	})
+
+	// 跳过不可用节点的虚拟节点，继续顺时针查找
+	var skipped map[string]bool
+	for i := 0; i < len(ring.hashes); i++ {
+		nodeKey := ring.keys[ring.hashes[(index+i)%len(ring.hashes)]]
+		if skipped[nodeKey] {
+			continue
+		}
+		if node := ring.byKey[nodeKey]; node != nil && ready(node) {
+			return node
+		}
+
+		if skipped == nil {
+			skipped = make(map[string]bool)
+		}
+		skipped[nodeKey] = true
+		if len(skipped) == len(ring.byKey) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// UpdateNodes implements NodesObserver, the hash ring is rebuilt when the nodes change instead of by a call
+func (h *hashBalancer) UpdateNodes(serviceName string, nodes []*Node) {
+	h.ring(serviceName, nodes)
+}
+
+// ring returns the hash ring of the nodes. The ring is reused as long as the same slice is passed, which
+// is what the Resolver does until the nodes change, so the hot path does not look at the nodes at all.
+func (h *hashBalancer) ring(serviceName string, nodes []*Node) *hashRing {
+	var last *hashRing
+	if r, ok := h.rings.Load(serviceName); ok {
+		last = r.(*hashRing)
+		if sameSlice(last.nodes, nodes) {
+			return last
+		}
+	}
+
+	byKey := make(map[string]*Node, len(nodes))
+	for _, node := range nodes {
+		byKey[node.Key] = node
+	}
+
+	// 节点的 key 没有变化时复用虚拟节点，例如节点只修改了权重
+	ring := &hashRing{nodes: nodes, byKey: byKey}
+	if last != nil && sameKeys(last.byKey, byKey) {
+		ring.hashes, ring.keys = last.hashes, last.keys
+		h.rings.Store(serviceName, ring)
+		return ring
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ring.hashes = make([]uint32, 0, len(keys)*h.replicas)
+	ring.keys = make(map[uint32]string, len(keys)*h.replicas)
+	for _, key := range keys {
+		for i := 0; i < h.replicas; i++ {
+			hash := h.hash([]byte(key + "#" + strconv.Itoa(i)))
+			// 哈希冲突时保留先加入的节点，结果与节点顺序无关
+			if _, ok := ring.keys[hash]; ok {
+				continue
+			}
+			ring.keys[hash] = key
+			ring.hashes = append(ring.hashes, hash)
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool {
+		return ring.hashes[i] < ring.hashes[j]
+	})
+
+	h.rings.Store(serviceName, ring)
+	return ring
+}
+
+// sameSlice 是否是同一个切片，不比较节点的内容
+func sameSlice(a, b []*Node) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
 }
 
-func (h hashBalancer) Balance(s string, nodes []*Node) *Node {
-	panic("implement me")
+func sameKeys(a, b map[string]*Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,167 @@
+package selector
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hashNodes(n int) []*Node {
+	var nodes []*Node
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("127.0.0.1:%d", 8000+i)
+		nodes = append(nodes, &Node{Key: "Greeter/" + addr, Value: []byte(addr)})
+	}
+	return nodes
+}
+
+func TestConsistentHashBalance(t *testing.T) {
+	b := NewConsistentHashBalancer(0, nil)
+	nodes := hashNodes(5)
+
+	assert.Nil(t, b.Balance("Greeter", nil))
+
+	// 同一个 key 始终选择同一个节点，与节点顺序无关
+	ctx := WithHashKey(context.Background(), "user-42")
+	node := Balance(ctx, b, "Greeter", nodes)
+	assert.NotNil(t, node)
+	reversed := []*Node{nodes[4], nodes[3], nodes[2], nodes[1], nodes[0]}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, node, Balance(ctx, b, "Greeter", nodes))
+		assert.Equal(t, node, Balance(ctx, b, "Greeter", reversed))
+	}
+
+	// 不同的 key 分散到所有节点
+	picked := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		ctx := WithHashKey(context.Background(), fmt.Sprintf("user-%d", i))
+		picked[Balance(ctx, b, "Greeter", nodes).Key]++
+	}
+	assert.Len(t, picked, 5)
+	for _, count := range picked {
+		assert.True(t, count > 100, "uneven distribution %v", picked)
+	}
+}
+
+func TestConsistentHashRemapping(t *testing.T) {
+	b := NewConsistentHashBalancer(DefaultReplicas, nil)
+	nodes := hashNodes(4)
+
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		before[key] = Balance(WithHashKey(context.Background(), key), b, "Greeter", nodes).Key
+	}
+
+	// 增加一个节点，只有迁移到新节点的 key 发生变化
+	nodes = hashNodes(5)
+	added := nodes[4].Key
+	moved := 0
+	for key, old := range before {
+		now := Balance(WithHashKey(context.Background(), key), b, "Greeter", nodes).Key
+		if now != old {
+			assert.Equal(t, added, now)
+			moved++
+		}
+	}
+	assert.True(t, moved > 0 && moved < 400, "moved %d keys", moved)
+
+	// 删除节点，只有原来在该节点上的 key 发生变化
+	nodes = hashNodes(4)
+	for key, old := range before {
+		assert.Equal(t, old, Balance(WithHashKey(context.Background(), key), b, "Greeter", nodes).Key)
+	}
+}
+
+func TestConsistentHashFunc(t *testing.T) {
+	calls := 0
+	b := NewConsistentHashBalancer(10, func(data []byte) uint32 {
+		calls++
+		h := fnv.New32a()
+		h.Write(data)
+		return h.Sum32()
+	})
+	nodes := hashNodes(3)
+
+	// 没有 key 时按服务名路由
+	node := b.Balance("Greeter", nodes)
+	assert.Equal(t, node, Balance(context.Background(), b, "Greeter", nodes))
+	assert.Equal(t, 3*10+2, calls)
+
+	assert.Equal(t, GetBalancer(ConsistentHash), CHBalancer)
+}
+
+func TestExclude(t *testing.T) {
+	nodes := hashNodes(3)
+	assert.Equal(t, nodes, Exclude(context.Background(), nodes))
+
+	ctx := WithTried(context.Background())
+	MarkTried(ctx, "127.0.0.1:8000")
+	assert.Equal(t, nodes[1:], Exclude(ctx, nodes))
+
+	// 所有节点都尝试过时不排除
+	MarkTried(ctx, "127.0.0.1:8001")
+	MarkTried(ctx, "127.0.0.1:8002")
+	assert.Equal(t, nodes, Exclude(ctx, nodes))
+	assert.Len(t, Tried(ctx), 3)
+}
+
+func TestConsistentHashPick(t *testing.T) {
+	b := NewConsistentHashBalancer(0, nil).(*hashBalancer)
+	nodes := hashNodes(5)
+	b.UpdateNodes("Greeter", nodes)
+	ring, _ := b.rings.Load("Greeter")
+
+	owners := make(map[string]*Node)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		owners[key] = Pick(WithHashKey(context.Background(), key), b, "Greeter", nodes)
+	}
+
+	// 重试时跳过尝试过的节点，只有这个节点上的 key 换到其他节点，哈希环不重建
+	for key, owner := range owners {
+		ctx := WithTried(WithHashKey(context.Background(), key))
+		MarkTried(ctx, "127.0.0.1:8000")
+		node := Pick(ctx, b, "Greeter", nodes)
+		if owner.Addr() == "127.0.0.1:8000" {
+			assert.NotEqual(t, owner, node)
+		} else {
+			assert.Equal(t, owner, node)
+		}
+	}
+	current, _ := b.rings.Load("Greeter")
+	assert.True(t, ring == current)
+
+	// 节点的 key 没有变化时复用虚拟节点，不重新计算哈希
+	copied := append([]*Node(nil), nodes...)
+	assert.Equal(t, owners["user-1"], Pick(WithHashKey(context.Background(), "user-1"), b, "Greeter", copied))
+	current, _ = b.rings.Load("Greeter")
+	assert.True(t, &ring.(*hashRing).hashes[0] == &current.(*hashRing).hashes[0])
+
+	ctx := WithFilter(context.Background(), &notReady{addr: "127.0.0.1:8001"})
+	for i := 0; i < 100; i++ {
+		assert.NotEqual(t, "127.0.0.1:8001", Pick(ctx, b, "Greeter", nodes).Addr())
+	}
+	ctx = WithFilter(context.Background(), &notReady{})
+	assert.Nil(t, Pick(ctx, b, "Greeter", nodes))
+}
+
+// notReady 拒绝 addr，addr 为空时拒绝所有节点
+type notReady struct {
+	addr string
+}
+
+func (f *notReady) Ready(serviceName string, addr string) bool { return f.addr != "" && addr != f.addr }
+func (f *notReady) Admit(serviceName string, addr string) bool { return true }
+
+func TestPeer(t *testing.T) {
+	SetPeer(context.Background(), "127.0.0.1:8000")
+
+	ctx, peer := WithPeer(context.Background())
+	assert.Equal(t, "", peer.Addr())
+	SetPeer(ctx, "127.0.0.1:8000")
+	assert.Equal(t, "127.0.0.1:8000", peer.Addr())
+}
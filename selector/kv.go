@@ -1,8 +1,59 @@
 package selector
 
+import "strings"
+
+// DefaultWeight is used by the weighted balancers when a node does not publish its weight
+const DefaultWeight = 100
+
+// WeightOf returns a pointer to weight, e.g. to publish weight 0 that drains a node
+func WeightOf(weight int) *int {
+	return &weight
+}
+
 // Node defines the basic information for a service Node
 type Node struct {
-	Key    string
-	Value  []byte
-	weight int
+	Key        string
+	Value      []byte
+	Address    string            // 节点地址，例如 127.0.0.1:8000
+	Weight     *int              // 节点权重，nil 时按 DefaultWeight 处理，0 表示不分配流量，例如下线灰度节点
+	Tags       []string          // 节点标签，例如 canary
+	Attributes map[string]string // 节点属性，例如 zone、version
+}
+
+// Addr returns the address of the node, the last segment of Key is used when Address is not set
+func (n *Node) Addr() string {
+	if n.Address != "" {
+		return n.Address
+	}
+	return n.Key[strings.LastIndex(n.Key, "/")+1:]
+}
+
+// EffectiveWeight returns the weight of the node, DefaultWeight if it does not publish one
+// and 0 if it takes no traffic
+func (n *Node) EffectiveWeight() int {
+	if n.Weight == nil {
+		return DefaultWeight
+	}
+	if *n.Weight < 0 {
+		return 0
+	}
+	return *n.Weight
+}
+
+// withTraffic 去掉权重为 0 的节点，没有这样的节点时返回原来的切片
+func withTraffic(nodes []*Node) []*Node {
+	for i, node := range nodes {
+		if node.EffectiveWeight() > 0 {
+			continue
+		}
+
+		filtered := append(make([]*Node, 0, len(nodes)-1), nodes[:i]...)
+		for _, node := range nodes[i+1:] {
+			if node.EffectiveWeight() > 0 {
+				filtered = append(filtered, node)
+			}
+		}
+		return filtered
+	}
+	return nodes
 }
@@ -0,0 +1,46 @@
+package selector
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// leastLoadedBalancer 选择正在进行的请求数最少的节点，请求数相同时随机选择
+type leastLoadedBalancer struct {
+	*loadTracker
+}
+
+func newLeastLoadedBalancer() *leastLoadedBalancer {
+	return &leastLoadedBalancer{
+		loadTracker: newLoadTracker(),
+	}
+}
+
+func (l *leastLoadedBalancer) Balance(serviceName string, nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	var picked *Node
+	var min int64
+	ties := 0
+	for _, node := range nodes {
+		// 权重为 0 的节点不分配流量
+		if node.EffectiveWeight() <= 0 {
+			continue
+		}
+		inflight := atomic.LoadInt64(&l.get(serviceName, node.Addr()).inflight)
+		switch {
+		case picked == nil || inflight < min:
+			picked, min, ties = node, inflight, 1
+		case inflight == min:
+			// 蓄水池抽样，在负载相同的节点中均匀选择
+			ties++
+			if rand.Intn(ties) == 0 {
+				picked = node
+			}
+		}
+	}
+
+	return picked
+}
@@ -0,0 +1,55 @@
+package selector
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// p2cBalancer 随机选出两个节点，把请求发给负载较低的一个
+// 负载 = peak EWMA 延迟 * (正在进行的请求数 + 1)，慢节点和拥堵的节点都会少分到流量
+type p2cBalancer struct {
+	*loadTracker
+}
+
+func newP2CBalancer() *p2cBalancer {
+	return &p2cBalancer{
+		loadTracker: newLoadTracker(),
+	}
+}
+
+func (p *p2cBalancer) Balance(serviceName string, nodes []*Node) *Node {
+	// 权重为 0 的节点不分配流量
+	nodes = withTraffic(nodes)
+
+	switch len(nodes) {
+	case 0:
+		return nil
+	case 1:
+		return nodes[0]
+	}
+
+	i := rand.Intn(len(nodes))
+	j := rand.Intn(len(nodes) - 1)
+	if j >= i {
+		j++
+	}
+
+	now := time.Now()
+	if p.cost(serviceName, nodes[j], now) < p.cost(serviceName, nodes[i], now) {
+		return nodes[j]
+	}
+	return nodes[i]
+}
+
+func (p *p2cBalancer) cost(serviceName string, node *Node, now time.Time) float64 {
+	s := p.get(serviceName, node.Addr())
+	inflight := atomic.LoadInt64(&s.inflight)
+	latency := s.latency(now)
+
+	// 还没有延迟数据的新节点：空闲时优先，已有请求在进行时按失败的延迟估算，避免突发流量全部压到新节点
+	if latency == 0 {
+		return float64(errorPenalty) * float64(inflight)
+	}
+	return latency * float64(inflight+1)
+}
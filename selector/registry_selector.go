@@ -0,0 +1,95 @@
+package selector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// RegistrySelector selects nodes of a Registry with a balancer, discovery plugins embed it to implement
+// Selector, ContextSelector, Feedback and NodesObserver. The nodes are cached by a Resolver that starts
+// watching a service the first time it is selected.
+type RegistrySelector struct {
+	registry     Registry
+	balancerName func() string // 每次选择时读取，Register 之后设置的 BalancerName 同样生效
+
+	resolver     *Resolver
+	resolverOnce sync.Once
+}
+
+// NewRegistrySelector creates a RegistrySelector, balancerName returns the name of the balancer to use
+func NewRegistrySelector(registry Registry, balancerName func() string) *RegistrySelector {
+	return &RegistrySelector{
+		registry:     registry,
+		balancerName: balancerName,
+	}
+}
+
+func (s *RegistrySelector) initResolver() {
+	s.resolver = NewResolver(s.registry)
+	s.resolver.Subscribe(s)
+}
+
+// Nodes returns the cached nodes of the service, the returned slice must not be modified
+func (s *RegistrySelector) Nodes(serviceName string) ([]*Node, error) {
+	s.resolverOnce.Do(s.initResolver)
+	return s.resolver.Resolve(serviceName)
+}
+
+// Close stops watching the registry
+func (s *RegistrySelector) Close() {
+	s.resolverOnce.Do(s.initResolver)
+	s.resolver.Close()
+}
+
+// UpdateNodes implements NodesObserver, changes of the nodes are passed on to the balancer
+func (s *RegistrySelector) UpdateNodes(serviceName string, nodes []*Node) {
+	if o, ok := s.balancer().(NodesObserver); ok {
+		o.UpdateNodes(serviceName, nodes)
+	}
+}
+
+// implements selector Select method
+func (s *RegistrySelector) Select(serviceName string) (string, error) {
+	return s.SelectContext(context.Background(), serviceName)
+}
+
+// SelectContext implements ContextSelector, the context of the call is passed on to the balancer
+func (s *RegistrySelector) SelectContext(ctx context.Context, serviceName string) (string, error) {
+
+	nodes, err := s.Nodes(serviceName)
+	if err != nil {
+		return "", err
+	}
+
+	// 跳过熔断的节点，重试的请求发给其他节点
+	node := Pick(ctx, s.balancer(), serviceName, nodes)
+
+	if node == nil {
+		return "", fmt.Errorf("no services find in %s", serviceName)
+	}
+
+	if addr := node.Addr(); addr != "" {
+		return addr, nil
+	}
+	return "", errors.New("addr is empty")
+}
+
+// Start implements Feedback, calls are reported to the balancer
+func (s *RegistrySelector) Start(serviceName string, addr string) {
+	if f, ok := s.balancer().(Feedback); ok {
+		f.Start(serviceName, addr)
+	}
+}
+
+// Done implements Feedback, calls are reported to the balancer
+func (s *RegistrySelector) Done(serviceName string, addr string, info DoneInfo) {
+	if f, ok := s.balancer().(Feedback); ok {
+		f.Done(serviceName, addr, info)
+	}
+}
+
+func (s *RegistrySelector) balancer() Balancer {
+	return GetBalancer(s.balancerName())
+}
@@ -0,0 +1,74 @@
+package selector
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordBalancer 总是选择最后一个节点，记录收到的节点变化和调用结果
+type recordBalancer struct {
+	updates chan []*Node
+	done    []string
+}
+
+func (b *recordBalancer) Balance(serviceName string, nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[len(nodes)-1]
+}
+
+func (b *recordBalancer) UpdateNodes(serviceName string, nodes []*Node) {
+	b.updates <- nodes
+}
+
+func (b *recordBalancer) Start(serviceName string, addr string) {}
+
+func (b *recordBalancer) Done(serviceName string, addr string, info DoneInfo) {
+	b.done = append(b.done, addr)
+}
+
+func TestRegistrySelector(t *testing.T) {
+	b := &recordBalancer{updates: make(chan []*Node, 4)}
+	RegisterBalancer("record", b)
+
+	registry := newFakeRegistry(&Node{Key: "a", Address: "127.0.0.1:8000"})
+	var name atomic.Value
+	name.Store(RoundRobin)
+	s := NewRegistrySelector(registry, func() string { return name.Load().(string) })
+	defer s.Close()
+
+	addr, err := s.Select("Greeter")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:8000", addr)
+
+	// 每次选择时读取负载均衡算法，节点变化和调用结果交给它
+	name.Store("record")
+	registry.update(nil, &Node{Key: "a", Address: "127.0.0.1:8000"}, &Node{Key: "b", Address: "127.0.0.1:8001"})
+	select {
+	case nodes := <-b.updates:
+		assert.Len(t, nodes, 2)
+	case <-time.After(time.Second):
+		t.Fatal("balancer not notified of the new nodes")
+	}
+
+	addr, err = s.SelectContext(context.Background(), "Greeter")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:8001", addr)
+	Track(s, "Greeter", addr)(nil)
+	assert.Equal(t, []string{"127.0.0.1:8001"}, b.done)
+
+	registry.update(nil)
+	for i := 0; i < 100; i++ {
+		if nodes, _ := s.Nodes("Greeter"); len(nodes) == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, err = s.Select("Greeter")
+	assert.NotNil(t, err)
+}
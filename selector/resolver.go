@@ -0,0 +1,185 @@
+package selector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Registry is where the nodes of services are registered, e.g. consul
+type Registry interface {
+	// Watch returns the nodes of the service and their version. It blocks until the nodes differ from
+	// version or ctx ends, version 0 returns the current nodes right away.
+	Watch(ctx context.Context, serviceName string, version uint64) ([]*Node, uint64, error)
+}
+
+// NodesObserver is implemented by balancers that keep state per node, the Resolver tells them when the
+// nodes of a service change, e.g. to drop the state of removed nodes
+type NodesObserver interface {
+	UpdateNodes(serviceName string, nodes []*Node)
+}
+
+const (
+	// resolveTimeout 第一次解析一个服务时等待注册中心返回的最长时间
+	resolveTimeout = 3 * time.Second
+	// watchMinInterval 节点没有变化时两次 Watch 的最小间隔，避免不支持阻塞查询的注册中心被频繁查询
+	watchMinInterval = time.Second
+	// watchMaxBackoff 注册中心不可用时重试的最大间隔
+	watchMaxBackoff = 30 * time.Second
+)
+
+// Resolver keeps a local cache of the nodes of every service it resolves, a watch per service keeps the
+// cache up to date, so calls do not query the registry. When the registry is unreachable the last nodes it
+// returned keep being used.
+type Resolver struct {
+	registry  Registry
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	services  map[string]*serviceNodes
+	observers []NodesObserver
+}
+
+// serviceNodes 一个服务的节点缓存
+type serviceNodes struct {
+	ready   chan struct{} // 第一次 Watch 返回后关闭
+	mu      sync.RWMutex
+	nodes   []*Node
+	version uint64
+	err     error // 还没有拿到节点时最近一次 Watch 的错误
+}
+
+// NewResolver creates a Resolver that watches registry, Close stops all watches
+func NewResolver(registry Registry) *Resolver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Resolver{
+		registry: registry,
+		ctx:      ctx,
+		cancel:   cancel,
+		services: make(map[string]*serviceNodes),
+	}
+}
+
+// Subscribe tells o about every change of the nodes of the services the Resolver watches
+func (r *Resolver) Subscribe(o NodesObserver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observers = append(r.observers, o)
+}
+
+// Resolve returns the cached nodes of the service. The first call of a service starts watching it and waits
+// for the registry, the returned slice must not be modified.
+func (r *Resolver) Resolve(serviceName string) ([]*Node, error) {
+	r.mu.Lock()
+	s, ok := r.services[serviceName]
+	if !ok {
+		s = &serviceNodes{ready: make(chan struct{})}
+		r.services[serviceName] = s
+		go r.watch(serviceName, s)
+	}
+	r.mu.Unlock()
+
+	// 只有第一次 Watch 还没有返回时才需要等待
+	select {
+	case <-s.ready:
+	default:
+		timer := time.NewTimer(resolveTimeout)
+		defer timer.Stop()
+		select {
+		case <-s.ready:
+		case <-timer.C:
+			return nil, context.DeadlineExceeded
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.nodes == nil {
+		return nil, s.err
+	}
+	return s.nodes, nil
+}
+
+// Close stops watching the registry
+func (r *Resolver) Close() {
+	r.cancel()
+}
+
+func (r *Resolver) watch(serviceName string, s *serviceNodes) {
+	var once sync.Once
+	ready := func() {
+		once.Do(func() {
+			close(s.ready)
+		})
+	}
+
+	backoff := watchMinInterval
+	for {
+		start := time.Now()
+		nodes, version, err := r.registry.Watch(r.ctx, serviceName, s.version)
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			// 注册中心不可用时继续使用之前的节点
+			zap.L().Warn("watch service nodes failed", zap.String("service", serviceName), zap.Error(err))
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			ready()
+
+			if !r.sleep(backoff) {
+				return
+			}
+			if backoff *= 2; backoff > watchMaxBackoff {
+				backoff = watchMaxBackoff
+			}
+			continue
+		}
+		backoff = watchMinInterval
+
+		s.mu.Lock()
+		changed := s.nodes == nil || version != s.version
+		if changed {
+			if nodes == nil {
+				nodes = []*Node{}
+			}
+			s.nodes, s.version, s.err = nodes, version, nil
+		}
+		s.mu.Unlock()
+		ready()
+
+		if !changed {
+			if !r.sleep(watchMinInterval - time.Since(start)) {
+				return
+			}
+			continue
+		}
+
+		r.mu.Lock()
+		observers := r.observers
+		r.mu.Unlock()
+		for _, o := range observers {
+			o.UpdateNodes(serviceName, nodes)
+		}
+	}
+}
+
+// sleep returns false if the Resolver is closed while sleeping
+func (r *Resolver) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return r.ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
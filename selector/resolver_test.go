@@ -0,0 +1,141 @@
+package selector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry 每次修改节点时版本加一，Watch 阻塞到版本变化
+type fakeRegistry struct {
+	mu      sync.Mutex
+	nodes   []*Node
+	version uint64
+	err     error
+	changed chan struct{}
+	calls   int
+}
+
+func newFakeRegistry(nodes ...*Node) *fakeRegistry {
+	return &fakeRegistry{nodes: nodes, version: 1, changed: make(chan struct{})}
+}
+
+func (f *fakeRegistry) Watch(ctx context.Context, serviceName string, version uint64) ([]*Node, uint64, error) {
+	f.mu.Lock()
+	f.calls++
+	for f.err == nil && f.version == version {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+		f.mu.Lock()
+	}
+	defer f.mu.Unlock()
+	return f.nodes, f.version, f.err
+}
+
+func (f *fakeRegistry) update(err error, nodes ...*Node) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		f.nodes = nodes
+		f.version++
+	}
+	f.err = err
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fakeObserver struct {
+	updates chan []*Node
+}
+
+func (o *fakeObserver) UpdateNodes(serviceName string, nodes []*Node) {
+	o.updates <- nodes
+}
+
+func TestResolver(t *testing.T) {
+	a := &Node{Key: "Greeter/127.0.0.1:8000"}
+	b := &Node{Key: "Greeter/127.0.0.1:8001"}
+	registry := newFakeRegistry(a)
+	r := NewResolver(registry)
+	defer r.Close()
+	o := &fakeObserver{updates: make(chan []*Node, 10)}
+	r.Subscribe(o)
+
+	nodes, err := r.Resolve("Greeter")
+	assert.Nil(t, err)
+	assert.Equal(t, []*Node{a}, nodes)
+	assert.Equal(t, []*Node{a}, <-o.updates)
+
+	// 之后的调用使用缓存，不查询注册中心
+	for i := 0; i < 10; i++ {
+		r.Resolve("Greeter")
+	}
+	time.Sleep(10 * time.Millisecond)
+	registry.mu.Lock()
+	assert.Equal(t, 2, registry.calls)
+	registry.mu.Unlock()
+	assert.Zero(t, testing.AllocsPerRun(10, func() {
+		r.Resolve("Greeter")
+	}))
+
+	// 节点变化时更新缓存并通知 Balancer
+	registry.update(nil, a, b)
+	assert.Equal(t, []*Node{a, b}, <-o.updates)
+	nodes, _ = r.Resolve("Greeter")
+	assert.Equal(t, []*Node{a, b}, nodes)
+
+	// 注册中心不可用时继续使用之前的节点
+	registry.update(errors.New("connection refused"))
+	time.Sleep(10 * time.Millisecond)
+	nodes, err = r.Resolve("Greeter")
+	assert.Nil(t, err)
+	assert.Equal(t, []*Node{a, b}, nodes)
+
+	// 恢复后继续 watch
+	registry.update(nil, b)
+	select {
+	case nodes = <-o.updates:
+		assert.Equal(t, []*Node{b}, nodes)
+	case <-time.After(3 * watchMinInterval):
+		t.Fatal("no update after the registry recovers")
+	}
+}
+
+func TestResolverError(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.err = errors.New("connection refused")
+	r := NewResolver(registry)
+
+	// 还没有拿到节点时返回注册中心的错误
+	_, err := r.Resolve("Greeter")
+	assert.Equal(t, registry.err, err)
+
+	r.Close()
+}
+
+func TestUpdateNodes(t *testing.T) {
+	b := newLeastLoadedBalancer()
+	b.Start("Greeter", "127.0.0.1:8000")
+	b.Start("Greeter", "127.0.0.1:8001")
+	b.Done("Greeter", "127.0.0.1:8001", DoneInfo{})
+	b.Start("Echo", "127.0.0.1:8002")
+	b.Done("Echo", "127.0.0.1:8002", DoneInfo{})
+
+	// 删除已经下线的节点，还有请求在进行的节点暂时保留
+	b.UpdateNodes("Greeter", nil)
+	_, ok := b.stats.Load("Greeter/127.0.0.1:8000")
+	assert.True(t, ok)
+	_, ok = b.stats.Load("Greeter/127.0.0.1:8001")
+	assert.False(t, ok)
+	_, ok = b.stats.Load("Echo/127.0.0.1:8002")
+	assert.True(t, ok)
+}
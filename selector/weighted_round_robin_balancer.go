@@ -1,6 +1,7 @@
 package selector
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -24,72 +25,100 @@ type weightedNode struct {
 	currentWeight   int
 }
 
+// wRoundRobinPicker 被同一个服务的所有调用并发使用，mu 保护节点和当前权重
 type wRoundRobinPicker struct {
+	mu             sync.Mutex
 	nodes          []*weightedNode // service nodes
 	lastUpdateTime time.Time       // last update time
 	duration       time.Duration   // time duration to update again
 }
 
-func (wr *wRoundRobinPicker) pick(nodes []*Node) *Node {
+// pick 平滑加权轮询，不可用的节点不参与这一轮，当前权重保持不变
+func (wr *wRoundRobinPicker) pick(nodes []*Node, ready func(*Node) bool) *Node {
 	if len(nodes) == 0 {
 		return nil
 	}
 
-	// update picker after timeout
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	// update picker after timeout, or when nodes or weights change
 	if time.Now().Sub(wr.lastUpdateTime) > wr.duration ||
-		len(nodes) != len(wr.nodes) {
+		wr.changed(nodes) {
 		wr.nodes = getWeightedNode(nodes)
 		wr.lastUpdateTime = time.Now()
 	}
 
 	totalWeight := 0
-	maxWeight := 0
-	index := 0
-	for i, node := range wr.nodes {
+	var selected *weightedNode
+	for _, node := range wr.nodes {
+		// 权重为 0 的节点不分配流量
+		if node.weight <= 0 || !ready(node.node) {
+			continue
+		}
 		node.currentWeight += node.weight
 		totalWeight += node.weight
-		if node.currentWeight > maxWeight {
-			maxWeight = node.currentWeight
-			index = i
+		if selected == nil || node.currentWeight > selected.currentWeight {
+			selected = node
 		}
 	}
+	if selected == nil {
+		return nil
+	}
 
-	wr.nodes[index].currentWeight -= totalWeight
-
-	return wr.nodes[index].node
+	selected.currentWeight -= totalWeight
 
+	return selected.node
 }
 
 func (w *weightedRoundRobinBalancer) Balance(serviceName string, nodes []*Node) *Node {
-	var picker *wRoundRobinPicker
+	return w.BalanceFilter(context.Background(), serviceName, nodes, allReady)
+}
 
-	if p, ok := w.pickers.Load(serviceName); !ok {
-		picker = &wRoundRobinPicker{
+// BalanceFilter implements FilterBalancer, the picker is built from all the nodes of the service
+func (w *weightedRoundRobinBalancer) BalanceFilter(ctx context.Context, serviceName string, nodes []*Node, ready func(*Node) bool) *Node {
+	p, ok := w.pickers.Load(serviceName)
+	if !ok {
+		p, _ = w.pickers.LoadOrStore(serviceName, &wRoundRobinPicker{
 			lastUpdateTime: time.Now(),
 			duration:       w.duration,
 			nodes:          getWeightedNode(nodes),
-		}
-		w.pickers.Store(serviceName, picker)
-	} else {
-		picker = p.(*wRoundRobinPicker)
+		})
 	}
 
-	node := picker.pick(nodes)
-	w.pickers.Store(serviceName, picker)
-	return node
+	return p.(*wRoundRobinPicker).pick(nodes, ready)
+}
+
+// changed 节点列表或者节点权重发生变化，例如灰度发布时调整了权重
+func (wr *wRoundRobinPicker) changed(nodes []*Node) bool {
+	if len(nodes) != len(wr.nodes) {
+		return true
+	}
+	for i, node := range nodes {
+		if node.Key != wr.nodes[i].node.Key || node.EffectiveWeight() != wr.nodes[i].weight {
+			return true
+		}
+	}
+	return false
 }
 
 func getWeightedNode(nodes []*Node) []*weightedNode {
 
 	var wgs []*weightedNode
 	for _, node := range nodes {
+		weight := node.EffectiveWeight()
 		wgs = append(wgs, &weightedNode{
 			node:            node,
-			weight:          node.weight,
-			currentWeight:   node.weight,
-			effectiveWeight: node.weight,
+			weight:          weight,
+			currentWeight:   weight,
+			effectiveWeight: weight,
 		})
 	}
 
 	return wgs
 }
+
+// UpdateNodes implements NodesObserver, the picker of the service is rebuilt by the next call
+func (w *weightedRoundRobinBalancer) UpdateNodes(serviceName string, nodes []*Node) {
+	w.pickers.Delete(serviceName)
+}
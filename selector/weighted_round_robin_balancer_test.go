@@ -0,0 +1,106 @@
+package selector
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightedRoundRobinBalance(t *testing.T) {
+	b := newWeightedRoundRobinBalancer()
+
+	// 没有发布权重的节点按 DefaultWeight 平均分配
+	nodes := hashNodes(3)
+	picked := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		picked[b.Balance("Greeter", nodes).Addr()]++
+	}
+	assert.Equal(t, map[string]int{"127.0.0.1:8000": 100, "127.0.0.1:8001": 100, "127.0.0.1:8002": 100}, picked)
+
+	// 灰度节点只分到 5% 的流量，调整权重后立即生效
+	nodes = hashNodes(2)
+	nodes[0].Weight = WeightOf(95)
+	nodes[1].Weight = WeightOf(5)
+	nodes[1].Tags = []string{"canary"}
+	picked = make(map[string]int)
+	for i := 0; i < 100; i++ {
+		picked[b.Balance("Greeter", nodes).Addr()]++
+	}
+	assert.Equal(t, map[string]int{"127.0.0.1:8000": 95, "127.0.0.1:8001": 5}, picked)
+
+	// 权重设置为 0 后灰度节点不再分到流量
+	nodes[1].Weight = WeightOf(0)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "127.0.0.1:8000", b.Balance("Greeter", nodes).Addr())
+	}
+	nodes[0].Weight = WeightOf(0)
+	assert.Nil(t, b.Balance("Greeter", nodes))
+}
+
+func TestNodeAddr(t *testing.T) {
+	node := &Node{Key: "Greeter/127.0.0.1:8000"}
+	assert.Equal(t, "127.0.0.1:8000", node.Addr())
+	assert.Equal(t, DefaultWeight, node.EffectiveWeight())
+
+	node = &Node{Key: "Greeter/node-1", Address: "10.0.0.1:8000", Weight: WeightOf(5)}
+	assert.Equal(t, "10.0.0.1:8000", node.Addr())
+	assert.Equal(t, 5, node.EffectiveWeight())
+
+	node.Weight = WeightOf(0)
+	assert.Equal(t, 0, node.EffectiveWeight())
+}
+
+func TestWeightedRoundRobinPick(t *testing.T) {
+	b := newWeightedRoundRobinBalancer()
+	nodes := hashNodes(3)
+	nodes[0].Weight = WeightOf(3)
+	nodes[1].Weight = WeightOf(2)
+	nodes[2].Weight = WeightOf(1)
+
+	// 重试跳过一个节点时不重置其他节点的当前权重，整体比例不变
+	Pick(context.Background(), b, "Greeter", nodes)
+	p, _ := b.pickers.Load("Greeter")
+	weighted := p.(*wRoundRobinPicker).nodes
+
+	picked := make(map[string]int)
+	for i := 0; i < 600; i++ {
+		ctx := context.Background()
+		if i%6 == 0 {
+			ctx = WithTried(ctx)
+			MarkTried(ctx, "127.0.0.1:8002")
+		}
+		picked[Pick(ctx, b, "Greeter", nodes).Addr()]++
+	}
+	p, _ = b.pickers.Load("Greeter")
+	assert.True(t, weighted[0] == p.(*wRoundRobinPicker).nodes[0])
+	assert.InDelta(t, 300, picked["127.0.0.1:8000"], 20)
+	assert.InDelta(t, 200, picked["127.0.0.1:8001"], 20)
+	assert.InDelta(t, 100, picked["127.0.0.1:8002"], 20)
+}
+
+func TestWeightedRoundRobinConcurrent(t *testing.T) {
+	b := newWeightedRoundRobinBalancer()
+	nodes := hashNodes(3)
+
+	var mu sync.Mutex
+	picked := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 30; j++ {
+				addr := b.Balance("Greeter", nodes).Addr()
+				mu.Lock()
+				picked[addr]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 并发调用时仍然严格按权重分配
+	assert.Equal(t, map[string]int{"127.0.0.1:8000": 100, "127.0.0.1:8001": 100, "127.0.0.1:8002": 100}, picked)
+}
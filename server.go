@@ -3,30 +3,48 @@ package novarpc
 import (
 	"context"
 	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/health"
 	"github.com/xing-you-ji/novarpc/interceptor"
 	"github.com/xing-you-ji/novarpc/log"
+	"github.com/xing-you-ji/novarpc/metadata"
 	"github.com/xing-you-ji/novarpc/plugin"
 	"github.com/xing-you-ji/novarpc/plugin/jaeger"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/stream"
+	"github.com/xing-you-ji/novarpc/transport"
+	"github.com/xing-you-ji/novarpc/utils"
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
 	"reflect"
+	"sort"
+	"strings"
 	"syscall"
 )
 
 // Server
 type Server struct {
-	opts    *ServerOptions  // 服务参数选项
-	service Service         // 一个 Server 可以有一个或多个 Service（暂时只弄一个）
-	plugins []plugin.Plugin // 插件
-	closing bool            // 服务是否正在关闭
+	opts      *ServerOptions            // 服务参数选项
+	services  map[string]Service        // 一个 Server 可以有一个或多个 Service，按服务名路由
+	plugins   []plugin.Plugin           // 插件
+	ctx       context.Context           // 上下文
+	cancel    context.CancelFunc        // 上下文控制器（取消函数）
+	transport transport.ServerTransport // 所有 Service 共用的 transport
+	limiter   *serverLimiter            // 限流和并发限制，nil 表示不限制
+	health    *health.Server            // 健康检查服务，每个 Server 都自动注册
 }
 
 // NewServer creates a Server, Support to pass in ServerOption parameters
 func NewServer(opt ...ServerOption) *Server {
 	log.Init()
 	s := &Server{
-		opts: &ServerOptions{},
+		opts: &ServerOptions{
+			compressThreshold: codec.DefaultCompressThreshold,
+		},
+		services: make(map[string]Service),
 	}
 
 	// 遍历 ServerOption 参数
@@ -34,8 +52,17 @@ func NewServer(opt ...ServerOption) *Server {
 		o(s.opts)
 	}
 
-	// 根据参数 创建一个 Service
-	s.service = NewService(s.opts)
+	s.limiter = newServerLimiter(s.opts)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.transport = transport.GetServerTransport(s.opts.protocol)
+	if c, ok := s.transport.(transport.ServerTransportCloner); ok {
+		s.transport = c.Clone()
+	}
+
+	s.health = health.NewServer()
+	if err := s.RegisterService(health.ServiceName, &healthService{s.health}); err != nil {
+		zap.L().Error("register health service error", zap.Error(err))
+	}
 
 	for pluginName, pluginVal := range plugin.PluginMap {
 		if !containPlugin(pluginName, s.opts.pluginNames) {
@@ -47,10 +74,22 @@ func NewServer(opt ...ServerOption) *Server {
 	return s
 }
 
-func NewService(opts *ServerOptions) Service {
-	return &service{
-		opts: opts,
-	}
+// Health returns the health service of the server, e.g. to report a service as NOT_SERVING
+func (s *Server) Health() *health.Server {
+	return s.health
+}
+
+// healthService 只把 Check 和 Watch 注册为方法
+type healthService struct {
+	hs *health.Server
+}
+
+func (h *healthService) Check(ctx context.Context, req *health.HealthCheckRequest) (*health.HealthCheckResponse, error) {
+	return h.hs.Check(ctx, req)
+}
+
+func (h *healthService) Watch(ss *stream.ServerStream) error {
+	return h.hs.Watch(ss)
 }
 
 func containPlugin(pluginName string, plugins []string) bool {
@@ -70,6 +109,11 @@ func (s *Server) RegisterService(serviceName string, svr interface{}) error {
 	svrType := reflect.TypeOf(svr)
 	svrValue := reflect.ValueOf(svr)
 
+	// 同一个 Server 中服务名不能重复
+	if _, ok := s.services[strings.TrimPrefix(serviceName, "/")]; ok {
+		return fmt.Errorf("service %s is already registered", serviceName)
+	}
+
 	// 将 svr 封装成 ServiceDesc
 	sd := &ServiceDesc{
 		ServiceName: serviceName,
@@ -79,13 +123,14 @@ func (s *Server) RegisterService(serviceName string, svr interface{}) error {
 	}
 
 	// 通过反射获取 svr 的所有方法
-	methods, err := getServiceMethods(svrType, svrValue)
+	methods, streams, err := getServiceMethods(svrType, svrValue)
 	if err != nil {
 		return err
 	}
 
 	// 记录方法
 	sd.Methods = methods
+	sd.Streams = streams
 
 	// 注册
 	s.Register(sd, svr)
@@ -93,17 +138,32 @@ func (s *Server) RegisterService(serviceName string, svr interface{}) error {
 	return nil
 }
 
-func getServiceMethods(serviceType reflect.Type, serviceValue reflect.Value) ([]*MethodDesc, error) {
+func getServiceMethods(serviceType reflect.Type, serviceValue reflect.Value) ([]*MethodDesc, []*StreamDesc, error) {
 
 	var methods []*MethodDesc
+	var streams []*StreamDesc
 
 	// 检查类型 的方法个数
 	for i := 0; i < serviceType.NumMethod(); i++ {
 		method := serviceType.Method(i)
 
+		// 流式方法：func(*stream.ServerStream) error
+		if isStreamMethod(method.Type) {
+			streamHandler := func(svr interface{}, ss *stream.ServerStream) error {
+				values := method.Func.Call([]reflect.Value{serviceValue, reflect.ValueOf(ss)})
+				return returnedError(values[0])
+			}
+
+			streams = append(streams, &StreamDesc{
+				StreamName: method.Name,
+				Handler:    streamHandler,
+			})
+			continue
+		}
+
 		// 检查方法的参数个数
 		if err := checkMethod(method.Type); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		methodHandler := func(ctx context.Context, svr interface{}, dec func(interface{}) error, ceps []interceptor.ServerInterceptor) (interface{}, error) {
@@ -117,19 +177,22 @@ func getServiceMethods(serviceType reflect.Type, serviceValue reflect.Value) ([]
 				return nil, err
 			}
 
-			if len(ceps) == 0 {
-				values := method.Func.Call([]reflect.Value{serviceValue, reflect.ValueOf(ctx), reflect.ValueOf(req)})
-				// determine error
-				return values[0].Interface(), nil
-			}
-
 			handler := func(ctx context.Context, reqbody interface{}) (interface{}, error) {
 
-				values := method.Func.Call([]reflect.Value{serviceValue, reflect.ValueOf(ctx), reflect.ValueOf(req)})
+				values := method.Func.Call([]reflect.Value{serviceValue, reflect.ValueOf(ctx), reflect.ValueOf(reqbody)})
+
+				// determine error
+				if err := returnedError(values[1]); err != nil {
+					return nil, err
+				}
 
 				return values[0].Interface(), nil
 			}
 
+			if len(ceps) == 0 {
+				return handler(ctx, req)
+			}
+
 			return interceptor.ServerIntercept(ctx, req, ceps, handler)
 		}
 
@@ -139,7 +202,31 @@ func getServiceMethods(serviceType reflect.Type, serviceValue reflect.Value) ([]
 		})
 	}
 
-	return methods, nil
+	return methods, streams, nil
+}
+
+// returnedError converts the error returned by a service method, a method may return a concrete error type,
+// e.g. *codes.Error, whose nil value means success
+func returnedError(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	err, _ := v.Interface().(error)
+	return err
+}
+
+func isStreamMethod(method reflect.Type) bool {
+	if method.NumIn() != 2 || method.NumOut() != 1 {
+		return false
+	}
+
+	var streamType = reflect.TypeOf((*stream.ServerStream)(nil))
+	var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+	return method.In(1) == streamType && method.Out(0) == errorType
 }
 
 func checkMethod(method reflect.Type) error {
@@ -196,10 +283,19 @@ func (s *Server) Register(sd *ServiceDesc, svr interface{}) {
 		zap.L().Error("server.Register found the handlerType is not implemented by the svr", zap.String("handlerType", ht.Name()), zap.String("svr", st.Name()))
 	}
 
+	// 服务名不带前导 "/"，与服务路径 /package.Service/Method 中的服务名一致
+	serviceName := strings.TrimPrefix(sd.ServiceName, "/")
+	if _, ok := s.services[serviceName]; ok {
+		zap.L().Error("server.Register found the service is already registered", zap.String("service", serviceName))
+		return
+	}
+
 	service := &service{
-		svr:         svr,
-		serviceName: sd.ServiceName,
-		handlers:    make(map[string]Handler),
+		svr:            svr,
+		serviceName:    serviceName,
+		handlers:       make(map[string]Handler),
+		streamHandlers: make(map[string]StreamHandler),
+		opts:           s.opts,
 	}
 
 	// 记录方法
@@ -207,30 +303,48 @@ func (s *Server) Register(sd *ServiceDesc, svr interface{}) {
 		service.handlers[method.MethodName] = method.Handler
 	}
 
-	s.service = service
+	for _, streamDesc := range sd.Streams {
+		service.streamHandlers[streamDesc.StreamName] = streamDesc.Handler
+	}
+
+	s.services[serviceName] = service
+
+	// 注册后的服务开始提供服务
+	if s.health != nil {
+		s.health.SetServingStatus(serviceName, health.StatusServing)
+	}
 }
 
 // Serve 启动服务
 func (s *Server) Serve() {
-	// tips：可以加上优雅关闭
 	err := s.InitPlugins()
 	if err != nil {
 		panic(err)
 	}
 
 	// 启动服务
-	go s.service.Serve(s.opts)
-	// 等待关闭信号
+	go s.serve()
+	// 等待关闭信号，或者服务被 Shutdown / Close 关闭
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGSEGV)
-	<-quit
-	s.Close()
-	if err = s.DeRegisterPlugin(); err != nil {
-		zap.L().Warn("deregister plugin failed", zap.Error(err))
-	} else {
-		zap.L().Info("deregister plugin success")
+	defer signal.Stop(quit)
+
+	select {
+	case <-quit:
+	case <-s.ctx.Done():
+		return
 	}
 
+	// 收到信号后优雅关闭
+	ctx := context.Background()
+	if s.opts.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.shutdownTimeout)
+		defer cancel()
+	}
+	if err = s.Shutdown(ctx); err != nil {
+		zap.L().Warn("server shutdown before all requests finished", zap.Error(err))
+	}
 }
 
 type emptyService struct{}
@@ -244,9 +358,137 @@ func (s *Server) ServeHttp() {
 	s.Serve()
 }
 
+// serve 所有 Service 共用一个监听，请求由 Server 按服务路径路由
+func (s *Server) serve() {
+
+	transportOpts := []transport.ServerTransportOption{
+		transport.WithServerAddress(s.opts.address),
+		transport.WithServerNetwork(s.opts.network),
+		transport.WithHandler(s),
+		transport.WithServerTimeout(s.opts.timeout),
+		transport.WithSerializationType(s.opts.serializationType),
+		transport.WithProtocol(s.opts.protocol),
+		transport.WithServerTransportAuth(s.opts.transportAuth),
+		transport.WithServerCompressType(s.opts.compressType),
+		transport.WithServerCompressThreshold(s.opts.compressThreshold),
+	}
+
+	if err := s.transport.ListenAndServe(s.ctx, transportOpts...); err != nil {
+		zap.L().Error("server transport listen and serve error", zap.Error(err))
+		return
+	}
+	zap.L().Info("server transport listen and serve success", zap.String("address", s.opts.address))
+	<-s.ctx.Done()
+}
+
+// Handle 解析服务路径，将请求交给对应的 Service 处理
+func (s *Server) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
+	// parse protocol header
+	request := &protocol.Request{}
+	if err := proto.Unmarshal(reqbuf, request); err != nil {
+		return nil, err
+	}
+
+	service, method, err := s.route(request.ServicePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// 超过限流或者并发限制时拒绝请求，排队等待的时间不超过调用方的超时时间
+	if s.limiter != nil {
+		if t, ok := metadata.Timeout(request.Metadata); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, t)
+			defer cancel()
+		}
+		release, err := s.limiter.acquire(ctx, request.ServicePath, request.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	return service.Handle(ctx, method, request)
+}
+
+// HandleStream 解析服务路径，将流式请求交给对应的 Service 处理
+func (s *Server) HandleStream(ctx context.Context, reqbuf []byte, st stream.Transport) error {
+	// parse protocol header
+	request := &protocol.Request{}
+	if err := proto.Unmarshal(reqbuf, request); err != nil {
+		return err
+	}
+
+	service, method, err := s.route(request.ServicePath)
+	if err != nil {
+		return err
+	}
+
+	// 流会长时间占用并发，只检查限流
+	if s.limiter != nil {
+		if err := s.limiter.allow(ctx, request.ServicePath, request.Metadata); err != nil {
+			return err
+		}
+	}
+
+	return service.HandleStream(ctx, method, request, st)
+}
+
+// route 根据服务路径 /package.Service/Method 找到 Service 和方法名
+func (s *Server) route(servicePath string) (Service, string, error) {
+	serviceName, method, err := utils.ParseServicePath(servicePath)
+	if err != nil {
+		return nil, "", codes.New(codes.ClientMsgErrorCode, "method is invalid")
+	}
+
+	service, ok := s.services[serviceName]
+	if !ok {
+		return nil, "", codes.NewFrameworkError(codes.NotFoundErrorCode, fmt.Sprintf("service %s not found", serviceName))
+	}
+
+	return service, method, nil
+}
+
+// Shutdown 优雅关闭：先把健康状态改为 NOT_SERVING 并从服务发现中摘除，再停止接收新连接，通知客户端不再发起新请求，
+// 等待正在处理的请求完成（最长到 ctx 结束），最后关闭所有连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	// 通知正在 Watch 的客户端，同时结束这些流
+	s.health.Shutdown()
+
+	// 先摘除，客户端不会再选到这个节点
+	if err := s.DeRegisterPlugin(); err != nil {
+		zap.L().Warn("deregister plugin failed", zap.Error(err))
+	} else {
+		zap.L().Info("deregister plugin success")
+	}
+
+	var err error
+	if st, ok := s.transport.(transport.GracefulServerTransport); ok {
+		err = st.Shutdown(ctx)
+	}
+
+	s.cancel()
+	zap.L().Info("service closed", zap.String("address", s.opts.address))
+	return err
+}
+
+// GracefulStop 优雅关闭，等待所有正在处理的请求完成
+func (s *Server) GracefulStop() {
+	s.Shutdown(context.Background())
+}
+
+// Close 立即关闭服务，不等待正在处理的请求
 func (s *Server) Close() {
-	s.closing = false
-	s.service.Close()
+	s.health.Shutdown()
+
+	if st, ok := s.transport.(transport.GracefulServerTransport); ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		st.Shutdown(ctx)
+	}
+
+	s.cancel()
+	zap.L().Info("service closed", zap.String("address", s.opts.address))
 }
 
 func (s *Server) InitPlugins() error {
@@ -256,13 +498,28 @@ func (s *Server) InitPlugins() error {
 		switch val := p.(type) {
 
 		case plugin.ResolverPlugin:
+			// 每个 Service 都注册到服务发现
 			var services []string
-			services = append(services, s.service.Name())
+			for serviceName := range s.services {
+				// 健康检查服务在每个节点上都有，不需要服务发现
+				if serviceName == health.ServiceName {
+					continue
+				}
+				services = append(services, serviceName)
+			}
+			sort.Strings(services)
 
 			pluginOpts := []plugin.Option{
 				plugin.WithSelectorSvrAddr(s.opts.selectorSvrAddr),
 				plugin.WithSvrAddr(s.opts.address),
 				plugin.WithServices(services),
+				plugin.WithTags(s.opts.tags),
+				plugin.WithAttributes(s.opts.attributes),
+				plugin.WithTTL(s.opts.ttl),
+				plugin.WithHealthCheck(s.opts.check),
+			}
+			if s.opts.weight != nil {
+				pluginOpts = append(pluginOpts, plugin.WithWeight(*s.opts.weight))
 			}
 			if err := val.Register(pluginOpts...); err != nil {
 				zap.L().Error("resolver init error", zap.Error(err))
@@ -0,0 +1,268 @@
+package novarpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/auth"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/health"
+	"github.com/xing-you-ji/novarpc/metadata"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/testdata"
+)
+
+type echoService struct{}
+
+func (s *echoService) Echo(ctx context.Context, req *testdata.HelloRequest) (*testdata.HelloReply, error) {
+	if req.Msg == "" {
+		return nil, codes.New(1001, "msg is empty")
+	}
+	return &testdata.HelloReply{Msg: req.Msg}, nil
+}
+
+// typedErrorService declares a concrete error type, a nil *codes.Error means success
+type typedErrorService struct{}
+
+func (s *typedErrorService) Echo(ctx context.Context, req *testdata.HelloRequest) (*testdata.HelloReply, *codes.Error) {
+	if req.Msg == "" {
+		return nil, codes.New(1001, "msg is empty")
+	}
+	return &testdata.HelloReply{Msg: req.Msg}, nil
+}
+
+// deadlineService returns how much time the handler has left
+type deadlineService struct{}
+
+func (s *deadlineService) Left(ctx context.Context, req *testdata.HelloRequest) (*testdata.HelloReply, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return &testdata.HelloReply{}, nil
+	}
+	return &testdata.HelloReply{Msg: time.Until(deadline).String()}, nil
+}
+
+func call(t *testing.T, s *Server, path string, req interface{}, rsp interface{}) error {
+	return callWithMetadata(t, s, path, nil, req, rsp)
+}
+
+func callWithMetadata(t *testing.T, s *Server, path string, md map[string][]byte, req interface{}, rsp interface{}) error {
+	return callWithContext(t, s, context.Background(), path, md, req, rsp)
+}
+
+func callWithContext(t *testing.T, s *Server, ctx context.Context, path string, md map[string][]byte, req interface{}, rsp interface{}) error {
+	serialization := codec.GetSerialization("msgpack")
+	payload, err := serialization.Marshal(req)
+	assert.Nil(t, err)
+
+	reqbuf, err := proto.Marshal(&protocol.Request{ServicePath: path, Payload: payload, Metadata: md})
+	assert.Nil(t, err)
+
+	rspbuf, err := s.Handle(ctx, reqbuf)
+	if err != nil {
+		return err
+	}
+	return serialization.Unmarshal(rspbuf, rsp)
+}
+
+func TestServerRoute(t *testing.T) {
+	s := NewServer(WithSerializationType("msgpack"))
+	assert.Nil(t, s.RegisterService("helloworld.Greeter", new(testdata.Service)))
+	assert.Nil(t, s.RegisterService("/echo.Echo", new(echoService)))
+	assert.NotNil(t, s.RegisterService("echo.Echo", new(echoService)))
+
+	rsp := &testdata.HelloReply{}
+	assert.Nil(t, call(t, s, "/helloworld.Greeter/SayHello", &testdata.HelloRequest{Msg: "hello"}, rsp))
+	assert.Equal(t, "world", rsp.Msg)
+
+	rsp = &testdata.HelloReply{}
+	assert.Nil(t, call(t, s, "/echo.Echo/Echo", &testdata.HelloRequest{Msg: "hi"}, rsp))
+	assert.Equal(t, "hi", rsp.Msg)
+
+	// 业务错误原样返回
+	err := call(t, s, "/echo.Echo/Echo", &testdata.HelloRequest{}, rsp)
+	assert.Equal(t, codes.New(1001, "msg is empty"), err)
+
+	// 服务或方法不存在
+	err = call(t, s, "/echo.Echo/SayHello", &testdata.HelloRequest{}, rsp)
+	assert.Equal(t, uint32(codes.NotFoundErrorCode), err.(*codes.Error).Code)
+	err = call(t, s, "/user.Greeter/SayHello", &testdata.HelloRequest{}, rsp)
+	assert.Equal(t, uint32(codes.NotFoundErrorCode), err.(*codes.Error).Code)
+
+	err = call(t, s, "SayHello", &testdata.HelloRequest{}, rsp)
+	assert.Equal(t, uint32(codes.ClientMsgErrorCode), err.(*codes.Error).Code)
+}
+
+func TestServerTypedNilError(t *testing.T) {
+	s := NewServer(WithSerializationType("msgpack"))
+	assert.Nil(t, s.RegisterService("/echo.Echo", new(typedErrorService)))
+
+	rsp := &testdata.HelloReply{}
+	assert.Nil(t, call(t, s, "/echo.Echo/Echo", &testdata.HelloRequest{Msg: "hi"}, rsp))
+	assert.Equal(t, "hi", rsp.Msg)
+
+	err := call(t, s, "/echo.Echo/Echo", &testdata.HelloRequest{}, rsp)
+	assert.Equal(t, codes.New(1001, "msg is empty"), err)
+}
+
+func TestServerDeadline(t *testing.T) {
+	s := NewServer(WithSerializationType("msgpack"), WithTimeout(2*time.Second))
+	assert.Nil(t, s.RegisterService("deadline.Deadline", new(deadlineService)))
+
+	left := func(md map[string][]byte) time.Duration {
+		rsp := &testdata.HelloReply{}
+		assert.Nil(t, callWithMetadata(t, s, "/deadline.Deadline/Left", md, &testdata.HelloRequest{}, rsp))
+		d, err := time.ParseDuration(rsp.Msg)
+		assert.Nil(t, err)
+		return d
+	}
+
+	// 调用方没有超时时间，使用服务端超时
+	d := left(nil)
+	assert.True(t, d > time.Second && d <= 2*time.Second)
+
+	// 调用方剩余的时间更短
+	md := map[string][]byte{}
+	metadata.SetTimeout(md, 100*time.Millisecond)
+	d = left(md)
+	assert.True(t, d > 0 && d <= 100*time.Millisecond)
+
+	// 服务端超时更短
+	metadata.SetTimeout(md, time.Minute)
+	d = left(md)
+	assert.True(t, d > time.Second && d <= 2*time.Second)
+}
+
+type blockService struct {
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (s *blockService) Wait(ctx context.Context, req *testdata.HelloRequest) (*testdata.HelloReply, error) {
+	s.entered <- struct{}{}
+	<-s.unblock
+	return &testdata.HelloReply{Msg: "done"}, nil
+}
+
+func TestServerRateLimit(t *testing.T) {
+	s := NewServer(WithSerializationType("msgpack"),
+		WithRateLimit("/echo.Echo/Echo", 1000, 1),
+		WithCallerRateLimit("app-id", 1000, 2))
+	assert.Nil(t, s.RegisterService("/echo.Echo", new(echoService)))
+
+	rsp := &testdata.HelloReply{}
+	req := &testdata.HelloRequest{Msg: "hi"}
+	assert.Nil(t, call(t, s, "/echo.Echo/Echo", req, rsp))
+	err := call(t, s, "/echo.Echo/Echo", req, rsp)
+	assert.Equal(t, uint32(codes.ResourceExhaustedErrorCode), codes.Code(err))
+
+	// 按调用方限流
+	time.Sleep(5 * time.Millisecond)
+	s = NewServer(WithSerializationType("msgpack"), WithCallerRateLimit("app-id", 1, 2))
+	assert.Nil(t, s.RegisterService("/echo.Echo", new(echoService)))
+	a := map[string][]byte{"app-id": []byte("a")}
+	assert.Nil(t, callWithMetadata(t, s, "/echo.Echo/Echo", a, req, rsp))
+	assert.Nil(t, callWithMetadata(t, s, "/echo.Echo/Echo", a, req, rsp))
+	err = callWithMetadata(t, s, "/echo.Echo/Echo", a, req, rsp)
+	assert.Equal(t, uint32(codes.ResourceExhaustedErrorCode), codes.Code(err))
+	assert.Nil(t, callWithMetadata(t, s, "/echo.Echo/Echo", map[string][]byte{"app-id": []byte("b")}, req, rsp))
+
+	// 没有调用方标识的请求按对端的 IP 限流
+	peer := func(addr string) context.Context {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		assert.Nil(t, err)
+		return auth.WithPeer(context.Background(), &auth.Peer{Addr: tcpAddr})
+	}
+	assert.Nil(t, callWithContext(t, s, peer("10.0.0.1:5000"), "/echo.Echo/Echo", nil, req, rsp))
+	assert.Nil(t, callWithContext(t, s, peer("10.0.0.1:5001"), "/echo.Echo/Echo", nil, req, rsp))
+	err = callWithContext(t, s, peer("10.0.0.1:5002"), "/echo.Echo/Echo", nil, req, rsp)
+	assert.Equal(t, uint32(codes.ResourceExhaustedErrorCode), codes.Code(err))
+	assert.Nil(t, callWithContext(t, s, peer("10.0.0.2:5000"), "/echo.Echo/Echo", nil, req, rsp))
+}
+
+func TestServerConcurrencyLimit(t *testing.T) {
+	svc := &blockService{entered: make(chan struct{}, 2), unblock: make(chan struct{})}
+	s := NewServer(WithSerializationType("msgpack"), WithMaxConcurrentRequests(1, 1))
+	assert.Nil(t, s.RegisterService("/block.Block", svc))
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- call(t, s, "/block.Block/Wait", &testdata.HelloRequest{}, &testdata.HelloReply{})
+		}()
+	}
+	<-svc.entered
+	time.Sleep(10 * time.Millisecond)
+
+	// 一个请求在处理，一个请求在排队，其余的请求被拒绝
+	err := call(t, s, "/block.Block/Wait", &testdata.HelloRequest{}, &testdata.HelloReply{})
+	assert.Equal(t, uint32(codes.ResourceExhaustedErrorCode), codes.Code(err))
+
+	// 排队的请求等待时间不超过调用方的超时时间
+	md := map[string][]byte{}
+	metadata.SetTimeout(md, 10*time.Millisecond)
+	err = callWithMetadata(t, s, "/block.Block/Wait", md, &testdata.HelloRequest{}, &testdata.HelloReply{})
+	assert.Equal(t, uint32(codes.ResourceExhaustedErrorCode), codes.Code(err))
+
+	close(svc.unblock)
+	assert.Nil(t, <-done)
+	assert.Nil(t, <-done)
+}
+
+func TestServerHealth(t *testing.T) {
+	s := NewServer(WithSerializationType("msgpack"))
+	assert.Nil(t, s.RegisterService("/echo.Echo", new(echoService)))
+
+	check := func(service string) health.ServingStatus {
+		rsp := &health.HealthCheckResponse{}
+		assert.Nil(t, call(t, s, health.CheckPath, &health.HealthCheckRequest{Service: service}, rsp))
+		return rsp.Status
+	}
+	assert.Equal(t, health.StatusServing, check(""))
+	assert.Equal(t, health.StatusServing, check("echo.Echo"))
+	assert.Equal(t, health.StatusServiceUnknown, check("helloworld.Greeter"))
+
+	s.Health().SetServingStatus("echo.Echo", health.StatusNotServing)
+	assert.Equal(t, health.StatusNotServing, check("echo.Echo"))
+
+	// 关闭时所有服务都不再提供服务
+	assert.Nil(t, s.Shutdown(context.Background()))
+	assert.Equal(t, health.StatusNotServing, check(""))
+}
+
+func TestServerShutdownIsolation(t *testing.T) {
+	listen := func() (*Server, string) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		addr := l.Addr().String()
+		l.Close()
+
+		s := NewServer(WithAddress(addr), WithNetwork("tcp"))
+		go s.serve()
+		for i := 0; i < 100; i++ {
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				conn.Close()
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return s, addr
+	}
+	s1, addr1 := listen()
+	s2, addr2 := listen()
+	defer s2.Close()
+	assert.True(t, s1.transport != s2.transport)
+
+	// 关闭一个 Server 不影响同一个进程中的其他 Server
+	assert.Nil(t, s1.Shutdown(context.Background()))
+	_, err := net.Dial("tcp", addr1)
+	assert.NotNil(t, err)
+	conn, err := net.Dial("tcp", addr2)
+	assert.Nil(t, err)
+	conn.Close()
+}
@@ -2,37 +2,30 @@ package novarpc
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"github.com/golang/protobuf/proto"
 	"github.com/xing-you-ji/novarpc/codec"
 	"github.com/xing-you-ji/novarpc/codes"
 	"github.com/xing-you-ji/novarpc/interceptor"
 	"github.com/xing-you-ji/novarpc/metadata"
 	"github.com/xing-you-ji/novarpc/protocol"
-	"github.com/xing-you-ji/novarpc/transport"
-	"github.com/xing-you-ji/novarpc/utils"
-	"go.uber.org/zap"
+	"github.com/xing-you-ji/novarpc/stream"
 )
 
 // Service 定义了一个具体 Service 的通用实现接口
 type Service interface {
-	Register(string, Handler) // 注册方法
-	Serve(*ServerOptions)     // 启动服务
-	Close()                   // 关闭服务
-	Name() string             // 获取服务名称
+	Register(string, Handler)                                                        // 注册方法
+	Handle(context.Context, string, *protocol.Request) ([]byte, error)               // 处理方法调用
+	HandleStream(context.Context, string, *protocol.Request, stream.Transport) error // 处理流式方法调用
+	Name() string                                                                    // 获取服务名称
 }
 
 // service 是 Service 接口的具体实现
 type service struct {
-	svr         interface{}        // server
-	ctx         context.Context    // 上下文
-	cancel      context.CancelFunc // 上下文控制器（取消函数）
-	serviceName string             // 服务名称
-	handlers    map[string]Handler // 方法对应处理函数
-	opts        *ServerOptions     // 参数选项
-
-	closing bool // 服务是否正在关闭
+	svr            interface{}              // server
+	serviceName    string                   // 服务名称
+	handlers       map[string]Handler       // 方法对应处理函数
+	streamHandlers map[string]StreamHandler // 流式方法对应处理函数
+	opts           *ServerOptions           // 参数选项
 }
 
 // ServiceDesc is a detailed description of a service
@@ -40,6 +33,7 @@ type ServiceDesc struct {
 	Svr         interface{}   // server
 	ServiceName string        // 服务名称
 	Methods     []*MethodDesc // 方法描述
+	Streams     []*StreamDesc // 流式方法描述
 	HandlerType interface{}
 }
 
@@ -49,9 +43,18 @@ type MethodDesc struct {
 	Handler    Handler
 }
 
+// StreamDesc 流式方法的描述(包含方法名和方法处理函数)
+type StreamDesc struct {
+	StreamName string
+	Handler    StreamHandler
+}
+
 // Handler is the handler of a method
 type Handler func(context.Context, interface{}, func(interface{}) error, []interceptor.ServerInterceptor) (interface{}, error)
 
+// StreamHandler is the handler of a streaming method, messages are sent and received through the stream
+type StreamHandler func(interface{}, *stream.ServerStream) error
+
 // Register 注册方法
 func (s *service) Register(handlerName string, handler Handler) {
 	if s.handlers == nil {
@@ -60,81 +63,42 @@ func (s *service) Register(handlerName string, handler Handler) {
 	s.handlers[handlerName] = handler
 }
 
-// Serve 启动服务
-func (s *service) Serve(opts *ServerOptions) {
-
-	s.opts = opts
-
-	transportOpts := []transport.ServerTransportOption{
-		transport.WithServerAddress(s.opts.address),
-		transport.WithServerNetwork(s.opts.network),
-		transport.WithHandler(s),
-		transport.WithServerTimeout(s.opts.timeout),
-		transport.WithSerializationType(s.opts.serializationType),
-		transport.WithProtocol(s.opts.protocol),
-	}
-
-	serverTransport := transport.GetServerTransport(s.opts.protocol)
-
-	s.ctx, s.cancel = context.WithCancel(context.Background())
-
-	if err := serverTransport.ListenAndServe(s.ctx, transportOpts...); err != nil {
-		zap.L().Error("server transport listen and serve error", zap.Error(err))
-		return
-	}
-	zap.L().Info("server transport listen and serve success", zap.String("address", s.opts.address))
-	<-s.ctx.Done()
-}
-
-func (s *service) Close() {
-	s.closing = true
-	if s.cancel != nil {
-		s.cancel()
-	}
-	fmt.Println("service closed")
-}
-
 func (s *service) Name() string {
 	return s.serviceName
 }
 
-func (s *service) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
-	// parse protocol header
-	request := &protocol.Request{}
-	if err := proto.Unmarshal(reqbuf, request); err != nil {
-		return nil, err
+// Handle 处理方法调用，method 由 Server 按服务路径解析得到
+func (s *service) Handle(ctx context.Context, method string, request *protocol.Request) ([]byte, error) {
+	// 如果方法不存在，则返回错误
+	handler := s.handlers[method]
+	if handler == nil {
+		return nil, codes.NewFrameworkError(codes.NotFoundErrorCode, fmt.Sprintf("method %s/%s not found", s.serviceName, method))
 	}
+
 	// 创建一个新的上下文（里面包含request的 元数据）
 	ctx = metadata.WithServerMetadata(ctx, request.Metadata)
 	// 请求体反序列化
 	serverSerialization := codec.GetSerialization(s.opts.serializationType)
 	dec := func(req interface{}) error {
 		// 反序列化请求体（请求体默认使用 msgpack进行 序列化 与 反序列化）
-		if err := serverSerialization.Unmarshal(request.Payload, req); err != nil {
+		if err := codec.UnmarshalPayload(serverSerialization, request.Payload, req); err != nil {
 			return err
 		}
 		return nil
 	}
 
-	// 如果设置了超时时间，则使用超时上下文
-	if s.opts.timeout != 0 {
+	// 使用调用方剩余的超时时间和服务端超时时间中较小的一个，
+	// 业务用这个上下文调用下游时，剩余的时间会继续传递下去
+	timeout := s.opts.timeout
+	if t, ok := metadata.Timeout(request.Metadata); ok && (timeout == 0 || t < timeout) {
+		timeout = t
+	}
+	if timeout != 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, s.opts.timeout)
+		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
-	// 解析服务路径
-	_, method, err := utils.ParseServicePath(request.ServicePath)
-	if err != nil {
-		return nil, codes.New(codes.ClientMsgErrorCode, "method is invalid")
-	}
-
-	// 如果方法不存在，则返回错误
-	handler := s.handlers[method]
-	if handler == nil {
-		return nil, errors.New("handlers is nil")
-	}
-
 	// 处理
 	rsp, err := handler(ctx, s.svr, dec, s.opts.interceptors)
 	if err != nil {
@@ -148,3 +112,22 @@ func (s *service) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
 
 	return responseBuf, nil
 }
+
+// HandleStream 处理流式请求
+func (s *service) HandleStream(ctx context.Context, method string, request *protocol.Request, st stream.Transport) error {
+	// 如果方法不存在，则返回错误
+	handler := s.streamHandlers[method]
+	if handler == nil {
+		return codes.NewFrameworkError(codes.NotFoundErrorCode, fmt.Sprintf("stream method %s/%s not found", s.serviceName, method))
+	}
+
+	ctx = metadata.WithServerMetadata(ctx, request.Metadata)
+
+	// 流式请求不使用服务端超时，由客户端取消或连接断开结束
+	_, serverStream := stream.NewStreamingServerStream(ctx)
+	serverStream.WithMethod(method).
+		WithTransport(st).
+		WithSerialization(codec.GetSerialization(s.opts.serializationType))
+
+	return handler(s.svr, serverStream)
+}
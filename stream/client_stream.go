@@ -1,13 +1,20 @@
 package stream
 
-import "context"
+import (
+	"context"
+	"io"
+
+	"github.com/xing-you-ji/novarpc/codec"
+)
 
 const ClientStreamKey = StreamContextKey("GORPC_CLIENT_STREAM")
 
 type ClientStream struct {
-	ctx         context.Context
-	ServiceName string // service name
-	Method      string // method
+	ctx           context.Context
+	ServiceName   string // service name
+	Method        string // method
+	transport     Transport
+	serialization codec.Serialization
 }
 
 func GetClientStream(ctx context.Context) *ClientStream {
@@ -48,3 +55,75 @@ func (cs *ClientStream) WithMethod(method string) {
 func (cs *ClientStream) WithServiceName(serviceName string) {
 	cs.ServiceName = serviceName
 }
+
+// NewStreamingClientStream always creates a new ClientStream for a streaming call
+func NewStreamingClientStream(ctx context.Context) (context.Context, *ClientStream) {
+	cs := &ClientStream{}
+	cs.ctx = context.WithValue(ctx, ClientStreamKey, cs)
+	return cs.ctx, cs
+}
+
+func (cs *ClientStream) WithTransport(transport Transport) {
+	cs.transport = transport
+}
+
+func (cs *ClientStream) WithSerialization(serialization codec.Serialization) {
+	cs.serialization = serialization
+}
+
+// Context returns the context of the stream
+func (cs *ClientStream) Context() context.Context {
+	return cs.ctx
+}
+
+// SendMsg serializes and sends a message to the server
+func (cs *ClientStream) SendMsg(m interface{}) error {
+	if cs.transport == nil {
+		return ErrNotStreaming
+	}
+	data, err := cs.serialization.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return cs.transport.Send(data)
+}
+
+// RecvMsg receives a message from the server, it returns io.EOF when the server has finished successfully
+func (cs *ClientStream) RecvMsg(m interface{}) error {
+	if cs.transport == nil {
+		return ErrNotStreaming
+	}
+	data, err := cs.transport.Recv()
+	if err != nil {
+		return err
+	}
+	return codec.UnmarshalPayload(cs.serialization, data, m)
+}
+
+// CloseSend closes the sending direction of the stream
+func (cs *ClientStream) CloseSend() error {
+	if cs.transport == nil {
+		return ErrNotStreaming
+	}
+	return cs.transport.CloseSend()
+}
+
+// CloseAndRecv closes the sending direction and receives the single reply of a client stream, it also reads
+// the end of the stream so that the final status is returned and the stream is released
+func (cs *ClientStream) CloseAndRecv(m interface{}) error {
+	if err := cs.CloseSend(); err != nil {
+		return err
+	}
+	if err := cs.RecvMsg(m); err != nil {
+		return err
+	}
+
+	_, err := cs.transport.Recv()
+	if err == nil {
+		return ErrUnexpectedReply
+	}
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -1,11 +1,43 @@
 package stream
 
 import (
+	"context"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codec"
 )
 
+// chanTransport is an in-memory Transport for tests
+type chanTransport struct {
+	msgs   chan []byte
+	closed bool
+}
+
+func (t *chanTransport) Send(data []byte) error {
+	t.msgs <- data
+	return nil
+}
+
+func (t *chanTransport) Recv() ([]byte, error) {
+	select {
+	case data := <-t.msgs:
+		return data, nil
+	default:
+		return nil, io.EOF
+	}
+}
+
+func (t *chanTransport) CloseSend() error {
+	t.closed = true
+	return nil
+}
+
+type testMsg struct {
+	Msg string
+}
+
 func TestClientWithMethod(t *testing.T) {
 	var cs ClientStream
 	cs.WithMethod("test")
@@ -24,3 +56,20 @@ func TestClientWithServiceName(t *testing.T) {
 	cs.WithServiceName("test")
 	assert.Equal(t, "test", cs.ServiceName)
 }
+
+func TestClientStreamSendRecv(t *testing.T) {
+	_, cs := NewStreamingClientStream(context.Background())
+	assert.Equal(t, ErrNotStreaming, cs.SendMsg(&testMsg{}))
+
+	transport := &chanTransport{msgs: make(chan []byte, 1)}
+	cs.WithTransport(transport)
+	cs.WithSerialization(codec.GetSerialization(codec.MsgPack))
+	assert.Equal(t, cs, GetClientStream(cs.Context()))
+
+	assert.Nil(t, cs.SendMsg(&testMsg{Msg: "hello"}))
+	rsp := &testMsg{}
+	assert.Nil(t, cs.CloseAndRecv(rsp))
+	assert.Equal(t, "hello", rsp.Msg)
+	assert.True(t, transport.closed)
+	assert.Equal(t, io.EOF, cs.RecvMsg(rsp))
+}
@@ -1,12 +1,18 @@
 package stream
 
-import "context"
+import (
+	"context"
+
+	"github.com/xing-you-ji/novarpc/codec"
+)
 
 type ServerStream struct {
-	ctx     context.Context
-	Method  string // 方法名
-	RetCode uint32 // 返回码 0—成功 非0-失败
-	RetMsg  string // 返回信息 OK-成功，失败返回具体信息
+	ctx           context.Context
+	Method        string // 方法名
+	RetCode       uint32 // 返回码 0—成功 非0-失败
+	RetMsg        string // 返回信息 OK-成功，失败返回具体信息
+	transport     Transport
+	serialization codec.Serialization
 }
 
 const ServerStreamKey = StreamContextKey("NOVARPC_SERVER_STREAM")
@@ -47,3 +53,49 @@ func NewServerStream(ctx context.Context) (context.Context, *ServerStream) {
 	valueCtx := context.WithValue(ctx, ServerStreamKey, ss)
 	return valueCtx, ss
 }
+
+// NewStreamingServerStream always creates a new ServerStream for a streaming call
+func NewStreamingServerStream(ctx context.Context) (context.Context, *ServerStream) {
+	ss := &ServerStream{}
+	ss.ctx = context.WithValue(ctx, ServerStreamKey, ss)
+	return ss.ctx, ss
+}
+
+func (ss *ServerStream) WithTransport(transport Transport) *ServerStream {
+	ss.transport = transport
+	return ss
+}
+
+func (ss *ServerStream) WithSerialization(serialization codec.Serialization) *ServerStream {
+	ss.serialization = serialization
+	return ss
+}
+
+// Context returns the context of the stream
+func (ss *ServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// SendMsg serializes and sends a message to the client
+func (ss *ServerStream) SendMsg(m interface{}) error {
+	if ss.transport == nil {
+		return ErrNotStreaming
+	}
+	data, err := ss.serialization.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ss.transport.Send(data)
+}
+
+// RecvMsg receives a message from the client, it returns io.EOF after the client has closed sending
+func (ss *ServerStream) RecvMsg(m interface{}) error {
+	if ss.transport == nil {
+		return ErrNotStreaming
+	}
+	data, err := ss.transport.Recv()
+	if err != nil {
+		return err
+	}
+	return codec.UnmarshalPayload(ss.serialization, data, m)
+}
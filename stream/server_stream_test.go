@@ -1,9 +1,12 @@
 package stream
 
 import (
+	"context"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codec"
 )
 
 func TestGetServerStream(t *testing.T) {
@@ -22,3 +25,19 @@ func TestClone(t *testing.T) {
 	test := ss.Clone()
 	assert.Equal(t, ss.Method, test.Method)
 }
+
+func TestServerStreamSendRecv(t *testing.T) {
+	ctx, ss := NewStreamingServerStream(context.Background())
+	assert.Equal(t, ErrNotStreaming, ss.RecvMsg(&testMsg{}))
+
+	ss.WithTransport(&chanTransport{msgs: make(chan []byte, 1)}).
+		WithSerialization(codec.GetSerialization(codec.MsgPack))
+	assert.Equal(t, ctx, ss.Context())
+	assert.Equal(t, ss, GetServerStream(ctx))
+
+	assert.Nil(t, ss.SendMsg(&testMsg{Msg: "world"}))
+	req := &testMsg{}
+	assert.Nil(t, ss.RecvMsg(req))
+	assert.Equal(t, "world", req.Msg)
+	assert.Equal(t, io.EOF, ss.RecvMsg(req))
+}
@@ -1,7 +1,31 @@
 package stream
 
+import "errors"
+
 type StreamContextKey string
 
 type Stream interface {
 	Clone() Stream
 }
+
+// Transport carries the serialized messages of one streaming call, it is implemented by the transport layer
+type Transport interface {
+	// Send sends one message
+	Send([]byte) error
+	// Recv receives one message, io.EOF means the peer has finished sending
+	Recv() ([]byte, error)
+	// CloseSend tells the peer that no more messages will be sent
+	CloseSend() error
+}
+
+// Desc describes which sides of a streaming method send a stream of messages
+type Desc struct {
+	ClientStreams bool // the client sends a stream of messages
+	ServerStreams bool // the server sends a stream of messages
+}
+
+// ErrNotStreaming is returned when Send/Recv is used on a stream that has no transport, e.g. a unary call
+var ErrNotStreaming = errors.New("not a streaming call")
+
+// ErrUnexpectedReply is returned by CloseAndRecv when the server sends more than one reply
+var ErrUnexpectedReply = errors.New("client stream received more than one reply")
@@ -3,7 +3,9 @@ package transport
 import (
 	"time"
 
+	"github.com/xing-you-ji/novarpc/auth"
 	"github.com/xing-you-ji/novarpc/pool/connpool"
+	"github.com/xing-you-ji/novarpc/pool/multiplexed"
 	"github.com/xing-you-ji/novarpc/selector"
 )
 
@@ -15,6 +17,16 @@ type ClientTransportOptions struct {
 	Pool        connpool.Pool
 	Selector    selector.Selector // 负载均衡
 	Timeout     time.Duration
+
+	Multiplexed     bool             // 多路复用：并发请求共享少量长连接
+	MultiplexedPool multiplexed.Pool // 多路复用连接池
+	Protocol        string           // protocol type, e.g. : proto
+	ReqType         uint8            // request type written in the frame header, e.g. : codec.BidiStreamReq
+
+	TransportAuth auth.TransportAuth // 连接建立后先握手，例如 TLS，nil 表示明文
+
+	CompressType      uint8 // 请求体的压缩类型, e.g. : codec.CompressTypeGzip
+	CompressThreshold int   // 请求体小于这个大小时不压缩
 }
 
 // Use the Options mode to wrap the ClientTransportOptions
@@ -61,3 +73,52 @@ func WithTimeout(timeout time.Duration) ClientTransportOption {
 		o.Timeout = timeout
 	}
 }
+
+// WithMultiplexed returns a ClientTransportOption which sets the value for multiplexed
+func WithMultiplexed(multiplexed bool) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.Multiplexed = multiplexed
+	}
+}
+
+// WithMultiplexedPool returns a ClientTransportOption which sets the value for multiplexedPool
+func WithMultiplexedPool(pool multiplexed.Pool) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.MultiplexedPool = pool
+	}
+}
+
+// WithClientProtocol returns a ClientTransportOption which sets the value for protocol
+func WithClientProtocol(protocol string) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.Protocol = protocol
+	}
+}
+
+// WithReqType returns a ClientTransportOption which sets the value for reqType
+func WithReqType(reqType uint8) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.ReqType = reqType
+	}
+}
+
+// WithClientTransportAuth returns a ClientTransportOption which sets the value for transportAuth
+func WithClientTransportAuth(transportAuth auth.TransportAuth) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.TransportAuth = transportAuth
+	}
+}
+
+// WithClientCompressType returns a ClientTransportOption which sets the value for compressType
+func WithClientCompressType(compressType uint8) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.CompressType = compressType
+	}
+}
+
+// WithClientCompressThreshold returns a ClientTransportOption which sets the value for compressThreshold
+func WithClientCompressThreshold(threshold int) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.CompressThreshold = threshold
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/xing-you-ji/novarpc/codec"
 	"github.com/xing-you-ji/novarpc/pool/connpool"
 	"github.com/xing-you-ji/novarpc/selector"
 
@@ -60,3 +61,33 @@ func TestWithSelector(t *testing.T) {
 	fCto(&cto)
 	assert.NotNil(t, cto.Selector)
 }
+
+func TestWithMultiplexed(t *testing.T) {
+	var cto ClientTransportOptions
+	fCto := WithMultiplexed(true)
+	fCto(&cto)
+	assert.True(t, cto.Multiplexed)
+	fCto = WithMultiplexedPool(DefaultMultiplexedPool)
+	fCto(&cto)
+	assert.NotNil(t, cto.MultiplexedPool)
+}
+
+func TestWithReqType(t *testing.T) {
+	var cto ClientTransportOptions
+	fCto := WithReqType(codec.BidiStreamReq)
+	fCto(&cto)
+	assert.Equal(t, uint8(codec.BidiStreamReq), cto.ReqType)
+	fCto = WithClientProtocol("proto")
+	fCto(&cto)
+	assert.Equal(t, "proto", cto.Protocol)
+}
+
+func TestWithClientCompress(t *testing.T) {
+	var cto ClientTransportOptions
+	fCto := WithClientCompressType(codec.CompressTypeSnappy)
+	fCto(&cto)
+	assert.Equal(t, uint8(codec.CompressTypeSnappy), cto.CompressType)
+	fCto = WithClientCompressThreshold(512)
+	fCto(&cto)
+	assert.Equal(t, 512, cto.CompressThreshold)
+}
@@ -2,8 +2,17 @@ package transport
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
 
+	"github.com/xing-you-ji/novarpc/auth"
+	"github.com/xing-you-ji/novarpc/codec"
 	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/pool/connpool"
+	"github.com/xing-you-ji/novarpc/pool/multiplexed"
+	"github.com/xing-you-ji/novarpc/selector"
 )
 
 type clientTransport struct {
@@ -44,37 +53,65 @@ var New = func() ClientTransport {
 	}
 }
 
+// DefaultMultiplexedPool is shared by all multiplexed calls
+var DefaultMultiplexedPool = multiplexed.NewPool(func() multiplexed.Framer {
+	return NewFramer()
+})
+
+// 每个 TransportAuth 使用各自的连接池，握手过的连接不会和明文连接混用
+var (
+	authPoolsMu          sync.Mutex
+	authPools            = make(map[auth.TransportAuth]connpool.Pool)
+	authMultiplexedPools = make(map[auth.TransportAuth]multiplexed.Pool)
+)
+
 func (c *clientTransport) Send(ctx context.Context, req []byte, opts ...ClientTransportOption) ([]byte, error) {
 
+	// 每次调用使用独立的参数，避免并发调用之间互相覆盖
+	callOpts := *c.opts
 	for _, o := range opts {
-		o(c.opts)
+		o(&callOpts)
+	}
+	call := &clientTransport{opts: &callOpts}
+
+	// 请求体超过阈值时压缩，服务端按帧头解压
+	req, err := codec.CompressFrame(req, call.opts.CompressType, call.opts.CompressThreshold)
+	if err != nil {
+		return nil, err
 	}
+	codec.SetReqType(req, call.opts.ReqType)
 
-	if c.opts.Network == "tcp" {
-		return c.SendTcpReq(ctx, req)
+	if call.opts.Network == "tcp" {
+		return call.SendTcpReq(ctx, req)
 	}
 
-	if c.opts.Network == "udp" {
-		return c.SendUdpReq(ctx, req)
+	if call.opts.Network == "udp" {
+		// 不支持对 udp 加密，拒绝以明文发送
+		if call.opts.TransportAuth != nil {
+			return nil, codes.NewFrameworkError(codes.ConfigErrorCode, "transport auth is not supported over udp")
+		}
+		return call.SendUdpReq(ctx, req)
 	}
 
 	return nil, codes.NetworkNotSupportedError
 }
 
-func (c *clientTransport) SendTcpReq(ctx context.Context, req []byte) ([]byte, error) {
+func (c *clientTransport) SendTcpReq(ctx context.Context, req []byte) (rsp []byte, err error) {
 
-	// service discovery
-	addr, err := c.opts.Selector.Select(c.opts.ServiceName)
+	addr, err := c.selectAddr(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// defaultSelector returns "", use the target as address
-	if addr == "" {
-		addr = c.opts.Target
+	// 把调用结果反馈给负载均衡
+	done := selector.Track(c.opts.Selector, c.opts.ServiceName, addr)
+	defer func() { done(err) }()
+
+	if c.opts.Multiplexed {
+		return c.sendMultiplexedReq(ctx, addr, req)
 	}
 
-	conn, err := c.opts.Pool.Get(ctx, c.opts.Network, addr)
+	conn, err := c.connPool().Get(ctx, c.opts.Network, addr)
 	//	conn, err := net.DialTimeout("tcp", addr, c.opts.Timeout);
 	if err != nil {
 		return nil, err
@@ -96,14 +133,174 @@ func (c *clientTransport) SendTcpReq(ctx context.Context, req []byte) ([]byte, e
 		}
 	}
 
+	// 单向调用，服务端不会回复
+	if c.opts.ReqType == codec.SendOnly {
+		return nil, nil
+	}
+
+	// 等待响应时调用被取消，通知服务端停止处理
+	stop := c.watchCancel(ctx, conn)
+	defer stop()
+
 	// parse frame
 	wrapperConn := wrapConn(conn)
-	frame, err := wrapperConn.framer.ReadFrame(conn)
+	goAway := false
+	for {
+		frame, err := wrapperConn.framer.ReadFrame(conn)
+		if err != nil {
+			// 读取因为调用取消而中断
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+
+		// 服务端正在关闭，继续等待响应
+		if codec.MsgType(frame) == codec.GoAwayMsg {
+			goAway = true
+			continue
+		}
+
+		// 连接池心跳迟到的 pong
+		if codec.MsgType(frame) == codec.HeartbeatMsg {
+			continue
+		}
+
+		// 连接不再放回连接池
+		if pc, ok := conn.(*connpool.PoolConn); ok && goAway {
+			pc.MarkUnusable()
+		}
+
+		return frame, nil
+	}
+}
+
+// sendMultiplexedReq sends the request on a shared connection and waits for the reply tagged with its stream ID
+func (c *clientTransport) sendMultiplexedReq(ctx context.Context, addr string, req []byte) ([]byte, error) {
+
+	conn, err := c.multiplexedPool().Get(ctx, c.opts.Network, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return frame, err
+	defer conn.Close()
+
+	if err = conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	// 单向调用，写出后即释放 stream ID
+	if c.opts.ReqType == codec.SendOnly {
+		return nil, nil
+	}
+
+	rsp, err := conn.Read(ctx)
+	if err != nil && ctx.Err() != nil {
+		// 调用被取消，通知服务端停止处理这个 stream ID 上的请求
+		if frame, err := c.cancelFrame(); err == nil {
+			conn.Write(frame)
+		}
+	}
+
+	return rsp, err
+}
+
+// watchCancel sends a cancel frame when ctx ends while the response of a non-multiplexed request is awaited,
+// and then interrupts the read. The connection is not reused since its response may still arrive.
+func (c *clientTransport) watchCancel(ctx context.Context, conn net.Conn) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			if frame, err := c.cancelFrame(); err == nil {
+				conn.Write(frame)
+			}
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// cancelFrame builds the frame asking the server to stop the request, the stream ID is filled in by the sender
+func (c *clientTransport) cancelFrame() ([]byte, error) {
+	frame, err := codec.GetCodec(c.opts.Protocol).Encode(nil)
+	if err != nil {
+		return nil, err
+	}
+	codec.SetMsgType(frame, codec.CancelMsg)
+
+	return frame, nil
+}
+
+// connPool returns the pool of the call, with TransportAuth set connections come from a pool that handshakes them
+func (c *clientTransport) connPool() connpool.Pool {
+	if c.opts.TransportAuth == nil {
+		return c.opts.Pool
+	}
+
+	authPoolsMu.Lock()
+	defer authPoolsMu.Unlock()
+
+	pool, ok := authPools[c.opts.TransportAuth]
+	if !ok {
+		pool = connpool.NewConnPool(connpool.WithTransportAuth(c.opts.TransportAuth))
+		authPools[c.opts.TransportAuth] = pool
+	}
+	return pool
+}
+
+// multiplexedPool returns the multiplexed pool of the call, like connPool with TransportAuth set connections
+// come from a pool that handshakes them, so handshaked and plaintext streams never share a connection
+func (c *clientTransport) multiplexedPool() multiplexed.Pool {
+	if c.opts.TransportAuth == nil {
+		if c.opts.MultiplexedPool != nil {
+			return c.opts.MultiplexedPool
+		}
+		return DefaultMultiplexedPool
+	}
+
+	authPoolsMu.Lock()
+	defer authPoolsMu.Unlock()
+
+	pool, ok := authMultiplexedPools[c.opts.TransportAuth]
+	if !ok {
+		pool = multiplexed.NewPool(func() multiplexed.Framer { return NewFramer() },
+			multiplexed.WithTransportAuth(c.opts.TransportAuth))
+		authMultiplexedPools[c.opts.TransportAuth] = pool
+	}
+	return pool
+}
+
+// selectAddr picks the downstream address through service discovery
+func (c *clientTransport) selectAddr(ctx context.Context) (string, error) {
+	addr, err := selector.Select(ctx, c.opts.Selector, c.opts.ServiceName)
+	if err != nil {
+		return "", err
+	}
+
+	// defaultSelector returns "", use the target as address
+	if addr == "" {
+		addr = c.opts.Target
+	}
+
+	// 重试时避开已经尝试过的节点
+	selector.MarkTried(ctx, addr)
+
+	// 例如熔断器打开时拒绝发送
+	if !selector.Admit(ctx, c.opts.ServiceName, addr) {
+		return "", codes.NewFrameworkError(codes.UnavailableErrorCode, fmt.Sprintf("calls to %s are rejected", addr))
+	}
+	selector.SetPeer(ctx, addr)
+
+	return addr, nil
 }
 
 func isDone(ctx context.Context) error {
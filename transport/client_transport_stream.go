@@ -0,0 +1,167 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/pool/multiplexed"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/stream"
+)
+
+// NewStream opens a stream on a multiplexed connection, all frames of the stream carry the same stream ID
+func (c *clientTransport) NewStream(ctx context.Context, req []byte, opts ...ClientTransportOption) (stream.Transport, error) {
+
+	callOpts := *c.opts
+	for _, o := range opts {
+		o(&callOpts)
+	}
+	call := &clientTransport{opts: &callOpts}
+
+	if call.opts.Network != "tcp" {
+		return nil, codes.NetworkNotSupportedError
+	}
+
+	addr, err := call.selectAddr(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := call.multiplexedPool().Get(ctx, call.opts.Network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 首帧携带服务路径和元数据
+	req, err = codec.CompressFrame(req, call.opts.CompressType, call.opts.CompressThreshold)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	codec.SetReqType(req, call.opts.ReqType)
+	if err = conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	cs := &clientStream{
+		ctx:     ctx,
+		conn:    conn,
+		codec:   codec.GetCodec(call.opts.Protocol),
+		reqType: call.opts.ReqType,
+		done:    make(chan struct{}),
+
+		compressType:      call.opts.CompressType,
+		compressThreshold: call.opts.CompressThreshold,
+	}
+	go cs.watch()
+
+	return cs, nil
+}
+
+// clientStream is the client side of one streaming call
+type clientStream struct {
+	ctx     context.Context
+	conn    *multiplexed.VirtualConn
+	codec   codec.Codec
+	reqType uint8
+	done    chan struct{} // closed once the server has ended the stream
+	err     error         // Recv 在流结束之后返回的结果，io.EOF 或者最终的错误
+	once    sync.Once
+
+	compressType      uint8
+	compressThreshold int
+}
+
+func (cs *clientStream) Send(payload []byte) error {
+	return cs.write(codec.GeneralMsg, &protocol.Request{Payload: payload})
+}
+
+func (cs *clientStream) CloseSend() error {
+	return cs.write(codec.StreamEndMsg, &protocol.Request{})
+}
+
+func (cs *clientStream) Recv() ([]byte, error) {
+	// 流已经结束，重复返回最终的结果
+	select {
+	case <-cs.done:
+		return nil, cs.err
+	default:
+	}
+
+	frame, err := cs.conn.Read(cs.ctx)
+	if err != nil {
+		// a cancelled context is handled by watch, which also notifies the server and closes the stream
+		if ctxErr := cs.ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		cs.finish(err)
+		return nil, err
+	}
+
+	rspBuf, err := cs.codec.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &protocol.Response{}
+	if err = proto.Unmarshal(rspBuf, response); err != nil {
+		return nil, err
+	}
+
+	// 服务端结束了流，响应中携带最终的返回码
+	if codec.MsgType(frame) == codec.StreamEndMsg {
+		err = codes.FromResponse(response)
+		if err == nil {
+			err = io.EOF
+		}
+		cs.finish(err)
+		return nil, err
+	}
+
+	return response.Payload, nil
+}
+
+func (cs *clientStream) write(msgType uint8, request *protocol.Request) error {
+	reqBuf, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	frame, err := cs.codec.Encode(reqBuf)
+	if err != nil {
+		return err
+	}
+
+	if frame, err = codec.CompressFrame(frame, cs.compressType, cs.compressThreshold); err != nil {
+		return err
+	}
+
+	codec.SetReqType(frame, cs.reqType)
+	codec.SetMsgType(frame, msgType)
+
+	return cs.conn.Write(frame)
+}
+
+// watch releases the stream ID when the stream ends, and tells the server to stop when the caller cancels.
+// The stream ends once Recv has read the end of the stream or failed, a stream that is neither read to the
+// end nor cancelled keeps the goroutine and the stream ID.
+func (cs *clientStream) watch() {
+	select {
+	case <-cs.ctx.Done():
+		cs.write(codec.CancelMsg, &protocol.Request{})
+	case <-cs.done:
+	}
+	cs.conn.Close()
+}
+
+func (cs *clientStream) finish(err error) {
+	cs.once.Do(func() {
+		cs.err = err
+		close(cs.done)
+	})
+}
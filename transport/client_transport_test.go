@@ -1,9 +1,20 @@
 package transport
 
 import (
+	"context"
+	"crypto/tls"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/auth"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/pool/connpool"
+	"github.com/xing-you-ji/novarpc/pool/multiplexed"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/selector"
 )
 
 var NewClientTransport = func() ClientTransport {
@@ -20,3 +31,86 @@ func TestGetClientTransport(t *testing.T) {
 	clientTransport = GetClientTransport("test")
 	assert.Equal(t, clientTransport, DefaultClientTransport)
 }
+
+// feedbackSelector always selects addr and records the calls reported to it
+type feedbackSelector struct {
+	addr    string
+	started []string
+	done    []selector.DoneInfo
+}
+
+func (f *feedbackSelector) Select(serviceName string) (string, error) {
+	return f.addr, nil
+}
+
+func (f *feedbackSelector) Start(serviceName string, addr string) {
+	f.started = append(f.started, serviceName+"/"+addr)
+}
+
+func (f *feedbackSelector) Done(serviceName string, addr string, info selector.DoneInfo) {
+	f.done = append(f.done, info)
+}
+
+func TestClientTransportFeedback(t *testing.T) {
+	s, addr := startShutdownTestServer(t, &echoHandler{})
+	defer s.Shutdown(context.Background())
+
+	reqBuf, err := proto.Marshal(&protocol.Request{Payload: []byte("ping")})
+	assert.Nil(t, err)
+	req, err := codec.DefaultCodec.Encode(reqBuf)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, multiplexed := range []bool{false, true} {
+		f := &feedbackSelector{addr: addr}
+		_, err = DefaultClientTransport.Send(ctx, req, WithServiceName("Greeter"), WithClientNetwork("tcp"),
+			WithSelector(f), WithClientPool(connpool.GetPool("default")), WithMultiplexed(multiplexed))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Greeter/" + addr}, f.started)
+		assert.Len(t, f.done, 1)
+		assert.Nil(t, f.done[0].Err)
+		assert.True(t, f.done[0].Duration > 0)
+	}
+
+	// 失败的调用同样反馈给负载均衡
+	f := &feedbackSelector{addr: "127.0.0.1:1"}
+	_, err = DefaultClientTransport.Send(ctx, req, WithServiceName("Greeter"), WithClientNetwork("tcp"),
+		WithSelector(f), WithClientPool(connpool.GetPool("default")))
+	assert.NotNil(t, err)
+	assert.Len(t, f.done, 1)
+	assert.Equal(t, err, f.done[0].Err)
+}
+
+type rejectFilter struct{}
+
+func (f *rejectFilter) Ready(serviceName string, addr string) bool { return false }
+func (f *rejectFilter) Admit(serviceName string, addr string) bool { return false }
+
+func TestClientTransportAdmit(t *testing.T) {
+	f := &feedbackSelector{addr: "127.0.0.1:1"}
+	ctx, peer := selector.WithPeer(selector.WithFilter(context.Background(), &rejectFilter{}))
+
+	// 被拒绝的节点不会建立连接，也不会反馈给负载均衡
+	_, err := DefaultClientTransport.Send(ctx, []byte{}, WithServiceName("Greeter"), WithClientNetwork("tcp"),
+		WithSelector(f), WithClientPool(connpool.GetPool("default")))
+	assert.Equal(t, uint32(codes.UnavailableErrorCode), codes.Code(err))
+	assert.Empty(t, f.started)
+	assert.Equal(t, "", peer.Addr())
+}
+
+func TestClientTransportMultiplexedPool(t *testing.T) {
+	pool := multiplexed.NewPool(func() multiplexed.Framer { return NewFramer() })
+
+	// 明文调用使用调用方设置的连接池
+	plain := &clientTransport{opts: &ClientTransportOptions{MultiplexedPool: pool}}
+	assert.Equal(t, multiplexed.Pool(pool), plain.multiplexedPool())
+	assert.Equal(t, multiplexed.Pool(DefaultMultiplexedPool), (&clientTransport{opts: &ClientTransportOptions{}}).multiplexedPool())
+
+	// 握手的调用使用 TransportAuth 各自的连接池，不和明文调用共用连接
+	a := auth.NewClientTLSAuth(&tls.Config{})
+	secure := &clientTransport{opts: &ClientTransportOptions{MultiplexedPool: pool, TransportAuth: a}}
+	assert.NotEqual(t, multiplexed.Pool(pool), secure.multiplexedPool())
+	assert.Equal(t, secure.multiplexedPool(), (&clientTransport{opts: &ClientTransportOptions{TransportAuth: a}}).multiplexedPool())
+}
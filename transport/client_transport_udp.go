@@ -4,20 +4,20 @@ import (
 	"context"
 	"net"
 
+	"github.com/xing-you-ji/novarpc/codec"
 	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/selector"
 )
 
-func (c *clientTransport) SendUdpReq(ctx context.Context, req []byte) ([]byte, error) {
-	// service discovery
-	addr, err := c.opts.Selector.Select(c.opts.ServiceName)
+func (c *clientTransport) SendUdpReq(ctx context.Context, req []byte) (rsp []byte, err error) {
+	addr, err := c.selectAddr(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// defaultSelector returns "", use the target as address
-	if addr == "" {
-		addr = c.opts.Target
-	}
+	// 把调用结果反馈给负载均衡
+	done := selector.Track(c.opts.Selector, c.opts.ServiceName, addr)
+	defer func() { done(err) }()
 
 	udpAddr, err := net.ResolveUDPAddr(c.opts.Network, addr)
 	if err != nil {
@@ -35,13 +35,18 @@ func (c *clientTransport) SendUdpReq(ctx context.Context, req []byte) ([]byte, e
 		return nil, err
 	}
 
+	// 单向调用，不等待响应
+	if c.opts.ReqType == codec.SendOnly {
+		return nil, nil
+	}
+
 	recvBuf := make([]byte, 65536)
 	n, err := conn.Read(recvBuf)
 	if err != nil {
 		return nil, err
 	}
 
-	rsp := recvBuf[:n]
+	rsp = recvBuf[:n]
 
 	return rsp, nil
 }
@@ -3,17 +3,23 @@ package transport
 import (
 	"context"
 	"time"
+
+	"github.com/xing-you-ji/novarpc/auth"
+	"github.com/xing-you-ji/novarpc/stream"
 )
 
 // ServerTransportOptions includes all ServerTransport parameter options
 type ServerTransportOptions struct {
-	Address           string        // address，e.g: ip://127.0.0.1：8080
-	Network           string        // network type
-	Protocol          string        // protocol type, e.g. : proto、json
-	Timeout           time.Duration // transport layer request timeout ，default: 2 min
-	Handler           Handler       // handler
-	SerializationType string        // serialization type, e.g : proto、json、msgpack
-	KeepAlivePeriod   time.Duration // keepalive period
+	Address           string             // address，e.g: ip://127.0.0.1：8080
+	Network           string             // network type
+	Protocol          string             // protocol type, e.g. : proto、json
+	Timeout           time.Duration      // transport layer request timeout ，default: 2 min
+	Handler           Handler            // handler
+	SerializationType string             // serialization type, e.g : proto、json、msgpack
+	KeepAlivePeriod   time.Duration      // keepalive period
+	TransportAuth     auth.TransportAuth // handshake of every connection, e.g. TLS, nil means plaintext
+	CompressType      uint8              // compress type of responses, e.g. : codec.CompressTypeGzip
+	CompressThreshold int                // responses smaller than it are not compressed
 }
 
 // Handler defines a common interface for handling packets
@@ -21,6 +27,11 @@ type Handler interface {
 	Handle(context.Context, []byte) ([]byte, error)
 }
 
+// StreamHandler handles streaming calls, a Handler implements it to support streaming
+type StreamHandler interface {
+	HandleStream(context.Context, []byte, stream.Transport) error
+}
+
 // Use the Options mode to wrap the ServerTransportOptions
 type ServerTransportOption func(*ServerTransportOptions)
 
@@ -72,3 +83,24 @@ func WithKeepAlivePeriod(keepAlivePeriod time.Duration) ServerTransportOption {
 		o.KeepAlivePeriod = keepAlivePeriod
 	}
 }
+
+// WithServerTransportAuth returns a ServerTransportOption which sets the value for transportAuth
+func WithServerTransportAuth(transportAuth auth.TransportAuth) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.TransportAuth = transportAuth
+	}
+}
+
+// WithServerCompressType returns a ServerTransportOption which sets the value for compressType
+func WithServerCompressType(compressType uint8) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.CompressType = compressType
+	}
+}
+
+// WithServerCompressThreshold returns a ServerTransportOption which sets the value for compressThreshold
+func WithServerCompressThreshold(threshold int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.CompressThreshold = threshold
+	}
+}
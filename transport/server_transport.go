@@ -2,21 +2,35 @@ package transport
 
 import (
 	"context"
+	"errors"
 	"go.uber.org/zap"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/xing-you-ji/novarpc/auth"
 	"github.com/xing-you-ji/novarpc/codec"
 	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/metadata"
 	"github.com/xing-you-ji/novarpc/protocol"
 	"github.com/xing-you-ji/novarpc/stream"
 	"github.com/xing-you-ji/novarpc/utils"
 )
 
+// handshakeTimeout 连接握手的最长时间
+var handshakeTimeout = 5 * time.Second
+
 type serverTransport struct {
 	opts *ServerTransportOptions
+
+	mu           sync.Mutex
+	listeners    []net.Listener            // tcp 监听
+	packetConns  []net.PacketConn          // udp 监听
+	conns        map[*connWrapper]struct{} // 活跃的 tcp 连接
+	udpActive    int32                     // 正在处理的 udp 请求
+	shuttingDown bool                      // 正在关闭，不再接收新连接
 }
 
 var serverTransportMap = make(map[string]ServerTransport)
@@ -53,6 +67,12 @@ var NewServerTransport = func() ServerTransport {
 	}
 }
 
+// Clone implements ServerTransportCloner
+func (s *serverTransport) Clone() ServerTransport {
+	opts := *s.opts
+	return &serverTransport{opts: &opts}
+}
+
 func (s *serverTransport) ListenAndServe(ctx context.Context, opts ...ServerTransportOption) error {
 
 	for _, o := range opts {
@@ -63,6 +83,10 @@ func (s *serverTransport) ListenAndServe(ctx context.Context, opts ...ServerTran
 	case "tcp", "tcp4", "tcp6":
 		return s.ListenAndServeTcp(ctx, opts...)
 	case "udp", "udp4", "udp6":
+		// 不支持对 udp 加密，拒绝以明文提供服务
+		if s.opts.TransportAuth != nil {
+			return codes.NewFrameworkError(codes.ConfigErrorCode, "transport auth is not supported over udp")
+		}
 		return s.ListenAndServeUdp(ctx, opts...)
 	default:
 		return codes.NetworkNotSupportedError
@@ -76,6 +100,11 @@ func (s *serverTransport) ListenAndServeTcp(ctx context.Context, opts ...ServerT
 		return err
 	}
 
+	if !s.trackListener(listener) {
+		listener.Close()
+		return ErrServerShutdown
+	}
+
 	go func() {
 		if err = s.serve(ctx, listener); err != nil {
 			zap.L().Error("transport serve error", zap.Error(err))
@@ -105,6 +134,11 @@ func (s *serverTransport) serve(ctx context.Context, listener net.Listener) erro
 		// 监听客户端连接
 		conn, err := tcpListener.AcceptTCP()
 		if err != nil {
+			// listener 被优雅关闭
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
 			// 检查错误是否是暂时性的
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
@@ -138,7 +172,16 @@ func (s *serverTransport) serve(ctx context.Context, listener net.Listener) erro
 			// 为每个连接创建一个上下文
 			ctx, _ := stream.NewServerStream(ctx)
 
-			if err := s.handleConn(ctx, wrapConn(conn)); err != nil {
+			// 握手成功后才处理请求，对端信息通过上下文传给业务
+			authConn, authInfo, err := s.handshake(conn)
+			if err != nil {
+				zap.L().Error("novaRPC tcp conn handshake error", zap.Error(err))
+				conn.Close()
+				return
+			}
+			ctx = auth.WithPeer(ctx, &auth.Peer{Addr: conn.RemoteAddr(), AuthInfo: authInfo})
+
+			if err := s.handleConn(ctx, wrapConn(authConn)); err != nil {
 				zap.L().Error("novaRPC handle tcp conn error", zap.Error(err))
 			}
 
@@ -146,11 +189,38 @@ func (s *serverTransport) serve(ctx context.Context, listener net.Listener) erro
 	}
 }
 
+// handshake 使用 TransportAuth 与客户端握手，没有设置时使用明文连接
+func (s *serverTransport) handshake(conn net.Conn) (net.Conn, auth.AuthInfo, error) {
+	if s.opts.TransportAuth == nil {
+		return conn, nil, nil
+	}
+
+	// 防止客户端迟迟不握手一直占用连接
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	authConn, authInfo, err := s.opts.TransportAuth.ServerHandshake(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return authConn, authInfo, nil
+}
+
 // handleConn 处理客户端连接
 func (s *serverTransport) handleConn(ctx context.Context, conn *connWrapper) error {
 	// 关闭连接
 	defer conn.Close()
 
+	// 记录连接，优雅关闭时等待其上的请求处理完成
+	if !s.trackConn(conn, true) {
+		return nil
+	}
+	defer s.trackConn(conn, false)
+
+	// 连接断开时取消其上所有的请求与流
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// 读取客户端请求
 	for {
 		// 监听关闭信号
@@ -169,19 +239,62 @@ func (s *serverTransport) handleConn(ctx context.Context, conn *connWrapper) err
 		}
 		// 如果是其他错误，直接返回
 		if err != nil {
+			// 连接被优雅关闭
+			if conn.isClosed() {
+				return nil
+			}
 			return err
 		}
 
-		// 处理客户端请求
-		rsp, err := s.handle(ctx, frame)
-		if err != nil {
-			zap.L().Error("novaRPC handle error", zap.Error(err))
+		// 心跳由传输层直接原样回复，不经过 Handler
+		if codec.MsgType(frame) == codec.HeartbeatMsg {
+			s.write(ctx, conn, frame)
+			continue
 		}
 
-		// 响应客户端请求
-		if err = s.write(ctx, conn, rsp); err != nil {
-			return err
+		// 流式请求按 stream ID 分发到对应的流
+		if codec.IsStream(codec.ReqType(frame)) {
+			s.handleStreamFrame(ctx, conn, frame)
+			continue
 		}
+
+		// 客户端放弃了请求，取消对应 stream ID 的 Handler
+		if codec.MsgType(frame) == codec.CancelMsg {
+			conn.cancelRequest(codec.StreamID(frame))
+			continue
+		}
+
+		// 服务端正在关闭，不再处理新的请求
+		if !conn.acquire() {
+			s.reject(ctx, conn, frame)
+			continue
+		}
+
+		// 读取下一帧之前登记请求，之后到达的取消帧才能找到它
+		reqCtx, done := conn.trackRequest(ctx, codec.StreamID(frame))
+
+		// 并发处理客户端请求，响应可能乱序返回，客户端通过 stream ID 对应
+		go func() {
+			defer conn.release()
+			defer done()
+
+			rsp, err := s.handle(reqCtx, frame)
+			if err != nil {
+				zap.L().Error("novaRPC handle error", zap.Error(err))
+				return
+			}
+
+			// 单向调用不回复
+			if rsp == nil {
+				return
+			}
+
+			// 响应使用请求的 stream ID
+			codec.SetStreamID(rsp, codec.StreamID(frame))
+
+			// 响应客户端请求
+			s.write(ctx, conn, rsp)
+		}()
 	}
 
 }
@@ -209,14 +322,21 @@ func (s *serverTransport) handle(ctx context.Context, requestBuf []byte) ([]byte
 		return nil, err
 	}
 
-	// 得到响应体
+	// 得到响应体，Handler 和拦截器可以设置随响应返回的元数据
+	ctx = metadata.WithResponseMetadata(ctx)
 	responseBuf, err := s.opts.Handler.Handle(ctx, request)
 	if err != nil {
 		zap.L().Error("novaRPC handle error", zap.Error(err))
 	}
 
+	// 单向调用只执行 Handler，没有响应
+	if codec.ReqType(requestBuf) == codec.SendOnly {
+		return nil, nil
+	}
+
 	// 添加响应头
 	response := addRspHeader(responseBuf, err)
+	response.Metadata = metadata.ResponseMetadata(ctx)
 
 	// 使用protobuf 序列化response
 	rspPb, err := proto.Marshal(response)
@@ -232,6 +352,13 @@ func (s *serverTransport) handle(ctx context.Context, requestBuf []byte) ([]byte
 		return nil, err
 	}
 
+	// 响应体超过阈值时压缩
+	responseBody, err = codec.CompressFrame(responseBody, s.opts.CompressType, s.opts.CompressThreshold)
+	if err != nil {
+		zap.L().Error("novaRPC compress error", zap.Error(err))
+		return nil, err
+	}
+
 	return responseBody, nil
 }
 
@@ -243,21 +370,38 @@ func addRspHeader(payload []byte, err error) *protocol.Response {
 	}
 
 	if err != nil {
-		if e, ok := err.(*codes.Error); ok {
-			response.RetCode = e.Code
-			response.RetMsg = e.Message
-		} else {
-			response.RetCode = codes.ServerInternalErrorCode
-			response.RetMsg = codes.ServerInternalError.Message
+		// 无法识别的错误不把内部信息暴露给客户端
+		e, ok := codes.FromError(err)
+		if !ok {
+			e = codes.ServerInternalError
 		}
+		// 值为 nil 的 *codes.Error 表示成功
+		if e == nil {
+			return response
+		}
+
+		response.RetCode = e.Code
+		response.RetMsg = e.Message
+		response.RetType = uint32(e.Type)
+
+		details, err := codes.EncodeDetails(e.Details)
+		if err != nil {
+			zap.L().Error("novaRPC encode error details error", zap.Error(err))
+		}
+		response.Details = details
 	}
 
 	return response
 }
 
-func (s *serverTransport) write(ctx context.Context, conn net.Conn, rsp []byte) error {
+func (s *serverTransport) write(ctx context.Context, conn *connWrapper, rsp []byte) error {
+	// 同一连接上的响应需要串行写入，避免帧交错
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
 	if _, err := conn.Write(rsp); err != nil {
 		zap.L().Error("novaRPC write error", zap.Error(err))
+		return err
 	}
 
 	return nil
@@ -265,7 +409,57 @@ func (s *serverTransport) write(ctx context.Context, conn net.Conn, rsp []byte)
 
 type connWrapper struct {
 	net.Conn
-	framer Framer
+	framer    Framer
+	mu        sync.Mutex // write lock
+	streamsMu sync.Mutex
+	streams   map[uint16]*serverStream // streaming calls on the connection
+
+	activeMu  sync.Mutex
+	active    int  // 正在处理的请求和流
+	goingAway bool // 已经发送 GOAWAY，新的请求回复 Unavailable
+	closed    bool // 优雅关闭时连接空闲后被关闭
+
+	requestsMu sync.Mutex
+	requests   map[uint16]*request // 正在处理的非流式请求，用于取消
+}
+
+// request is a unary request being handled, the client cancels it by its stream ID
+type request struct {
+	cancel context.CancelFunc
+}
+
+// trackRequest derives the handler context of a request, done must be called when the request is handled
+func (c *connWrapper) trackRequest(ctx context.Context, id uint16) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	req := &request{cancel: cancel}
+
+	c.requestsMu.Lock()
+	if c.requests == nil {
+		c.requests = make(map[uint16]*request)
+	}
+	c.requests[id] = req
+	c.requestsMu.Unlock()
+
+	return ctx, func() {
+		c.requestsMu.Lock()
+		// stream ID 可能已经被新的请求复用
+		if c.requests[id] == req {
+			delete(c.requests, id)
+		}
+		c.requestsMu.Unlock()
+		cancel()
+	}
+}
+
+// cancelRequest cancels the handler of the request with the stream ID, it does nothing if the request has finished
+func (c *connWrapper) cancelRequest(id uint16) {
+	c.requestsMu.Lock()
+	req, ok := c.requests[id]
+	c.requestsMu.Unlock()
+
+	if ok {
+		req.cancel()
+	}
 }
 
 func wrapConn(rawConn net.Conn) *connWrapper {
@@ -0,0 +1,254 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/codes"
+	"go.uber.org/zap"
+)
+
+// ErrServerShutdown is returned when listening on a transport that is shutting down
+var ErrServerShutdown = errors.New("server transport is shutting down")
+
+// shutdownPollInterval 优雅关闭时检查连接是否空闲的间隔
+var shutdownPollInterval = 10 * time.Millisecond
+
+// goAwayGracePeriod 发送 GOAWAY 之后空闲连接保持打开的时间，客户端在收到 GOAWAY 之前发出的请求
+// 收到 Unavailable，而不是连接断开
+var goAwayGracePeriod = time.Second
+
+// errShuttingDown 回复 GOAWAY 之后到达的请求，请求没有被处理，客户端可以安全地重试
+var errShuttingDown = codes.NewFrameworkError(codes.UnavailableErrorCode, "server is shutting down")
+
+// Shutdown stops accepting connections, tells clients to go away, waits for in-flight
+// requests and streams until ctx is done, then closes all connections. Idle connections are
+// kept for goAwayGracePeriod so that requests crossing the GOAWAY are answered with Unavailable.
+func (s *serverTransport) Shutdown(ctx context.Context) error {
+
+	s.mu.Lock()
+	s.shuttingDown = true
+
+	// 停止接收新的连接
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	s.listeners = nil
+
+	// udp 停止读取新的请求，正在处理的请求还需要写回响应
+	for _, conn := range s.packetConns {
+		conn.SetReadDeadline(time.Now())
+	}
+
+	conns := make([]*connWrapper, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	// 通知客户端不要在这些连接上发起新的请求
+	for _, conn := range conns {
+		s.goAway(ctx, conn)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	// 关闭完成后 transport 可以重新监听
+	defer func() {
+		s.mu.Lock()
+		s.shuttingDown = false
+		s.mu.Unlock()
+	}()
+
+	grace := time.Now().Add(goAwayGracePeriod)
+	for {
+		if s.closeIdleConns(!time.Now().Before(grace)) {
+			s.closePacketConns()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			// 超时，强制关闭所有连接
+			s.closeConns()
+			s.closePacketConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *serverTransport) goAway(ctx context.Context, conn *connWrapper) {
+	// 之后读到的请求不再处理
+	conn.activeMu.Lock()
+	conn.goingAway = true
+	conn.activeMu.Unlock()
+
+	frame, err := codec.GetCodec(s.opts.Protocol).Encode(nil)
+	if err != nil {
+		zap.L().Error("novaRPC encode goaway error", zap.Error(err))
+		return
+	}
+	codec.SetMsgType(frame, codec.GoAwayMsg)
+
+	s.write(ctx, conn, frame)
+}
+
+// reject answers a request that arrived after GOAWAY with errShuttingDown, one-way requests get no reply
+func (s *serverTransport) reject(ctx context.Context, conn *connWrapper, frame []byte) {
+	if codec.ReqType(frame) == codec.SendOnly {
+		return
+	}
+
+	rspPb, err := proto.Marshal(addRspHeader(nil, errShuttingDown))
+	if err != nil {
+		zap.L().Error("novaRPC proto marshal error", zap.Error(err))
+		return
+	}
+	rsp, err := codec.GetCodec(s.opts.Protocol).Encode(rspPb)
+	if err != nil {
+		zap.L().Error("novaRPC encode error", zap.Error(err))
+		return
+	}
+
+	codec.SetStreamID(rsp, codec.StreamID(frame))
+	// 流的首帧，以结束流的帧回复
+	if codec.IsStream(codec.ReqType(frame)) {
+		codec.SetReqType(rsp, codec.ReqType(frame))
+		codec.SetMsgType(rsp, codec.StreamEndMsg)
+	}
+
+	s.write(ctx, conn, rsp)
+}
+
+// closeIdleConns closes the connections without in-flight requests, returns true when all are done.
+// Before graceOver idle connections are kept open, it only returns true once clients have closed them.
+func (s *serverTransport) closeIdleConns(graceOver bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	done := atomic.LoadInt32(&s.udpActive) == 0
+	for conn := range s.conns {
+		if !graceOver || !conn.closeIfIdle() {
+			done = false
+		}
+	}
+	return done
+}
+
+func (s *serverTransport) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.close()
+	}
+}
+
+func (s *serverTransport) closePacketConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.packetConns {
+		conn.Close()
+	}
+	s.packetConns = nil
+}
+
+func (s *serverTransport) trackListener(listener net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+	s.listeners = append(s.listeners, listener)
+	return true
+}
+
+func (s *serverTransport) trackPacketConn(conn net.PacketConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown {
+		return false
+	}
+	s.packetConns = append(s.packetConns, conn)
+	return true
+}
+
+// trackConn adds or removes a connection, a new connection is refused while shutting down
+func (s *serverTransport) trackConn(conn *connWrapper, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !add {
+		delete(s.conns, conn)
+		return true
+	}
+
+	if s.shuttingDown {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*connWrapper]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+// acquire records an in-flight request or stream, returns false after GOAWAY or if the connection is closed
+func (c *connWrapper) acquire() bool {
+	c.activeMu.Lock()
+	defer c.activeMu.Unlock()
+
+	if c.goingAway || c.closed {
+		return false
+	}
+	c.active++
+	return true
+}
+
+func (c *connWrapper) release() {
+	c.activeMu.Lock()
+	defer c.activeMu.Unlock()
+
+	c.active--
+}
+
+// closeIfIdle closes the connection when nothing is in flight on it
+func (c *connWrapper) closeIfIdle() bool {
+	c.activeMu.Lock()
+	defer c.activeMu.Unlock()
+
+	if c.active > 0 {
+		return false
+	}
+	if !c.closed {
+		c.closed = true
+		c.Close()
+	}
+	return true
+}
+
+// close closes the connection even if requests are still in flight
+func (c *connWrapper) close() {
+	c.activeMu.Lock()
+	defer c.activeMu.Unlock()
+
+	c.closed = true
+	c.Close()
+}
+
+func (c *connWrapper) isClosed() bool {
+	c.activeMu.Lock()
+	defer c.activeMu.Unlock()
+
+	return c.closed
+}
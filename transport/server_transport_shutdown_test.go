@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/protocol"
+)
+
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return []byte("done"), nil
+}
+
+func startShutdownTestServer(t *testing.T, h Handler) (*serverTransport, string) {
+	s := NewServerTransport().(*serverTransport)
+	err := s.ListenAndServe(context.Background(), WithServerAddress("127.0.0.1:0"),
+		WithServerNetwork("tcp"), WithHandler(h))
+	assert.Nil(t, err)
+
+	s.mu.Lock()
+	addr := s.listeners[0].Addr().String()
+	s.mu.Unlock()
+
+	return s, addr
+}
+
+func sendTestRequest(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+
+	frame, err := codec.DefaultCodec.Encode([]byte{})
+	assert.Nil(t, err)
+	_, err = conn.Write(frame)
+	assert.Nil(t, err)
+
+	return conn
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	h := &blockingHandler{started: make(chan struct{}, 1), release: make(chan struct{})}
+	s, addr := startShutdownTestServer(t, h)
+
+	conn := sendTestRequest(t, addr)
+	defer conn.Close()
+	<-h.started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	// 先收到 GOAWAY
+	framer := NewFramer()
+	frame, err := framer.ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(codec.GoAwayMsg), codec.MsgType(frame))
+
+	// 不再接收新的连接
+	_, err = net.DialTimeout("tcp", addr, 100*time.Millisecond)
+	assert.NotNil(t, err)
+
+	// 正在处理的请求仍然返回响应
+	close(h.release)
+	frame, err = framer.ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(codec.GeneralMsg), codec.MsgType(frame))
+
+	assert.Nil(t, <-shutdown)
+
+	// 请求处理完成后连接被关闭
+	_, err = framer.ReadFrame(conn)
+	assert.NotNil(t, err)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	h := &blockingHandler{started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(h.release)
+	s, addr := startShutdownTestServer(t, h)
+
+	conn := sendTestRequest(t, addr)
+	defer conn.Close()
+	<-h.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+
+	// 超时后强制关闭连接
+	framer := NewFramer()
+	frame, err := framer.ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(codec.GoAwayMsg), codec.MsgType(frame))
+	_, err = framer.ReadFrame(conn)
+	assert.NotNil(t, err)
+}
+
+func TestShutdownRejectsLateRequests(t *testing.T) {
+	grace := goAwayGracePeriod
+	goAwayGracePeriod = 100 * time.Millisecond
+	defer func() { goAwayGracePeriod = grace }()
+
+	h := &recordHandler{requests: make(chan []byte, 1)}
+	s, addr := startShutdownTestServer(t, h)
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// 收到 pong 之后连接已经被服务端记录
+	framer := NewFramer()
+	_, err = conn.Write(codec.HeartbeatFrame())
+	assert.Nil(t, err)
+	_, err = framer.ReadFrame(conn)
+	assert.Nil(t, err)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	frame, err := framer.ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(codec.GoAwayMsg), codec.MsgType(frame))
+
+	// GOAWAY 之后发出的请求和流不被处理，收到 Unavailable
+	for _, reqType := range []uint8{codec.SendAndRecv, codec.BidiStreamReq} {
+		req, err := codec.DefaultCodec.Encode(nil)
+		assert.Nil(t, err)
+		codec.SetStreamID(req, uint16(reqType)+1)
+		codec.SetReqType(req, reqType)
+		_, err = conn.Write(req)
+		assert.Nil(t, err)
+
+		frame, err = framer.ReadFrame(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, uint16(reqType)+1, codec.StreamID(frame))
+		if codec.IsStream(reqType) {
+			assert.Equal(t, uint8(codec.StreamEndMsg), codec.MsgType(frame))
+		}
+		rspBuf, err := codec.DefaultCodec.Decode(frame)
+		assert.Nil(t, err)
+		rsp := &protocol.Response{}
+		assert.Nil(t, proto.Unmarshal(rspBuf, rsp))
+		assert.Equal(t, uint32(codes.UnavailableErrorCode), rsp.RetCode)
+	}
+	assert.Len(t, h.requests, 0)
+
+	// 宽限期之后空闲连接被关闭
+	assert.Nil(t, <-shutdown)
+	_, err = framer.ReadFrame(conn)
+	assert.NotNil(t, err)
+}
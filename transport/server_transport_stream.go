@@ -0,0 +1,222 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"go.uber.org/zap"
+)
+
+// maxStreamPayloads 每个流缓存的未读消息数，缓存满时暂停读取连接，直到 Handler 读取，压力通过 TCP 传回客户端
+const maxStreamPayloads = 64
+
+// serverStream is the server side of one streaming call on a connection
+type serverStream struct {
+	id       uint16
+	reqType  uint8
+	s        *serverTransport
+	conn     *connWrapper
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	payloads [][]byte      // 已收到但还未读取的消息，最多 maxStreamPayloads 个
+	eof      bool          // 客户端已经关闭发送
+	notify   chan struct{} // 收到新消息时通知读者
+	space    chan struct{} // 读者取走消息时通知等待缓存的 push
+}
+
+// handleStreamFrame dispatches a frame of a streaming call by its stream ID
+func (s *serverTransport) handleStreamFrame(ctx context.Context, conn *connWrapper, frame []byte) {
+
+	id := codec.StreamID(frame)
+
+	conn.streamsMu.Lock()
+	st, ok := conn.streams[id]
+	if !ok {
+		// 流已经结束，丢弃迟到的帧
+		if codec.MsgType(frame) != codec.GeneralMsg {
+			conn.streamsMu.Unlock()
+			return
+		}
+
+		// 服务端正在关闭，不再建立新的流
+		if !conn.acquire() {
+			conn.streamsMu.Unlock()
+			s.reject(ctx, conn, frame)
+			return
+		}
+
+		// 首帧，建立新的流
+		st = &serverStream{
+			id:      id,
+			reqType: codec.ReqType(frame),
+			s:       s,
+			conn:    conn,
+			notify:  make(chan struct{}, 1),
+			space:   make(chan struct{}, 1),
+		}
+		st.ctx, st.cancel = context.WithCancel(ctx)
+		if conn.streams == nil {
+			conn.streams = make(map[uint16]*serverStream)
+		}
+		conn.streams[id] = st
+		conn.streamsMu.Unlock()
+
+		go s.serveStream(st, frame)
+		return
+	}
+	conn.streamsMu.Unlock()
+
+	switch codec.MsgType(frame) {
+	case codec.GeneralMsg:
+		request, err := s.decodeRequest(frame)
+		if err != nil {
+			zap.L().Error("novaRPC decode stream frame error", zap.Error(err))
+			return
+		}
+		st.push(request.Payload)
+	case codec.StreamEndMsg:
+		st.closeRecv()
+	case codec.CancelMsg:
+		st.cancel()
+	}
+}
+
+// serveStream runs the stream handler and sends the final status when it returns
+func (s *serverTransport) serveStream(st *serverStream, frame []byte) {
+
+	defer func() {
+		st.conn.streamsMu.Lock()
+		delete(st.conn.streams, st.id)
+		st.conn.streamsMu.Unlock()
+		st.cancel()
+		st.conn.release()
+	}()
+
+	reqBuf, err := codec.GetCodec(s.opts.Protocol).Decode(frame)
+	if err == nil {
+		if handler, ok := s.opts.Handler.(StreamHandler); ok {
+			err = handler.HandleStream(st.ctx, reqBuf, st)
+		} else {
+			err = codes.NewFrameworkError(codes.UnimplementedErrorCode, "streaming not supported")
+		}
+	}
+
+	if err != nil {
+		zap.L().Error("novaRPC handle stream error", zap.Error(err))
+	}
+
+	if err = st.write(codec.StreamEndMsg, addRspHeader(nil, err)); err != nil {
+		zap.L().Error("novaRPC write stream end error", zap.Error(err))
+	}
+}
+
+func (s *serverTransport) decodeRequest(frame []byte) (*protocol.Request, error) {
+	reqBuf, err := codec.GetCodec(s.opts.Protocol).Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &protocol.Request{}
+	if err = proto.Unmarshal(reqBuf, request); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+func (st *serverStream) Send(payload []byte) error {
+	return st.write(codec.GeneralMsg, addRspHeader(payload, nil))
+}
+
+func (st *serverStream) Recv() ([]byte, error) {
+	for {
+		st.mu.Lock()
+		if len(st.payloads) > 0 {
+			payload := st.payloads[0]
+			st.payloads[0] = nil
+			st.payloads = st.payloads[1:]
+			st.mu.Unlock()
+
+			select {
+			case st.space <- struct{}{}:
+			default:
+			}
+			return payload, nil
+		}
+		if st.eof {
+			st.mu.Unlock()
+			return nil, io.EOF
+		}
+		st.mu.Unlock()
+
+		select {
+		case <-st.notify:
+		case <-st.ctx.Done():
+			return nil, st.ctx.Err()
+		}
+	}
+}
+
+// CloseSend does nothing on the server side, the stream ends when the handler returns
+func (st *serverStream) CloseSend() error {
+	return nil
+}
+
+// push 缓存收到的消息，缓存满时等待 Handler 读取或者流结束，等待期间连接上的其他帧也不会被读取
+func (st *serverStream) push(payload []byte) {
+	st.mu.Lock()
+	for len(st.payloads) >= maxStreamPayloads {
+		st.mu.Unlock()
+		select {
+		case <-st.space:
+		case <-st.ctx.Done():
+			return
+		}
+		st.mu.Lock()
+	}
+	st.payloads = append(st.payloads, payload)
+	st.mu.Unlock()
+	st.wakeup()
+}
+
+func (st *serverStream) closeRecv() {
+	st.mu.Lock()
+	st.eof = true
+	st.mu.Unlock()
+	st.wakeup()
+}
+
+func (st *serverStream) wakeup() {
+	select {
+	case st.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (st *serverStream) write(msgType uint8, response *protocol.Response) error {
+	rspPb, err := proto.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	frame, err := codec.GetCodec(st.s.opts.Protocol).Encode(rspPb)
+	if err != nil {
+		return err
+	}
+
+	if frame, err = codec.CompressFrame(frame, st.s.opts.CompressType, st.s.opts.CompressThreshold); err != nil {
+		return err
+	}
+
+	codec.SetStreamID(frame, st.id)
+	codec.SetReqType(frame, st.reqType)
+	codec.SetMsgType(frame, msgType)
+
+	return st.s.write(st.ctx, st.conn, frame)
+}
@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/selector"
+	"github.com/xing-you-ji/novarpc/stream"
+)
+
+// slowStreamHandler reads nothing until release is closed, then replies with the number of messages received
+type slowStreamHandler struct {
+	streams chan *serverStream
+	release chan struct{}
+}
+
+func (h *slowStreamHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (h *slowStreamHandler) HandleStream(ctx context.Context, req []byte, st stream.Transport) error {
+	h.streams <- st.(*serverStream)
+	<-h.release
+
+	n := 0
+	for {
+		_, err := st.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	return st.Send([]byte(strconv.Itoa(n)))
+}
+
+func TestServerStreamBackpressure(t *testing.T) {
+	h := &slowStreamHandler{streams: make(chan *serverStream, 1), release: make(chan struct{})}
+	s, addr := startShutdownTestServer(t, h)
+	defer s.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := codec.DefaultCodec.Encode(nil)
+	assert.Nil(t, err)
+	cs, err := DefaultClientTransport.(ClientStreamTransport).NewStream(ctx, req, WithClientTarget(addr),
+		WithClientNetwork("tcp"), WithSelector(selector.DefaultSelector), WithReqType(codec.ClientStreamReq))
+	assert.Nil(t, err)
+
+	total := maxStreamPayloads + 10
+	for i := 0; i < total; i++ {
+		assert.Nil(t, cs.Send([]byte("hello")))
+	}
+	assert.Nil(t, cs.CloseSend())
+
+	// 缓存满了之后不再读取连接，剩下的消息留在 TCP 缓冲区中
+	st := <-h.streams
+	buffered := func() int {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		return len(st.payloads)
+	}
+	assert.Eventually(t, func() bool { return buffered() == maxStreamPayloads }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, maxStreamPayloads, buffered())
+
+	// Handler 读取之后收到所有消息
+	close(h.release)
+	rsp, err := cs.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, strconv.Itoa(total), string(rsp))
+	_, err = cs.Recv()
+	assert.Equal(t, io.EOF, err)
+}
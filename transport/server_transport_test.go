@@ -1,9 +1,29 @@
 package transport
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/xing-you-ji/novarpc/auth"
+	"github.com/xing-you-ji/novarpc/codec"
+	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/metadata"
+	"github.com/xing-you-ji/novarpc/pool/connpool"
+	"github.com/xing-you-ji/novarpc/protocol"
+	"github.com/xing-you-ji/novarpc/selector"
 )
 
 var NewTestServerTransport = func() ServerTransport {
@@ -20,3 +40,346 @@ func TestGetServerTransport(t *testing.T) {
 	serverTransport = GetServerTransport("test")
 	assert.Equal(t, serverTransport, DefaultServerTransport)
 }
+
+func TestAddRspHeader(t *testing.T) {
+	rsp := addRspHeader(nil, codes.New(1001, "user not found"))
+	assert.Equal(t, uint32(1001), rsp.RetCode)
+	assert.Equal(t, "user not found", rsp.RetMsg)
+	assert.Equal(t, uint32(codes.BusyError), rsp.RetType)
+
+	rsp = addRspHeader(nil, codes.ConfigError)
+	assert.Equal(t, uint32(codes.ConfigErrorCode), rsp.RetCode)
+	assert.Equal(t, uint32(codes.FrameworkError), rsp.RetType)
+
+	rsp = addRspHeader(nil, errors.New("unknown"))
+	assert.Equal(t, uint32(codes.ServerInternalErrorCode), rsp.RetCode)
+	assert.Equal(t, uint32(codes.FrameworkError), rsp.RetType)
+
+	// 上下文错误映射到标准错误码
+	rsp = addRspHeader(nil, context.DeadlineExceeded)
+	assert.Equal(t, uint32(codes.DeadlineExceededErrorCode), rsp.RetCode)
+	assert.Equal(t, uint32(codes.FrameworkError), rsp.RetType)
+
+	// 错误详情随响应返回
+	rsp = addRspHeader(nil, codes.New(1001, "user not found").WithDetails(&protocol.Request{ServicePath: "/user"}))
+	assert.Equal(t, 1, len(rsp.Details))
+	assert.Equal(t, "protocol.Request", rsp.Details[0].Type)
+
+	rsp = addRspHeader([]byte("ok"), nil)
+	assert.Equal(t, uint32(codes.OK), rsp.RetCode)
+	assert.Equal(t, []byte("ok"), rsp.Payload)
+
+	// 值为 nil 的 *codes.Error 表示成功
+	var e *codes.Error
+	rsp = addRspHeader([]byte("ok"), e)
+	assert.Equal(t, uint32(codes.OK), rsp.RetCode)
+	assert.Equal(t, []byte("ok"), rsp.Payload)
+}
+
+// newTestCert issues a certificate for commonName, signed by parent or self-signed when parent is nil
+func newTestCert(t *testing.T, commonName string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	issuer, signer := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	assert.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// peerHandler answers with the common name of the client certificate
+type peerHandler struct{}
+
+func (h *peerHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	p, ok := auth.GetPeer(ctx)
+	if !ok {
+		return nil, errors.New("no peer")
+	}
+	info, ok := p.AuthInfo.(auth.TLSInfo)
+	if !ok {
+		return nil, errors.New("not tls")
+	}
+	return proto.Marshal(&protocol.Request{ServicePath: info.State.PeerCertificates[0].Subject.CommonName})
+}
+
+func TestServerTransportAuth(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil)
+	serverCert := newTestCert(t, "server", &ca)
+	clientCert := newTestCert(t, "client-a", &ca)
+
+	cp := x509.NewCertPool()
+	cp.AddCert(ca.Leaf)
+
+	s := NewServerTransport().(*serverTransport)
+	err := s.ListenAndServe(context.Background(), WithServerAddress("127.0.0.1:0"),
+		WithServerNetwork("tcp"), WithHandler(&peerHandler{}),
+		WithServerTransportAuth(auth.NewServerTLSAuth(&tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    cp,
+		})))
+	assert.Nil(t, err)
+	defer s.Shutdown(context.Background())
+
+	s.mu.Lock()
+	addr := s.listeners[0].Addr().String()
+	s.mu.Unlock()
+
+	clientAuth := auth.NewClientTLSAuth(&tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      cp,
+	})
+
+	for _, multiplexed := range []bool{false, true} {
+		req, err := codec.DefaultCodec.Encode([]byte{})
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		frame, err := DefaultClientTransport.Send(ctx, req, WithClientTarget(addr), WithClientNetwork("tcp"),
+			WithSelector(selector.DefaultSelector), WithClientPool(connpool.GetPool("default")),
+			WithMultiplexed(multiplexed), WithClientTransportAuth(clientAuth))
+		cancel()
+		assert.Nil(t, err)
+
+		rspBuf, err := codec.DefaultCodec.Decode(frame)
+		assert.Nil(t, err)
+		rsp := &protocol.Response{}
+		assert.Nil(t, proto.Unmarshal(rspBuf, rsp))
+		assert.Equal(t, uint32(codes.OK), rsp.RetCode)
+
+		peer := &protocol.Request{}
+		assert.Nil(t, proto.Unmarshal(rsp.Payload, peer))
+		assert.Equal(t, "client-a", peer.ServicePath)
+	}
+
+	// 明文客户端无法完成握手
+	req, err := codec.DefaultCodec.Encode([]byte{})
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = DefaultClientTransport.Send(ctx, req, WithClientTarget(addr), WithClientNetwork("tcp"),
+		WithSelector(selector.DefaultSelector), WithMultiplexed(true))
+	assert.NotNil(t, err)
+}
+
+type echoHandler struct {
+	received []byte
+}
+
+func (h *echoHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	request := &protocol.Request{}
+	if err := proto.Unmarshal(req, request); err != nil {
+		return nil, err
+	}
+	h.received = request.Payload
+	return request.Payload, nil
+}
+
+func TestServerTransportCompress(t *testing.T) {
+	h := &echoHandler{}
+	s := NewServerTransport().(*serverTransport)
+	err := s.ListenAndServe(context.Background(), WithServerAddress("127.0.0.1:0"),
+		WithServerNetwork("tcp"), WithHandler(h),
+		WithServerCompressType(codec.CompressTypeZstd), WithServerCompressThreshold(codec.DefaultCompressThreshold))
+	assert.Nil(t, err)
+	defer s.Shutdown(context.Background())
+
+	s.mu.Lock()
+	addr := s.listeners[0].Addr().String()
+	s.mu.Unlock()
+
+	payload := bytes.Repeat([]byte("novarpc batch export "), 4096)
+	reqBuf, err := proto.Marshal(&protocol.Request{Payload: payload})
+	assert.Nil(t, err)
+	req, err := codec.DefaultCodec.Encode(reqBuf)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	frame, err := DefaultClientTransport.Send(ctx, req, WithClientTarget(addr), WithClientNetwork("tcp"),
+		WithSelector(selector.DefaultSelector), WithMultiplexed(true),
+		WithClientCompressType(codec.CompressTypeGzip), WithClientCompressThreshold(codec.DefaultCompressThreshold))
+	assert.Nil(t, err)
+	assert.Equal(t, payload, h.received)
+
+	// 响应按服务端的设置压缩
+	assert.Equal(t, uint8(codec.CompressTypeZstd), codec.CompressType(frame))
+	assert.True(t, len(frame) < len(payload))
+
+	rspBuf, err := codec.DefaultCodec.Decode(frame)
+	assert.Nil(t, err)
+	rsp := &protocol.Response{}
+	assert.Nil(t, proto.Unmarshal(rspBuf, rsp))
+	assert.Equal(t, payload, rsp.Payload)
+}
+
+type failHandler struct{}
+
+func (h *failHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	return nil, errors.New("heartbeats must not reach the handler")
+}
+
+func TestServerTransportHeartbeat(t *testing.T) {
+	s, addr := startShutdownTestServer(t, &failHandler{})
+	defer s.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	ping := codec.HeartbeatFrame()
+	codec.SetStreamID(ping, 9)
+	_, err = conn.Write(ping)
+	assert.Nil(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	pong, err := NewFramer().ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(codec.HeartbeatMsg), codec.MsgType(pong))
+	assert.Equal(t, uint16(9), codec.StreamID(pong))
+}
+
+type recordHandler struct {
+	requests chan []byte
+}
+
+func (h *recordHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	h.requests <- req
+	return []byte("ignored"), nil
+}
+
+func TestServerTransportOneWay(t *testing.T) {
+	h := &recordHandler{requests: make(chan []byte, 1)}
+
+	// 找一个空闲端口，tcp 和 udp 使用同一个端口
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	tcp := NewServerTransport().(*serverTransport)
+	assert.Nil(t, tcp.ListenAndServe(context.Background(), WithServerAddress(addr),
+		WithServerNetwork("tcp"), WithHandler(h)))
+	defer tcp.Shutdown(context.Background())
+
+	udp := NewServerTransport().(*serverTransport)
+	go udp.ListenAndServe(context.Background(), WithServerAddress(addr),
+		WithServerNetwork("udp"), WithHandler(h))
+	defer udp.Shutdown(context.Background())
+	time.Sleep(50 * time.Millisecond)
+
+	for _, network := range []string{"tcp", "udp"} {
+		for _, multiplexed := range []bool{false, true} {
+			req, err := codec.DefaultCodec.Encode([]byte(network))
+			assert.Nil(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			rsp, err := DefaultClientTransport.Send(ctx, req, WithClientTarget(addr), WithClientNetwork(network),
+				WithSelector(selector.DefaultSelector), WithClientPool(connpool.GetPool("default")),
+				WithMultiplexed(multiplexed), WithReqType(codec.SendOnly))
+			cancel()
+			assert.Nil(t, err)
+			assert.Nil(t, rsp)
+
+			select {
+			case got := <-h.requests:
+				assert.Equal(t, network, string(got))
+			case <-time.After(time.Second):
+				t.Fatalf("one-way request over %s was not handled", network)
+			}
+		}
+	}
+}
+
+// cancelHandler blocks until the request is cancelled
+type cancelHandler struct {
+	cancelled chan error
+}
+
+func (h *cancelHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		h.cancelled <- ctx.Err()
+	case <-time.After(5 * time.Second):
+		h.cancelled <- nil
+	}
+	return nil, ctx.Err()
+}
+
+func TestServerTransportCancel(t *testing.T) {
+	h := &cancelHandler{cancelled: make(chan error, 1)}
+	s, addr := startShutdownTestServer(t, h)
+	defer s.Shutdown(context.Background())
+
+	for _, multiplexed := range []bool{false, true} {
+		req, err := codec.DefaultCodec.Encode(nil)
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, err = DefaultClientTransport.Send(ctx, req, WithClientTarget(addr), WithClientNetwork("tcp"),
+			WithSelector(selector.DefaultSelector), WithClientPool(connpool.GetPool("default")),
+			WithMultiplexed(multiplexed))
+		cancel()
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		// 服务端收到取消帧后取消 Handler
+		select {
+		case err := <-h.cancelled:
+			assert.Equal(t, context.Canceled, err)
+		case <-time.After(time.Second):
+			t.Fatal("handler was not cancelled")
+		}
+	}
+}
+
+type trailerHandler struct{}
+
+func (h *trailerHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	metadata.SetResponseMetadata(ctx, "cursor", []byte("42"))
+	return nil, codes.New(1001, "rate limited")
+}
+
+func TestServerTransportResponseMetadata(t *testing.T) {
+	s, addr := startShutdownTestServer(t, &trailerHandler{})
+	defer s.Shutdown(context.Background())
+
+	reqBuf, err := proto.Marshal(&protocol.Request{})
+	assert.Nil(t, err)
+	req, err := codec.DefaultCodec.Encode(reqBuf)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	frame, err := DefaultClientTransport.Send(ctx, req, WithClientTarget(addr), WithClientNetwork("tcp"),
+		WithSelector(selector.DefaultSelector), WithMultiplexed(true))
+	assert.Nil(t, err)
+
+	rspBuf, err := codec.DefaultCodec.Decode(frame)
+	assert.Nil(t, err)
+	rsp := &protocol.Response{}
+	assert.Nil(t, proto.Unmarshal(rspBuf, rsp))
+
+	// 出错时同样返回元数据
+	assert.Equal(t, uint32(1001), rsp.RetCode)
+	assert.Equal(t, "42", string(rsp.Metadata["cursor"]))
+}
@@ -2,21 +2,35 @@ package transport
 
 import (
 	"context"
+	"errors"
+	"github.com/xing-you-ji/novarpc/codec"
 	"github.com/xing-you-ji/novarpc/stream"
 	"go.uber.org/zap"
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
 func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerTransportOption) error {
 
 	conn, err := net.ListenPacket(s.opts.Network, s.opts.Address)
-	defer conn.Close()
-
-	buffer := make([]byte, 65536)
 	if err != nil {
 		return err
 	}
+	// 优雅关闭时由 Shutdown 在请求处理完成后关闭
+	stopped := false
+	defer func() {
+		if !stopped {
+			conn.Close()
+		}
+	}()
+
+	if !s.trackPacketConn(conn) {
+		return ErrServerShutdown
+	}
+
+	buffer := make([]byte, 65536)
 
 	var tempDelay time.Duration
 
@@ -30,6 +44,12 @@ func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerT
 
 		num, addr, err := conn.ReadFrom(buffer)
 		if err != nil {
+			// 优雅关闭，停止读取新的请求
+			if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, net.ErrClosed) {
+				stopped = true
+				return nil
+			}
+
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -45,9 +65,13 @@ func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerT
 			return err
 		}
 
-		req := buffer[:num]
+		// buffer 会被下一次读取复用
+		req := make([]byte, num)
+		copy(req, buffer[:num])
 
+		atomic.AddInt32(&s.udpActive, 1)
 		go func() {
+			defer atomic.AddInt32(&s.udpActive, -1)
 
 			// build stream
 			ctx, _ := stream.NewServerStream(ctx)
@@ -65,11 +89,22 @@ func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerT
 
 func (s *serverTransport) handleUdpConn(ctx context.Context, conn net.PacketConn, addr net.Addr, req []byte) error {
 
+	// 心跳原样回复
+	if codec.MsgType(req) == codec.HeartbeatMsg {
+		_, err := conn.WriteTo(req, addr)
+		return err
+	}
+
 	rsp, err := s.handle(ctx, req)
 	if err != nil {
 		return err
 	}
 
+	// 单向调用不回复
+	if rsp == nil {
+		return nil
+	}
+
 	_, err = conn.WriteTo(rsp, addr)
 	return err
 }
@@ -10,12 +10,13 @@ import (
 
 	"github.com/xing-you-ji/novarpc/codec"
 	"github.com/xing-you-ji/novarpc/codes"
+	"github.com/xing-you-ji/novarpc/stream"
 )
 
 const DefaultPayloadLength = 1024
 
-// 定义了一个最大的数据包长度，4M
-const MaxPayloadLength = 4 * 1024 * 1024
+// 定义了一个最大的数据包长度，4M，压缩的数据包解压后同样不能超过
+const MaxPayloadLength = codec.MaxPayloadLength
 
 // ServerTransport 提供一种监听和处理请求的机制，实现成接口，主要是为了实现可插拔，支持业务自定义（比如支持HTTP协议）
 type ServerTransport interface {
@@ -23,12 +24,33 @@ type ServerTransport interface {
 	ListenAndServe(context.Context, ...ServerTransportOption) error
 }
 
+// GracefulServerTransport 是支持优雅关闭的 ServerTransport：停止接收新连接，等待正在处理的请求完成后再关闭连接
+type GracefulServerTransport interface {
+	ServerTransport
+	// stop accepting, drain in-flight requests until the context is done, then close connections
+	Shutdown(context.Context) error
+}
+
+// ServerTransportCloner 是保存了每个服务自己状态的 ServerTransport，例如监听和连接，
+// 每个 Server 使用注册的 transport 的副本，关闭一个 Server 不影响同一个进程中的其他 Server
+type ServerTransportCloner interface {
+	ServerTransport
+	// returns a transport with the same options and no listeners or connections
+	Clone() ServerTransport
+}
+
 // Send 这个方法主要是用来发起请求调用，传参除了上下文 context 之外，还有二进制的请求包 request，返回是一个二进制的完整数据帧。这里设计成 interface 接口的形式，同样是为了可插拔、支持业务自定义
 type ClientTransport interface {
 	// send requests
 	Send(context.Context, []byte, ...ClientTransportOption) ([]byte, error)
 }
 
+// ClientStreamTransport opens streaming calls, the default ClientTransport implements it
+type ClientStreamTransport interface {
+	// open a stream, the request frame carries the service path and metadata
+	NewStream(context.Context, []byte, ...ClientTransportOption) (stream.Transport, error)
+}
+
 // Framer defines the reading of data frames from a data stream
 type Framer interface {
 	// read a full frame